@@ -0,0 +1,338 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/whoisnian/glb/httpd"
+	"github.com/whoisnian/glb/logger"
+)
+
+const adminPageRawTemplate = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8" />
+  <meta name="viewport" content="width=device-width, initial-scale=1" />
+  <title>Mock CAS Admin</title>
+  <style>
+    table { border-collapse: collapse; margin-bottom: 1em; }
+    th, td { border: 1px solid #999; padding: 2px 8px; text-align: left; vertical-align: top; }
+  </style>
+</head>
+<body style="font-family:ui-monospace,Menlo,Consolas,Hack,Liberation Mono,Microsoft Yahei,Noto Sans Mono CJK SC,sans-serif;">
+  <h1>Mock CAS Admin</h1>
+  <h2>Sessions <button onclick="refresh()">refresh</button></h2>
+  <table><thead><tr><th>user</th><th>login at</th><th>ticket granting ticket</th><th>tickets</th><th></th></tr></thead><tbody id="sessions"></tbody></table>
+  <h2>Mint Ticket</h2>
+  <form id="mint">
+    <input name="username" placeholder="username" required>
+    <input name="service" placeholder="service (optional)" size="50">
+    <input type="submit" value="Mint">
+  </form>
+  <pre id="minted"></pre>
+  <h2>Fault Scenario <select id="scenarios"><option value="">none</option></select></h2>
+  <pre id="fault"></pre>
+  <h2>Recent Validation Failures</h2>
+  <table><thead><tr><th>time</th><th>endpoint</th><th>ticket</th><th>service</th><th>code</th><th>description</th></tr></thead><tbody id="failures"></tbody></table>
+  <script>
+    function cell(tr, text) { const td = tr.insertCell(); td.textContent = text; return td; }
+    async function api(method, path, form) {
+      const resp = await fetch(path, { method: method, headers: { "X-Requested-With": "fetch" }, body: form ? new URLSearchParams(form) : undefined });
+      return resp.json();
+    }
+    async function refresh() {
+      const sessions = document.getElementById("sessions"), failures = document.getElementById("failures");
+      sessions.replaceChildren();
+      for (const s of await api("GET", "/admin/api/sessions")) {
+        const tr = sessions.insertRow();
+        cell(tr, s.username); cell(tr, s.authTime); cell(tr, s.ticket);
+        cell(tr, (s.members || []).map(m => m.ticket + " " + (m.service || "")).join("\n")).style.whiteSpace = "pre";
+        const btn = document.createElement("button");
+        btn.textContent = "logout user";
+        btn.onclick = async () => { await api("POST", "/admin/api/logout", { username: s.username }); refresh(); };
+        tr.insertCell().appendChild(btn);
+      }
+      failures.replaceChildren();
+      for (const f of await api("GET", "/admin/api/failures")) {
+        const tr = failures.insertRow();
+        [f.time, f.endpoint, f.ticket, f.service, f.code, f.description].forEach(v => cell(tr, v));
+      }
+    }
+    async function showFault(status) {
+      const select = document.getElementById("scenarios");
+      select.replaceChildren(select.options[0]);
+      for (const name of status.scenarios || []) select.add(new Option(name, name, false, name === status.active));
+      document.getElementById("fault").textContent = JSON.stringify(status.scenario, null, 2);
+    }
+    document.getElementById("scenarios").onchange = async (e) => {
+      const name = e.target.value;
+      showFault(await (name ? api("POST", "/admin/api/fault", { scenario: name }) : api("DELETE", "/admin/api/fault")));
+    };
+    document.getElementById("mint").onsubmit = async (e) => {
+      e.preventDefault();
+      const result = await api("POST", "/admin/api/tickets", new FormData(e.target));
+      document.getElementById("minted").textContent = JSON.stringify(result, null, 2);
+      refresh();
+    };
+    refresh();
+    api("GET", "/admin/api/fault").then(showFault);
+  </script>
+</body>
+</html>`
+
+// ValidationFailure is a failed ticket validation kept for admin console.
+type ValidationFailure struct {
+	Time        time.Time `json:"time"`
+	Endpoint    string    `json:"endpoint"`
+	Ticket      string    `json:"ticket"`
+	Service     string    `json:"service"`
+	Code        string    `json:"code"`
+	Description string    `json:"description"`
+}
+
+// FailureRecorder keeps the most recent failures in a ring buffer.
+type FailureRecorder struct {
+	mu       sync.Mutex
+	failures []ValidationFailure
+	next     int
+}
+
+func NewFailureRecorder(size int) *FailureRecorder {
+	return &FailureRecorder{failures: make([]ValidationFailure, 0, size)}
+}
+
+func (r *FailureRecorder) Record(f ValidationFailure) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.failures) < cap(r.failures) {
+		r.failures = append(r.failures, f)
+	} else {
+		r.failures[r.next] = f
+	}
+	r.next = (r.next + 1) % cap(r.failures)
+}
+
+// List returns recorded failures, the most recent first.
+func (r *FailureRecorder) List() []ValidationFailure {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]ValidationFailure, 0, len(r.failures))
+	for i := range len(r.failures) {
+		result = append(result, r.failures[(r.next-1-i+2*len(r.failures))%len(r.failures)])
+	}
+	return result
+}
+
+var recentFailures = NewFailureRecorder(100)
+
+func recordValidationFailure(r *http.Request, ticket, service, code, desc string) {
+	recentFailures.Record(ValidationFailure{
+		Time:        time.Now(),
+		Endpoint:    r.URL.Path,
+		Ticket:      ticket,
+		Service:     service,
+		Code:        code,
+		Description: desc,
+	})
+}
+
+type AdminError struct {
+	Error string `json:"error"`
+}
+
+// adminAuth wraps handler with basic authentication, the username is 'admin' and the password is from config.
+// Browsers resend cached basic auth credentials, so requests changing state are also checked against CSRF.
+func adminAuth(handler func(*httpd.Store)) func(*httpd.Store) {
+	return func(store *httpd.Store) {
+		username, password, ok := store.R.BasicAuth()
+		if !ok || username != "admin" || subtle.ConstantTimeCompare([]byte(password), []byte(CFG.AdminPassword)) != 1 {
+			store.W.Header().Set("WWW-Authenticate", `Basic realm="mockcas admin"`)
+			http.Error(store.W, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if store.R.Method != http.MethodGet && store.R.Method != http.MethodHead && !isNonSimpleRequest(store.R) {
+			store.RespondJson(http.StatusForbidden, AdminError{"X-Requested-With header or JSON content type is required"})
+			return
+		}
+		handler(store)
+	}
+}
+
+// isNonSimpleRequest reports whether r cannot be sent by cross-site html forms without CORS preflight.
+func isNonSimpleRequest(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return r.Header.Get("X-Requested-With") != "" || mediaType == "application/json"
+}
+
+func adminPageHandler(store *httpd.Store) {
+	store.W.Header().Set("Content-Type", "text/html; charset=utf-8")
+	store.Respond200([]byte(adminPageRawTemplate))
+}
+
+func adminSessionsHandler(store *httpd.Store) {
+	sessions, err := TP.ListSessions(store.R.Context())
+	if err != nil {
+		LOG.Error(store.R.Context(), "list sessions error", logger.Error(err))
+		store.RespondJson(http.StatusInternalServerError, AdminError{"list sessions error: " + err.Error()})
+		return
+	}
+	store.RespondJson(http.StatusOK, sessions)
+}
+
+// adminLogoutHandler destroys all sessions of user, and single logout requests are sent by SLO dispatcher.
+func adminLogoutHandler(store *httpd.Store) {
+	username := store.R.FormValue("username")
+	if username == "" {
+		store.RespondJson(http.StatusBadRequest, AdminError{"username is empty"})
+		return
+	}
+	sessions, err := TP.ListSessions(store.R.Context())
+	if err != nil {
+		LOG.Error(store.R.Context(), "list sessions error", logger.Error(err))
+		store.RespondJson(http.StatusInternalServerError, AdminError{"list sessions error: " + err.Error()})
+		return
+	}
+	var result struct {
+		Sessions int `json:"sessions"`
+		Notified int `json:"notified"`
+	}
+	for _, session := range sessions {
+		if session.Username != username {
+			continue
+		}
+		notices := destroySession(store.R.Context(), session.Ticket)
+		if SLO != nil {
+			for _, notice := range notices {
+				SLO.Dispatch(store.R.Context(), notice)
+			}
+			result.Notified += len(notices)
+		}
+		result.Sessions++
+	}
+	LOG.Infof(store.R.Context(), "admin force logout user %s: %d sessions", username, result.Sessions)
+	store.RespondJson(http.StatusOK, result)
+}
+
+// adminTicketsHandler mints a ticket granting ticket for user without password, and also a service ticket if service is specified.
+func adminTicketsHandler(store *httpd.Store) {
+	username := store.R.FormValue("username")
+	service := store.R.FormValue("service")
+	if username == "" {
+		store.RespondJson(http.StatusBadRequest, AdminError{"username is empty"})
+		return
+	}
+	user, err := UP.FindUser(store.R.Context(), username)
+	if errors.Is(err, UserNotFoundError) {
+		store.RespondJson(http.StatusNotFound, AdminError{"user " + username + " not found"})
+		return
+	} else if err != nil {
+		store.RespondJson(http.StatusBadRequest, AdminError{"find user error: " + err.Error()})
+		return
+	}
+	if err = CheckLoginAllowed(user); err != nil {
+		store.RespondJson(http.StatusForbidden, AdminError{"user " + username + " is not allowed to login"})
+		return
+	}
+	if service != "" {
+		if _, err = SR.CheckAccess(service, user); err != nil {
+			store.RespondJson(http.StatusForbidden, AdminError{"user " + username + " cannot access service " + service + ": " + err.Error()})
+			return
+		}
+	}
+
+	var result struct {
+		TicketGrantingTicket string `json:"ticketGrantingTicket"`
+		ServiceTicket        string `json:"serviceTicket,omitempty"`
+	}
+	if result.TicketGrantingTicket, err = TP.GenerateTicketGrantingTicket(store.R.Context(), username); err != nil {
+		LOG.Error(store.R.Context(), "generate ticket granting ticket error", logger.Error(err))
+		store.RespondJson(http.StatusInternalServerError, AdminError{"generate ticket granting ticket error"})
+		return
+	}
+	if service != "" {
+		if result.ServiceTicket, err = TP.GenerateServiceTicket(store.R.Context(), result.TicketGrantingTicket, service, true); err != nil {
+			LOG.Error(store.R.Context(), "generate service ticket error", logger.Error(err))
+			store.RespondJson(http.StatusInternalServerError, AdminError{"generate service ticket error"})
+			return
+		}
+		if err = TP.BindTicketToGroup(store.R.Context(), result.TicketGrantingTicket, result.ServiceTicket, service); err != nil {
+			LOG.Warnf(store.R.Context(), "bind ticket to group error: %v", err)
+		}
+	}
+	store.RespondJson(http.StatusOK, result)
+}
+
+func adminFailuresHandler(store *httpd.Store) {
+	store.RespondJson(http.StatusOK, recentFailures.List())
+}
+
+// adminCacheHandler invalidates cached user of 'username' parameter, or all cached users if it is empty.
+func adminCacheHandler(store *httpd.Store) {
+	p, ok := UP.(*LdapUserProvider)
+	if !ok {
+		store.RespondJson(http.StatusNotFound, AdminError{"user provider has no cache"})
+		return
+	}
+	username := store.R.FormValue("username")
+	n := p.InvalidateCache(username)
+	LOG.Infof(store.R.Context(), "admin invalidate %d cached users", n)
+	store.RespondJson(http.StatusOK, map[string]int{"invalidated": n})
+}
+
+type FaultStatus struct {
+	Active    string         `json:"active"` // name of the active scenario, empty if it is set by API or disabled
+	Scenario  *FaultScenario `json:"scenario"`
+	Scenarios []string       `json:"scenarios"`
+}
+
+func respondFaultStatus(store *httpd.Store) {
+	var status FaultStatus
+	status.Active, status.Scenario, status.Scenarios = FI.Status()
+	store.RespondJson(http.StatusOK, status)
+}
+
+func adminFaultHandler(store *httpd.Store) {
+	respondFaultStatus(store)
+}
+
+// adminFaultActivateHandler switches to the named scenario from fault scenarios file.
+func adminFaultActivateHandler(store *httpd.Store) {
+	name := store.R.FormValue("scenario")
+	if err := FI.Activate(name); err != nil {
+		store.RespondJson(http.StatusNotFound, AdminError{err.Error()})
+		return
+	}
+	LOG.Warnf(store.R.Context(), "fault scenario %s is active", name)
+	respondFaultStatus(store)
+}
+
+// adminFaultSetHandler switches to the scenario in JSON request body.
+func adminFaultSetHandler(store *httpd.Store) {
+	var sc FaultScenario
+	dec := json.NewDecoder(io.LimitReader(store.R.Body, 64*1024))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&sc); err != nil {
+		store.RespondJson(http.StatusBadRequest, AdminError{"decode fault scenario error: " + err.Error()})
+		return
+	}
+	if err := FI.Set(&sc); err != nil {
+		store.RespondJson(http.StatusBadRequest, AdminError{"invalid fault scenario: " + err.Error()})
+		return
+	}
+	LOG.Warnf(store.R.Context(), "custom fault scenario is active")
+	respondFaultStatus(store)
+}
+
+func adminFaultResetHandler(store *httpd.Store) {
+	FI.Set(nil)
+	LOG.Infof(store.R.Context(), "fault injection is disabled")
+	respondFaultStatus(store)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func adminRequest(t *testing.T, method, rawUrl string, form url.Values, result any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, rawUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("new request error: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Requested-With", "test")
+	req.SetBasicAuth("admin", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s error: %v", method, rawUrl, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && result != nil {
+		if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+			t.Fatalf("decode response of %s error: %v", rawUrl, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestAdminApi(t *testing.T) {
+	srv := setupTestServer(t)
+	password := CFG.AdminPassword
+	t.Cleanup(func() { CFG.AdminPassword, SLO = password, nil })
+	CFG.AdminPassword = "secret"
+
+	receiver, received, _ := newLogoutReceiver(t, 0)
+	logoutUrl := CFG.CasClientLogoutUrl
+	t.Cleanup(func() { CFG.CasClientLogoutUrl = logoutUrl })
+	CFG.CasClientLogoutUrl = receiver.URL
+	ctx, cancel := context.WithCancel(context.Background())
+	SLO = NewSingleLogoutDispatcher(1, 0, 0)
+	SLO.Start(ctx)
+	defer func() { cancel(); SLO.Wait() }()
+
+	resp, err := http.Get(srv.URL + "/admin/api/sessions")
+	if err != nil {
+		t.Fatalf("sessions request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("sessions without auth got %d, want 401", resp.StatusCode)
+	}
+
+	// form posted from another site is rejected even with cached basic auth credentials
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/admin/api/tickets", strings.NewReader(url.Values{"username": {"casuser"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("admin", "secret")
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatalf("tickets request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("mint ticket without X-Requested-With got %d, want 403", resp.StatusCode)
+	}
+	if sessions, _ := TP.ListSessions(t.Context()); len(sessions) != 0 {
+		t.Fatalf("mint ticket without X-Requested-With created sessions %+v", sessions)
+	}
+
+	// mint tickets for service, one is validated and the other is kept for single logout
+	service := "https://app.example.com/"
+	var minted struct {
+		TicketGrantingTicket string `json:"ticketGrantingTicket"`
+		ServiceTicket        string `json:"serviceTicket"`
+	}
+	if code := adminRequest(t, http.MethodPost, srv.URL+"/admin/api/tickets", url.Values{"username": {"casuser"}, "service": {service}}, &minted); code != http.StatusOK {
+		t.Fatalf("mint ticket got %d", code)
+	}
+	validateUrl := srv.URL + "/cas/p3/serviceValidate?" + url.Values{"ticket": {minted.ServiceTicket}, "service": {service}, "format": {"JSON"}}.Encode()
+	if resp := getServiceResponse(t, validateUrl); resp.ServiceResponse.AuthenticationSuccess == nil || resp.ServiceResponse.AuthenticationSuccess.User != "casuser" {
+		t.Fatalf("validate minted ticket got %+v", resp.ServiceResponse)
+	}
+	if code := adminRequest(t, http.MethodPost, srv.URL+"/admin/api/tickets", url.Values{"username": {"nobody"}}, nil); code != http.StatusNotFound {
+		t.Fatalf("mint ticket for unknown user got %d, want 404", code)
+	}
+
+	var sessions []Session
+	adminRequest(t, http.MethodGet, srv.URL+"/admin/api/sessions", nil, &sessions)
+	if len(sessions) != 1 || sessions[0].Ticket != minted.TicketGrantingTicket || len(sessions[0].Members) != 1 || sessions[0].Members[0].Service != service {
+		t.Fatalf("unexpected sessions %+v", sessions)
+	}
+
+	// validating the consumed ticket again is recorded as failure
+	getServiceResponse(t, validateUrl)
+	var failures []ValidationFailure
+	adminRequest(t, http.MethodGet, srv.URL+"/admin/api/failures", nil, &failures)
+	if len(failures) == 0 || failures[0].Ticket != minted.ServiceTicket || failures[0].Code != "INVALID_TICKET" {
+		t.Fatalf("unexpected failures %+v", failures)
+	}
+
+	var result struct {
+		Sessions int `json:"sessions"`
+		Notified int `json:"notified"`
+	}
+	adminRequest(t, http.MethodPost, srv.URL+"/admin/api/logout", url.Values{"username": {"casuser"}}, &result)
+	if result.Sessions != 1 || result.Notified != 1 {
+		t.Fatalf("force logout got %+v", result)
+	}
+	select {
+	case req := <-received:
+		if req.SessionIndex != minted.ServiceTicket {
+			t.Fatalf("received logout request for %s, want %s", req.SessionIndex, minted.ServiceTicket)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("logout request is not received")
+	}
+	sessions = nil
+	adminRequest(t, http.MethodGet, srv.URL+"/admin/api/sessions", nil, &sessions)
+	if len(sessions) != 0 {
+		t.Fatalf("sessions after force logout: %+v", sessions)
+	}
+}
+
+func TestFailureRecorder(t *testing.T) {
+	r := NewFailureRecorder(3)
+	for _, ticket := range []string{"ST-1", "ST-2", "ST-3", "ST-4"} {
+		r.Record(ValidationFailure{Ticket: ticket})
+	}
+	var got []string
+	for _, f := range r.List() {
+		got = append(got, f.Ticket)
+	}
+	if strings.Join(got, ",") != "ST-4,ST-3,ST-2" {
+		t.Fatalf("got %v, want most recent 3 failures in reverse order", got)
+	}
+}
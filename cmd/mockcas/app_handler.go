@@ -5,22 +5,31 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"sync"
 
 	"github.com/whoisnian/glb/httpd"
 	"github.com/whoisnian/glb/logger"
 )
 
+// appLoginHandler redirects to CAS login, and passes through 'renew', 'gateway' and 'method' parameters for testing.
 func appLoginHandler(store *httpd.Store) {
 	query := url.Values{"service": {CFG.CasClientServiceUrl}}
+	for _, key := range []string{"renew", "gateway", "method"} {
+		if value := store.R.URL.Query().Get(key); value != "" {
+			query.Set(key, value)
+		}
+	}
 	store.Redirect(http.StatusFound, CFG.CasServerUrlPrefix+"/login?"+query.Encode())
 }
 
+// appValidateHandler receives ticket in query, or in form body if CAS login is requested with 'method=POST'.
 func appValidateHandler(store *httpd.Store) {
-	ticket := store.R.URL.Query().Get("ticket")
+	ticket := store.R.FormValue("ticket")
 
 	resp, err := http.Get(CFG.CasServerUrlPrefix + "/p3/serviceValidate?" + url.Values{
 		"ticket":  {ticket},
 		"service": {CFG.CasClientServiceUrl},
+		"pgtUrl":  {CFG.CasClientProxyCallbackUrl},
 	}.Encode())
 	if err != nil {
 		LOG.Error(store.R.Context(), "cas service validate error", logger.Error(err))
@@ -38,7 +47,84 @@ func appValidateHandler(store *httpd.Store) {
 	store.Respond200(data)
 }
 
+// appProxyGrantingTickets maps pgtIou to pgtId received from proxy callback.
+var appProxyGrantingTickets sync.Map
+
+func appProxyCallbackHandler(store *httpd.Store) {
+	pgtId := store.R.URL.Query().Get("pgtId")
+	pgtIou := store.R.URL.Query().Get("pgtIou")
+	if pgtId == "" || pgtIou == "" {
+		http.Error(store.W, "pgtId or pgtIou is empty", http.StatusBadRequest)
+		return
+	}
+	appProxyGrantingTickets.Store(pgtIou, pgtId)
+	store.Respond200(nil)
+}
+
+// appProxyHandler acts as a proxy: it requests a proxy ticket for the service url of itself, and validates it with /proxyValidate.
+func appProxyHandler(store *httpd.Store) {
+	pgtIou := store.R.URL.Query().Get("pgtIou")
+	pgtId, ok := appProxyGrantingTickets.Load(pgtIou)
+	if !ok {
+		http.Error(store.W, "pgtIou not found, visit /app/login first", http.StatusBadRequest)
+		return
+	}
+
+	var result []byte
+	resp, err := http.Get(CFG.CasServerUrlPrefix + "/proxy?" + url.Values{
+		"pgt":           {pgtId.(string)},
+		"targetService": {CFG.CasClientServiceUrl},
+	}.Encode())
+	if err != nil {
+		LOG.Error(store.R.Context(), "cas proxy error", logger.Error(err))
+		store.Error500("cas proxy error")
+		return
+	}
+	defer resp.Body.Close()
+	var proxyResp struct {
+		ProxyTicket string `xml:"proxySuccess>proxyTicket"`
+	}
+	data, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		LOG.Error(store.R.Context(), "dump proxy response error", logger.Error(err))
+		store.Error500("dump proxy response error")
+		return
+	}
+	result = append(result, data...)
+	if err = xml.NewDecoder(resp.Body).Decode(&proxyResp); err != nil || proxyResp.ProxyTicket == "" {
+		store.Respond200(result)
+		return
+	}
+
+	resp, err = http.Get(CFG.CasServerUrlPrefix + "/p3/proxyValidate?" + url.Values{
+		"ticket":  {proxyResp.ProxyTicket},
+		"service": {CFG.CasClientServiceUrl},
+	}.Encode())
+	if err != nil {
+		LOG.Error(store.R.Context(), "cas proxy validate error", logger.Error(err))
+		store.Error500("cas proxy validate error")
+		return
+	}
+	defer resp.Body.Close()
+	if data, err = httputil.DumpResponse(resp, true); err != nil {
+		LOG.Error(store.R.Context(), "dump proxy validate response error", logger.Error(err))
+		store.Error500("dump proxy validate response error")
+		return
+	}
+	store.Respond200(append(append(result, "\n\n"...), data...))
+}
+
+// appLogoutHandler redirects to CAS logout, or handles front-channel single logout request from browser if 'logoutRequest' is present.
 func appLogoutHandler(store *httpd.Store) {
+	if value := store.R.URL.Query().Get("logoutRequest"); value != "" {
+		xmlData, err := decodeFrontChannelLogoutRequest(value)
+		if err != nil {
+			http.Error(store.W, "invalid logoutRequest", http.StatusBadRequest)
+			return
+		}
+		handleLogoutRequest(store, xmlData)
+		return
+	}
 	store.Redirect(http.StatusFound, CFG.CasServerUrlPrefix+"/logout")
 }
 
@@ -63,9 +149,13 @@ func appSingleLogoutHandler(store *httpd.Store) {
 		http.Error(store.W, "logoutRequest is empty", http.StatusBadRequest)
 		return
 	}
-	LOG.Debugf(store.R.Context(), "received logoutRequest: %s", xmlStr)
+	handleLogoutRequest(store, []byte(xmlStr))
+}
+
+func handleLogoutRequest(store *httpd.Store, xmlData []byte) {
+	LOG.Debugf(store.R.Context(), "received logoutRequest: %s", xmlData)
 	var logoutRequest LogoutRequest
-	err := xml.Unmarshal([]byte(xmlStr), &logoutRequest)
+	err := xml.Unmarshal(xmlData, &logoutRequest)
 	if err != nil {
 		LOG.Error(store.R.Context(), "unmarshal logout request error", logger.Error(err))
 		store.Error500("unmarshal logout request error")
@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/whoisnian/glb/httpd"
 	"github.com/whoisnian/glb/logger"
@@ -30,6 +31,7 @@ const loginPageRawTemplate = `<!DOCTYPE html>
     <input type="text" id="username" name="username" required><br><br>
     <label for="password">Password:</label>
     <input type="password" id="password" name="password" required><br><br>
+    <input type="hidden" name="execution" value="{{.Execution}}">
     <input type="submit" value="Login">
   </form>
 </body>
@@ -37,27 +39,68 @@ const loginPageRawTemplate = `<!DOCTYPE html>
 
 var loginPageTmpl = template.Must(template.New("loginPage").Parse(loginPageRawTemplate))
 
+const redirectPostRawTemplate = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8" />
+  <title>Mock CAS Redirect</title>
+</head>
+<body onload="document.forms[0].submit()">
+  <form method="post" action="{{.Service}}">
+    <input type="hidden" name="{{.TicketParam}}" value="{{.Ticket}}">
+    <noscript><input type="submit" value="Continue"></noscript>
+  </form>
+</body>
+</html>`
+
+var redirectPostTmpl = template.Must(template.New("redirectPost").Parse(redirectPostRawTemplate))
+
+// loginPageHandler shows login form, or issues service ticket if single sign-on session exists.
+// With 'renew=true' the session is ignored and the user must login again, and 'gateway=true' is ignored if renew is set.
+// With 'gateway=true' the user is redirected back to service without ticket if no session exists, instead of showing login form.
 func loginPageHandler(store *httpd.Store) {
-	if cookie, err := store.R.Cookie(TicketGrantingCookieName); err == nil {
-		if user, err := TP.ValidateTicket(store.R.Context(), cookie.Value, false); err == nil {
-			loginSuccessPageOrRedirectToService(store, user, cookie.Value)
+	service, _ := loginServiceParam(store.R)
+	if service != "" && SR.Find(service) == nil { // also prevents open redirect with gateway or after login
+		http.Error(store.W, "service "+service+" is not authorized to use CAS", http.StatusForbidden)
+		return
+	}
+	renew := store.R.URL.Query().Get("renew") == "true"
+	if cookie, err := store.R.Cookie(TicketGrantingCookieName); err == nil && !renew {
+		if user, err := TP.ValidateTicketGrantingTicket(store.R.Context(), cookie.Value); err == nil {
+			if err = CheckLoginAllowed(user); err != nil { // groups of user may be changed after login
+				http.SetCookie(store.W, ticketGrantingCookie(""))
+				http.Error(store.W, err.Error(), http.StatusForbidden)
+				return
+			}
+			loginSuccessPageOrRedirectToService(store, user, cookie.Value, false)
 			return
 		} else {
 			if !errors.Is(err, InvalidTicket) {
 				LOG.Warnf(store.R.Context(), "validate ticket error: %v", err)
 			}
-			cookie.MaxAge = -1
-			http.SetCookie(store.W, cookie)
+			http.SetCookie(store.W, ticketGrantingCookie(""))
 		}
 	}
+	if service != "" && !renew && store.R.URL.Query().Get("gateway") == "true" {
+		store.Redirect(http.StatusFound, service)
+		return
+	}
 
 	actionUrl := "/cas/login"
 	if store.R.URL.RawQuery != "" {
 		actionUrl += "?" + store.R.URL.RawQuery
 	}
+	var lt string
+	if cookie, err := store.R.Cookie(LoginTicketCookieName); err == nil && TP.ValidateLoginTicket(cookie.Value) == nil {
+		lt = cookie.Value // reuse login ticket, so login forms in multiple tabs are all valid
+	} else {
+		lt = TP.GenerateLoginTicket()
+		http.SetCookie(store.W, newCasCookie(LoginTicketCookieName, lt, "/cas/login", int(LoginTicketLifetime.Seconds())))
+	}
 
 	err := loginPageTmpl.Execute(store.W, map[string]string{
 		"FormActionUrl": actionUrl,
+		"Execution":     lt,
 	})
 	if err != nil {
 		LOG.Error(store.R.Context(), "execute login page template error", logger.Error(err))
@@ -66,7 +109,25 @@ func loginPageHandler(store *httpd.Store) {
 	}
 }
 
+// loginCheckHandler validates credentials posted from login form. The 'execution' field must be the login ticket
+// issued with the form, which is also kept in cookie, so the form cannot be forged from other sites or submitted twice.
 func loginCheckHandler(store *httpd.Store) {
+	execution := store.R.FormValue("execution")
+	cookie, err := store.R.Cookie(LoginTicketCookieName)
+	if err != nil || execution == "" || cookie.Value != execution {
+		http.Error(store.W, "invalid login form, please reload the login page", http.StatusForbidden)
+		return
+	}
+	http.SetCookie(store.W, newCasCookie(LoginTicketCookieName, "", "/cas/login", -1))
+	if err = TP.ConsumeLoginTicket(store.R.Context(), execution); errors.Is(err, InvalidTicket) {
+		http.Error(store.W, "login form is expired or already submitted, please reload the login page", http.StatusForbidden)
+		return
+	} else if err != nil {
+		LOG.Error(store.R.Context(), "consume login ticket error", logger.Error(err))
+		store.Error500("consume login ticket error")
+		return
+	}
+
 	username := store.R.FormValue("username")
 	password := store.R.FormValue("password")
 	if username == "" || password == "" {
@@ -74,7 +135,13 @@ func loginCheckHandler(store *httpd.Store) {
 		return
 	}
 	user, err := UP.ValidateUser(store.R.Context(), username, password)
-	if err != nil {
+	if err == nil {
+		err = CheckLoginAllowed(user)
+	}
+	if errors.Is(err, UserDisabledError) || errors.Is(err, UserLockedError) || errors.Is(err, UserNotAllowedError) {
+		http.Error(store.W, err.Error(), http.StatusForbidden)
+		return
+	} else if err != nil {
 		if !errors.Is(err, InvalidUsernameOrPasswordError) {
 			LOG.Warnf(store.R.Context(), "validate user error: %v", err)
 		}
@@ -88,59 +155,157 @@ func loginCheckHandler(store *httpd.Store) {
 		store.Error500("generate ticket granting ticket error")
 		return
 	}
-	cookie := &http.Cookie{
-		Name:     TicketGrantingCookieName,
-		Value:    tgt,
-		Path:     "/cas",
+	http.SetCookie(store.W, ticketGrantingCookie(tgt))
+
+	loginSuccessPageOrRedirectToService(store, user, tgt, true)
+}
+
+// newCasCookie returns an HttpOnly cookie with SameSite=Lax, so it is still sent when services redirect users to CAS.
+// It is Secure if CAS server is served over HTTPS, and the cookie is deleted if maxAge is negative.
+func newCasCookie(name, value, path string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		MaxAge:   maxAge,
 		HttpOnly: true,
+		Secure:   strings.HasPrefix(CFG.CasServerUrlPrefix, "https://"),
+		SameSite: http.SameSiteLaxMode,
 	}
-	http.SetCookie(store.W, cookie)
+}
 
-	loginSuccessPageOrRedirectToService(store, user, tgt)
+// ticketGrantingCookie returns session cookie of tgt, or a cookie to delete it if tgt is empty.
+func ticketGrantingCookie(tgt string) *http.Cookie {
+	if tgt == "" {
+		return newCasCookie(TicketGrantingCookieName, "", "/cas", -1)
+	}
+	return newCasCookie(TicketGrantingCookieName, tgt, "/cas", 0)
 }
 
-func loginSuccessPageOrRedirectToService(store *httpd.Store, user *User, tgt string) {
-	service := store.R.URL.Query().Get("service")
+// loginServiceParam returns the service url from 'service' parameter for CAS protocol, or from 'TARGET' parameter for SAML 1.1 protocol.
+// The ticketParam is the parameter name to carry ticket when redirecting to service.
+func loginServiceParam(r *http.Request) (service string, ticketParam string) {
+	if target := r.URL.Query().Get("TARGET"); target != "" {
+		return target, "SAMLart"
+	}
+	return r.URL.Query().Get("service"), "ticket"
+}
+
+// loginSuccessPageOrRedirectToService sends the user back to service with a new service ticket, by redirection or by
+// an auto-submitting form if 'method=POST' is specified. The success page is shown if no service is specified.
+func loginSuccessPageOrRedirectToService(store *httpd.Store, user *User, tgt string, newLogin bool) {
+	service, ticketParam := loginServiceParam(store.R)
 	if service == "" {
 		store.Respond200([]byte(`<body><pre>` + html.EscapeString(user.Username) + ` login successful, click <a href="/cas/logout">here</a> to logout.</pre></body>`))
 		return
 	}
 
-	st, err := TP.GenerateServiceTicket(store.R.Context(), user.Username)
+	if _, err := SR.CheckAccess(service, user); errors.Is(err, InvalidService) {
+		http.Error(store.W, "service "+service+" is not authorized to use CAS", http.StatusForbidden)
+		return
+	} else if errors.Is(err, UnauthorizedService) {
+		http.Error(store.W, "user "+user.Username+" is not authorized to access service "+service, http.StatusForbidden)
+		return
+	}
+
+	st, err := TP.GenerateServiceTicket(store.R.Context(), tgt, service, newLogin)
 	if err != nil {
 		LOG.Error(store.R.Context(), "generate service ticket error", logger.Error(err))
 		store.Error500("generate service ticket error")
 		return
 	}
-	if err = TP.BindTicketToGroup(store.R.Context(), tgt, st); err != nil {
+	if err = TP.BindTicketToGroup(store.R.Context(), tgt, st, service); err != nil {
 		LOG.Warnf(store.R.Context(), "bind ticket to group error: %v", err)
 	}
 
-	query := url.Values{"ticket": {st}}
-	store.Redirect(http.StatusFound, service+"?"+query.Encode())
+	if strings.ToUpper(store.R.URL.Query().Get("method")) != "POST" {
+		store.Redirect(http.StatusFound, appendQuery(service, url.Values{ticketParam: {st}}))
+		return
+	}
+	err = redirectPostTmpl.Execute(store.W, map[string]string{
+		"Service":     service,
+		"TicketParam": ticketParam,
+		"Ticket":      st,
+	})
+	if err != nil {
+		LOG.Error(store.R.Context(), "execute redirect post template error", logger.Error(err))
+		store.Error500("execute redirect post template error")
+	}
+}
+
+// appendQuery appends query parameters to rawUrl, which may already contain a query string.
+func appendQuery(rawUrl string, query url.Values) string {
+	if strings.Contains(rawUrl, "?") {
+		return rawUrl + "&" + query.Encode()
+	}
+	return rawUrl + "?" + query.Encode()
 }
 
+const frontChannelLogoutRawTemplate = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8" />
+  <title>Mock CAS Logout</title>
+</head>
+<body style="font-family:ui-monospace,Menlo,Consolas,Hack,Liberation Mono,Microsoft Yahei,Noto Sans Mono CJK SC,sans-serif;">
+  <pre id="status">logout successful, notifying {{len .LogoutUrls}} services...</pre>
+  <script>
+    var pending = {{len .LogoutUrls}}, service = {{.Service}}, finished = false;
+    function finish() {
+      if (finished) return;
+      finished = true;
+      if (service) location.href = service;
+      else document.getElementById("status").innerHTML = 'logout successful, click <a href="/cas/login">here</a> to login.';
+    }
+    function done() { if (--pending <= 0) finish(); }
+    setTimeout(finish, 5000);
+  </script>
+  {{- range .LogoutUrls}}
+  <iframe src="{{.}}" style="display:none" onload="done()"></iframe>
+  {{- end}}
+</body>
+</html>`
+
+var frontChannelLogoutTmpl = template.Must(template.New("frontChannelLogout").Parse(frontChannelLogoutRawTemplate))
+
+// logoutHandler destroys single sign-on session and notifies services which the user logged in with it.
+// In back-channel mode requests are sent by SLO dispatcher from server, and in front-channel mode they are sent by browser with iframes.
 func logoutHandler(store *httpd.Store) {
+	var notices []LogoutNotice
 	if cookie, err := store.R.Cookie(TicketGrantingCookieName); err == nil {
-		user, err := TP.DeleteTicket(store.R.Context(), cookie.Value)
-		if err != nil && !errors.Is(err, InvalidTicket) {
-			LOG.Warnf(store.R.Context(), "delete ticket error: %v", err)
-		}
-		tgt := cookie.Value
-		cookie.Value = ""
-		cookie.MaxAge = -1
-		http.SetCookie(store.W, cookie)
-
-		sts := TP.DeleteTicketGroup(store.R.Context(), tgt)
-		for _, st := range sts {
-			_, err = TP.DeleteTicket(store.R.Context(), st)
-			if err != nil && !errors.Is(err, InvalidTicket) {
-				LOG.Warnf(store.R.Context(), "delete ticket error: %v", err)
+		notices = destroySession(store.R.Context(), cookie.Value)
+		http.SetCookie(store.W, ticketGrantingCookie(""))
+	}
+
+	service := store.R.URL.Query().Get("service")
+	if service != "" && (!isHttpUrl(service) || SR.Find(service) == nil) { // also prevents javascript: url in front-channel logout page
+		LOG.Warnf(store.R.Context(), "ignore logout redirect to unregistered service %s", service)
+		service = ""
+	}
+	if CFG.SingleLogoutMode == "front" && len(notices) > 0 {
+		logoutUrls := make([]string, 0, len(notices))
+		for _, notice := range notices {
+			if logoutUrl, err := frontChannelLogoutUrl(notice); err != nil {
+				LOG.Warnf(store.R.Context(), "encode single logout request error: %v", err)
+			} else {
+				logoutUrls = append(logoutUrls, logoutUrl)
 			}
-			sendLogoutRequest(store.R.Context(), user.Username, st)
+		}
+		err := frontChannelLogoutTmpl.Execute(store.W, map[string]any{
+			"LogoutUrls": logoutUrls,
+			"Service":    service,
+		})
+		if err != nil {
+			LOG.Error(store.R.Context(), "execute front channel logout template error", logger.Error(err))
+			store.Error500("execute front channel logout template error")
+		}
+		return
+	}
+	if SLO != nil {
+		for _, notice := range notices {
+			SLO.Dispatch(store.R.Context(), notice)
 		}
 	}
-	service := store.R.URL.Query().Get("service")
 	if service == "" {
 		store.Respond200([]byte(`<body><pre>logout successful, click <a href="/cas/login">here</a> to login.</pre></body>`))
 		return
@@ -148,26 +313,32 @@ func logoutHandler(store *httpd.Store) {
 	store.Redirect(http.StatusFound, service)
 }
 
-func sendLogoutRequest(ctx context.Context, username, sessionIndex string) {
-	logoutReqData, err := encodeSingleLogoutRequest(username, sessionIndex)
-	if err != nil {
-		LOG.Warnf(ctx, "encode single logout request error: %v", err)
-		return
-	}
-	values := url.Values{"logoutRequest": {string(logoutReqData)}}
-	resp, err := http.PostForm(CFG.CasClientLogoutUrl, values)
-	if err != nil {
-		LOG.Warnf(ctx, "post single logout request error: %v", err)
-		return
+// destroySession deletes ticket granting ticket and all tickets bound to it,
+// and returns single logout notices for services which the user logged in with it.
+func destroySession(ctx context.Context, tgt string) (notices []LogoutNotice) {
+	data, err := TP.DeleteTicket(ctx, tgt)
+	if err != nil && !errors.Is(err, InvalidTicket) {
+		LOG.Warnf(ctx, "delete ticket error: %v", err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		LOG.Infof(ctx, "single logout request received %s", resp.Status)
-	} else {
-		buf := make([]byte, 4096)
-		n, _ := io.ReadFull(resp.Body, buf)
-		LOG.Warnf(ctx, "single logout request received %s: %s", resp.Status, buf[:n])
+	members := TP.DeleteTicketGroup(ctx, tgt)
+	for _, member := range members {
+		_, err = TP.DeleteTicket(ctx, member.Ticket)
+		if err != nil && !errors.Is(err, InvalidTicket) {
+			LOG.Warnf(ctx, "delete ticket error: %v", err)
+		}
+		// proxy granting tickets and oidc tickets are not sessions of CAS services, so only service and proxy tickets are notified
+		if data == nil || !(strings.HasPrefix(member.Ticket, ServiceTicketPrefix+"-") || strings.HasPrefix(member.Ticket, ProxyTicketPrefix+"-")) {
+			continue
+		}
+		if FI.Match(member.Service).DropLogout() {
+			LOG.Infof(ctx, "drop single logout request for %s by fault injection", member.Ticket)
+			continue
+		}
+		if svc := SR.Find(member.Service); svc != nil && svc != oidcCallbackService {
+			notices = append(notices, LogoutNotice{LogoutUrl: svc.SingleLogoutUrl(), Username: data.Username, SessionIndex: member.Ticket})
+		}
 	}
+	return notices
 }
 
 func validateHandler(store *httpd.Store) {
@@ -177,45 +348,234 @@ func validateHandler(store *httpd.Store) {
 		http.Error(store.W, "ticket or service is empty", http.StatusBadRequest)
 		return
 	}
+	fault := FI.Match(service)
+	if fault.BeforeValidate(store) {
+		return
+	}
+	fault.ExpireTicket(store.R.Context(), ticket)
 
-	user, err := TP.ValidateTicket(store.R.Context(), ticket, true)
+	user, tktData, err := TP.ValidateServiceTicket(store.R.Context(), ticket, service, false)
+	if err == nil && store.R.URL.Query().Get("renew") == "true" && !tktData.NewLogin {
+		err = InvalidTicket
+	}
+	if err == nil {
+		_, err = SR.CheckAccess(service, user)
+	}
+	if err == nil {
+		err = fault.InternalError()
+	}
 	if err != nil {
-		if !errors.Is(err, InvalidTicket) {
+		if !isTicketValidationError(err) {
 			LOG.Warnf(store.R.Context(), "validate ticket error: %v", err)
 		}
-		store.Respond200([]byte("no\n"))
+		recordValidationFailure(store.R, ticket, service, "INVALID_TICKET", err.Error())
+		store.Respond200(fault.Malform([]byte("no\n")))
 		return
 	}
-	store.Respond200([]byte("yes\n" + user.Username + "\n"))
+	store.Respond200(fault.Malform([]byte("yes\n" + fault.User(user).Username + "\n")))
 }
 
 func serviceValidateHandler(store *httpd.Store) {
+	serviceOrProxyValidate(store, false)
+}
+
+func proxyValidateHandler(store *httpd.Store) {
+	serviceOrProxyValidate(store, true)
+}
+
+// serviceOrProxyValidate validates service ticket, and also proxy ticket if allowProxy is true.
+// If pgtUrl is present, a proxy granting ticket is issued and sent to the callback url with its iou.
+// If renew is true, tickets issued from single sign-on session are rejected.
+func serviceOrProxyValidate(store *httpd.Store, allowProxy bool) {
 	ticket := store.R.URL.Query().Get("ticket")
 	service := store.R.URL.Query().Get("service")
+	pgtUrl := store.R.URL.Query().Get("pgtUrl")
+	renew := store.R.URL.Query().Get("renew") == "true"
 	format := strings.ToUpper(store.R.URL.Query().Get("format")) // XML or JSON, default XML
 	if ticket == "" || service == "" {
 		http.Error(store.W, "ticket or service is empty", http.StatusBadRequest)
 		return
 	}
+	fault := FI.Match(service)
+	if fault.BeforeValidate(store) {
+		return
+	}
+	fault.ExpireTicket(store.R.Context(), ticket)
 
 	var data []byte
-	user, err := TP.ValidateTicket(store.R.Context(), ticket, true)
+	var svc *RegisteredService
+	user, tktData, err := TP.ValidateServiceTicket(store.R.Context(), ticket, service, allowProxy)
+	if err == nil && renew && !tktData.NewLogin {
+		err = InvalidTicket // with renew, only tickets issued right after the user provided credentials are accepted
+	}
+	if err == nil {
+		svc, err = SR.CheckAccess(service, user)
+	}
+	if err == nil {
+		err = fault.InternalError()
+	}
 	if err != nil {
-		if !errors.Is(err, InvalidTicket) {
+		if !isTicketValidationError(err) {
 			LOG.Warnf(store.R.Context(), "validate ticket error: %v", err)
 		}
-		data, err = encodeServiceResponseFailure("INVALID_TICKET", "Ticket "+ticket+" not recognized", format)
+		var code, desc string
+		switch {
+		case errors.Is(err, InvalidTicketSpec):
+			code, desc = "INVALID_TICKET_SPEC", "Ticket "+ticket+" does not satisfy validation specification"
+		case errors.Is(err, InvalidService):
+			code, desc = "INVALID_SERVICE", "Service "+service+" is invalid for ticket "+ticket
+		case errors.Is(err, UnauthorizedService):
+			code, desc = "UNAUTHORIZED_SERVICE", "User "+user.Username+" is not authorized to access service "+service
+		case errors.Is(err, InjectedInternalError):
+			code, desc = "INTERNAL_ERROR", "Internal error injected by fault scenario"
+		default:
+			code, desc = "INVALID_TICKET", "Ticket "+ticket+" not recognized"
+		}
+		recordValidationFailure(store.R, ticket, service, code, desc)
+		data, err = encodeServiceResponseFailure(code, desc, format)
+	} else if pgtUrl != "" && !isValidProxyCallbackUrl(pgtUrl) {
+		desc := "The supplied proxy callback url " + pgtUrl + " is invalid"
+		recordValidationFailure(store.R, ticket, service, "INVALID_PROXY_CALLBACK", desc)
+		data, err = encodeServiceResponseFailure("INVALID_PROXY_CALLBACK", desc, format)
 	} else {
-		data, err = encodeServiceResponseSuccess(user.Username, user.Mail, user.Mobile, format)
+		var pgtIou string
+		if pgtUrl != "" {
+			pgtIou = sendProxyGrantingTicket(store.R.Context(), tktData, pgtUrl)
+		}
+		var proxies []string
+		if allowProxy {
+			proxies = tktData.Proxies
+		}
+		data, err = encodeServiceResponseSuccess(svc.ReleasedUser(fault.User(user)), tktData, pgtIou, proxies, format)
 	}
 	if err != nil {
 		LOG.Error(store.R.Context(), "encode service response error", logger.Error(err))
 		store.Error500("encode service response error")
 		return
 	}
+	store.Respond200(fault.Malform(data))
+}
+
+func isTicketValidationError(err error) bool {
+	return errors.Is(err, InvalidTicket) || errors.Is(err, InvalidTicketSpec) || errors.Is(err, InvalidService) || errors.Is(err, UnauthorizedService) || errors.Is(err, InjectedInternalError)
+}
+
+// isValidProxyCallbackUrl reports whether pgtUrl is an https url matching the service registry.
+// Plain http is accepted only with -allow-http-proxy-callback, as the proxy granting ticket is sent in its query.
+func isValidProxyCallbackUrl(pgtUrl string) bool {
+	u, err := url.Parse(pgtUrl)
+	if err != nil || (u.Scheme != "https" && (u.Scheme != "http" || !CFG.AllowHttpProxyCallback)) {
+		return false
+	}
+	return SR.Find(pgtUrl) != nil
+}
+
+// isHttpUrl reports whether rawUrl is an absolute http or https url, which is safe to redirect to or request.
+func isHttpUrl(rawUrl string) bool {
+	u, err := url.Parse(rawUrl)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+var proxyCallbackClient = &http.Client{Timeout: 10 * time.Second}
+
+// sendProxyGrantingTicket issues a proxy granting ticket and sends it with iou to pgtUrl.
+// It returns the iou if the callback responds 200, otherwise the ticket is revoked and empty string is returned.
+func sendProxyGrantingTicket(ctx context.Context, data *TicketData, pgtUrl string) (pgtIou string) {
+	pgt, err := TP.GenerateProxyGrantingTicket(ctx, data, pgtUrl)
+	if err != nil {
+		LOG.Warnf(ctx, "generate proxy granting ticket error: %v", err)
+		return ""
+	}
+	pgtIou = NewTicket(ProxyGrantingTicketIouPrefix, 0, TicketGrantingTicketRandSize).String()
+
+	resp, err := proxyCallbackClient.Get(appendQuery(pgtUrl, url.Values{"pgtId": {pgt}, "pgtIou": {pgtIou}}))
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK {
+			return pgtIou
+		}
+		err = errors.New("unexpected status " + resp.Status)
+	}
+	LOG.Warnf(ctx, "proxy callback to %s error: %v", pgtUrl, err)
+	if _, err = TP.DeleteTicket(ctx, pgt); err != nil {
+		LOG.Warnf(ctx, "delete ticket error: %v", err)
+	}
+	return ""
+}
+
+func proxyHandler(store *httpd.Store) {
+	pgt := store.R.URL.Query().Get("pgt")
+	targetService := store.R.URL.Query().Get("targetService")
+	format := strings.ToUpper(store.R.URL.Query().Get("format")) // XML or JSON, default XML
+
+	var data []byte
+	var err error
+	if pgt == "" || targetService == "" {
+		data, err = encodeProxyResponseFailure("INVALID_REQUEST", "'pgt' and 'targetService' parameters are both required", format)
+	} else if SR.Find(targetService) == nil {
+		data, err = encodeProxyResponseFailure("UNAUTHORIZED_SERVICE", "Service "+targetService+" is not authorized to use CAS", format)
+	} else if pt, e := TP.GenerateProxyTicket(store.R.Context(), pgt, targetService); e != nil {
+		if !errors.Is(e, InvalidTicket) {
+			LOG.Warnf(store.R.Context(), "generate proxy ticket error: %v", e)
+		}
+		data, err = encodeProxyResponseFailure("INVALID_TICKET", "Ticket "+pgt+" not recognized", format)
+	} else {
+		data, err = encodeProxyResponseSuccess(pt, format)
+	}
+	if err != nil {
+		LOG.Error(store.R.Context(), "encode proxy response error", logger.Error(err))
+		store.Error500("encode proxy response error")
+		return
+	}
 	store.Respond200(data)
 }
 
-func proxyValidateHandler(store *httpd.Store) {
-	http.Error(store.W, "not implemented", http.StatusNotImplemented)
+// samlValidateHandler validates service ticket in SAML 1.1 protocol, the ticket is posted as AssertionArtifact in SOAP request.
+func samlValidateHandler(store *httpd.Store) {
+	target := store.R.URL.Query().Get("TARGET")
+	body, err := io.ReadAll(io.LimitReader(store.R.Body, 64*1024))
+	if err != nil {
+		http.Error(store.W, "read request body error", http.StatusBadRequest)
+		return
+	}
+	fault := FI.Match(target)
+	if fault.BeforeValidate(store) {
+		return
+	}
+
+	var data []byte
+	req, err := decodeSamlValidateRequest(body)
+	if err != nil || target == "" {
+		LOG.Debugf(store.R.Context(), "decode saml validate request error: %v", err)
+		data, err = encodeSamlValidateFailure("", target, "samlp:Requester", "TARGET and AssertionArtifact are both required")
+	} else {
+		ticket := req.Body.Request.AssertionArtifact
+		var user *User
+		var tktData *TicketData
+		var svc *RegisteredService
+		fault.ExpireTicket(store.R.Context(), ticket)
+		user, tktData, err = TP.ValidateServiceTicket(store.R.Context(), ticket, target, false)
+		if err == nil {
+			svc, err = SR.CheckAccess(target, user)
+		}
+		if err == nil {
+			err = fault.InternalError()
+		}
+		if err != nil {
+			if !isTicketValidationError(err) {
+				LOG.Warnf(store.R.Context(), "validate ticket error: %v", err)
+			}
+			recordValidationFailure(store.R, ticket, target, "samlp:Responder", err.Error())
+			data, err = encodeSamlValidateFailure(req.Body.Request.RequestID, target, "samlp:Responder", "Ticket "+ticket+" is invalid for service "+target+": "+err.Error())
+		} else {
+			data, err = encodeSamlValidateSuccess(req.Body.Request.RequestID, target, svc.ReleasedUser(fault.User(user)), tktData)
+		}
+	}
+	if err != nil {
+		LOG.Error(store.R.Context(), "encode saml validate response error", logger.Error(err))
+		store.Error500("encode saml validate response error")
+		return
+	}
+	store.W.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	store.Respond200(fault.Malform(data))
 }
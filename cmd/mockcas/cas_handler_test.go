@@ -0,0 +1,427 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/whoisnian/glb/httpd"
+	"github.com/whoisnian/glb/logger"
+)
+
+func setupTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	LOG = logger.New(logger.NewNanoHandler(io.Discard, logger.Options{}))
+	UP = NewStaticUserProvider()
+	TP = NewTicketProvider(NewMemTicketAdapter(), 10*time.Second, time.Hour, time.Hour)
+	SR = nil
+	FI = NewFaultInjector(nil)
+	CFG.AllowAnyService = true
+	t.Cleanup(func() { CFG.AllowAnyService = false })
+
+	mux := httpd.NewMux()
+	mux.Handle("/healthz", http.MethodGet, healthzHandler)
+	mux.Handle("/cas/login", http.MethodGet, loginPageHandler)
+	mux.Handle("/cas/login", http.MethodPost, loginCheckHandler)
+	mux.Handle("/cas/logout", http.MethodGet, logoutHandler)
+	mux.Handle("/cas/validate", http.MethodGet, validateHandler)
+	mux.Handle("/cas/p3/serviceValidate", http.MethodGet, serviceValidateHandler)
+	mux.Handle("/cas/p3/proxyValidate", http.MethodGet, proxyValidateHandler)
+	mux.Handle("/cas/proxy", http.MethodGet, proxyHandler)
+	mux.Handle("/cas/samlValidate", http.MethodPost, samlValidateHandler)
+	mux.Handle("/oidc/.well-known/openid-configuration", http.MethodGet, oidcDiscoveryHandler)
+	mux.Handle("/oidc/jwks", http.MethodGet, oidcJwksHandler)
+	mux.Handle("/oidc/authorize", http.MethodGet, oidcAuthorizeHandler)
+	mux.Handle("/oidc/callback", http.MethodGet, oidcCallbackHandler)
+	mux.Handle("/oidc/token", http.MethodPost, oidcTokenHandler)
+	mux.Handle("/oidc/userinfo", http.MethodGet, oidcUserinfoHandler)
+	mux.Handle("/admin/api/sessions", http.MethodGet, adminAuth(adminSessionsHandler))
+	mux.Handle("/admin/api/logout", http.MethodPost, adminAuth(adminLogoutHandler))
+	mux.Handle("/admin/api/tickets", http.MethodPost, adminAuth(adminTicketsHandler))
+	mux.Handle("/admin/api/failures", http.MethodGet, adminAuth(adminFailuresHandler))
+	mux.Handle("/admin/api/fault", http.MethodGet, adminAuth(adminFaultHandler))
+	mux.Handle("/admin/api/fault", http.MethodPost, adminAuth(adminFaultActivateHandler))
+	mux.Handle("/admin/api/fault", http.MethodPut, adminAuth(adminFaultSetHandler))
+	mux.Handle("/admin/api/fault", http.MethodDelete, adminAuth(adminFaultResetHandler))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newTestBrowser returns a client which keeps cookies and does not follow redirects.
+func newTestBrowser() *http.Client {
+	jar, _ := cookiejar.New(nil)
+	return &http.Client{Jar: jar, CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+}
+
+// expectRedirect sends request and returns the parsed Location of its 302 response.
+func expectRedirect(t *testing.T, client *http.Client, req *http.Request) *url.URL {
+	t.Helper()
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s error: %v", req.Method, req.URL, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("%s %s got status %d, want 302", req.Method, req.URL, resp.StatusCode)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parse location error: %v", err)
+	}
+	return location
+}
+
+// newLoginRequest fetches login form at rawUrl with client, and returns the request to submit it with credentials.
+func newLoginRequest(t *testing.T, client *http.Client, rawUrl, username, password string) *http.Request {
+	t.Helper()
+	resp, err := client.Get(rawUrl)
+	if err != nil {
+		t.Fatalf("GET %s error: %v", rawUrl, err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	match := regexp.MustCompile(`name="execution" value="([^"]+)"`).FindSubmatch(body)
+	if resp.StatusCode != http.StatusOK || match == nil {
+		t.Fatalf("GET %s got %d without login form", rawUrl, resp.StatusCode)
+	}
+
+	form := url.Values{"username": {username}, "password": {password}, "execution": {string(match[1])}}
+	req, err := http.NewRequest(http.MethodPost, rawUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatalf("new request error: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+func httpGetString(t *testing.T, rawUrl string) string {
+	t.Helper()
+	resp, err := http.Get(rawUrl)
+	if err != nil {
+		t.Fatalf("GET %s error: %v", rawUrl, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return string(data)
+}
+
+type testAuthenticationSuccess struct {
+	User                string         `json:"user"`
+	Attributes          map[string]any `json:"attributes"`
+	ProxyGrantingTicket string         `json:"proxyGrantingTicket"`
+	Proxies             []string       `json:"proxies"`
+}
+
+type testServiceResponse struct {
+	ServiceResponse struct {
+		AuthenticationSuccess *testAuthenticationSuccess `json:"authenticationSuccess"`
+		AuthenticationFailure *AuthenticationFailure     `json:"authenticationFailure"`
+		ProxySuccess          *ProxySuccess              `json:"proxySuccess"`
+		ProxyFailure          *AuthenticationFailure     `json:"proxyFailure"`
+	} `json:"serviceResponse"`
+}
+
+func getServiceResponse(t *testing.T, rawUrl string) (resp testServiceResponse) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(httpGetString(t, rawUrl)), &resp); err != nil {
+		t.Fatalf("unmarshal response of %s error: %v", rawUrl, err)
+	}
+	return resp
+}
+
+func TestProxyFlow(t *testing.T) {
+	srv := setupTestServer(t)
+	CFG.AllowHttpProxyCallback = true
+	t.Cleanup(func() { CFG.AllowHttpProxyCallback = false })
+
+	callbacks := make(chan url.Values, 1)
+	proxyCallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		callbacks <- r.URL.Query()
+	}))
+	defer proxyCallback.Close()
+	pgtUrl := proxyCallback.URL + "/pgtCallback"
+
+	tgt, _ := TP.GenerateTicketGrantingTicket(t.Context(), "casuser")
+	st, _ := TP.GenerateServiceTicket(t.Context(), tgt, "http://proxy/validate", false)
+
+	// 1. proxy validates its service ticket with pgtUrl, and receives PGTIOU in xml
+	body := httpGetString(t, srv.URL+"/cas/p3/serviceValidate?"+url.Values{
+		"ticket": {st}, "service": {"http://proxy/validate"}, "pgtUrl": {pgtUrl},
+	}.Encode())
+	var xmlResp struct {
+		Success struct {
+			User                string `xml:"user"`
+			ProxyGrantingTicket string `xml:"proxyGrantingTicket"`
+		} `xml:"authenticationSuccess"`
+	}
+	if err := xml.Unmarshal([]byte(body), &xmlResp); err != nil {
+		t.Fatalf("unmarshal serviceValidate response error: %v\n%s", err, body)
+	}
+	var callback url.Values
+	select {
+	case callback = <-callbacks:
+	default:
+		t.Fatalf("proxy callback is not called")
+	}
+	if xmlResp.Success.User != "casuser" || xmlResp.Success.ProxyGrantingTicket != callback.Get("pgtIou") {
+		t.Fatalf("serviceValidate got %+v, want pgtIou %s\n%s", xmlResp.Success, callback.Get("pgtIou"), body)
+	}
+	pgt := callback.Get("pgtId")
+
+	// 2. proxy requests a proxy ticket for target service
+	resp := getServiceResponse(t, srv.URL+"/cas/proxy?"+url.Values{
+		"pgt": {pgt}, "targetService": {"http://backend/"}, "format": {"json"},
+	}.Encode())
+	if resp.ServiceResponse.ProxySuccess == nil || !strings.HasPrefix(resp.ServiceResponse.ProxySuccess.ProxyTicket, "PT-") {
+		t.Fatalf("proxy got %+v, want proxySuccess", resp.ServiceResponse)
+	}
+	pt := resp.ServiceResponse.ProxySuccess.ProxyTicket
+
+	// 3. target service cannot validate proxy ticket with /serviceValidate, and it is not consumed
+	resp = getServiceResponse(t, srv.URL+"/cas/p3/serviceValidate?"+url.Values{
+		"ticket": {pt}, "service": {"http://backend/"}, "format": {"JSON"},
+	}.Encode())
+	if f := resp.ServiceResponse.AuthenticationFailure; f == nil || f.Code != "INVALID_TICKET_SPEC" {
+		t.Fatalf("serviceValidate(pt) got %+v, want INVALID_TICKET_SPEC", resp.ServiceResponse)
+	}
+
+	// 4. target service validates proxy ticket with /proxyValidate, and receives the proxy chain
+	resp = getServiceResponse(t, srv.URL+"/cas/p3/proxyValidate?"+url.Values{
+		"ticket": {pt}, "service": {"http://backend/"}, "format": {"JSON"},
+	}.Encode())
+	if s := resp.ServiceResponse.AuthenticationSuccess; s == nil || s.User != "casuser" || !reflect.DeepEqual(s.Proxies, []string{pgtUrl}) {
+		t.Fatalf("proxyValidate(pt) got %+v, want proxies [%s]", resp.ServiceResponse, pgtUrl)
+	}
+
+	// 5. proxy ticket is consumed after validation
+	resp = getServiceResponse(t, srv.URL+"/cas/p3/proxyValidate?"+url.Values{
+		"ticket": {pt}, "service": {"http://backend/"}, "format": {"JSON"},
+	}.Encode())
+	if f := resp.ServiceResponse.AuthenticationFailure; f == nil || f.Code != "INVALID_TICKET" {
+		t.Fatalf("proxyValidate(pt) again got %+v, want INVALID_TICKET", resp.ServiceResponse)
+	}
+}
+
+func TestServiceValidateFailures(t *testing.T) {
+	srv := setupTestServer(t)
+	tgt, _ := TP.GenerateTicketGrantingTicket(t.Context(), "casuser")
+
+	st, _ := TP.GenerateServiceTicket(t.Context(), tgt, "http://app/validate", false)
+	resp := getServiceResponse(t, srv.URL+"/cas/p3/serviceValidate?"+url.Values{
+		"ticket": {st}, "service": {"http://other/validate"}, "format": {"JSON"},
+	}.Encode())
+	if f := resp.ServiceResponse.AuthenticationFailure; f == nil || f.Code != "INVALID_SERVICE" {
+		t.Errorf("serviceValidate(other service) got %+v, want INVALID_SERVICE", resp.ServiceResponse)
+	}
+
+	st, _ = TP.GenerateServiceTicket(t.Context(), tgt, "http://app/validate", false)
+	resp = getServiceResponse(t, srv.URL+"/cas/p3/serviceValidate?"+url.Values{
+		"ticket": {st}, "service": {"http://app/validate"}, "pgtUrl": {"ftp://proxy/callback"}, "format": {"JSON"},
+	}.Encode())
+	if f := resp.ServiceResponse.AuthenticationFailure; f == nil || f.Code != "INVALID_PROXY_CALLBACK" {
+		t.Errorf("serviceValidate(invalid pgtUrl) got %+v, want INVALID_PROXY_CALLBACK", resp.ServiceResponse)
+	}
+
+	st, _ = TP.GenerateServiceTicket(t.Context(), tgt, "http://app/validate", false)
+	resp = getServiceResponse(t, srv.URL+"/cas/p3/serviceValidate?"+url.Values{
+		"ticket": {st}, "service": {"http://app/validate"}, "pgtUrl": {"http://proxy/callback"}, "format": {"JSON"},
+	}.Encode())
+	if f := resp.ServiceResponse.AuthenticationFailure; f == nil || f.Code != "INVALID_PROXY_CALLBACK" {
+		t.Errorf("serviceValidate(http pgtUrl) got %+v, want INVALID_PROXY_CALLBACK", resp.ServiceResponse)
+	}
+
+	resp = getServiceResponse(t, srv.URL+"/cas/proxy?format=JSON&pgt=PGT-1-XXX-t8k2n0&targetService=http://backend/")
+	if f := resp.ServiceResponse.ProxyFailure; f == nil || f.Code != "INVALID_TICKET" {
+		t.Errorf("proxy(unknown pgt) got %+v, want INVALID_TICKET", resp.ServiceResponse)
+	}
+	if body := httpGetString(t, srv.URL+"/cas/validate?ticket="+st+"&service=http://app/validate"); body != "no\n" {
+		t.Errorf("validate(consumed st) got %q, want %q", body, "no\n")
+	}
+}
+
+func TestLoginRenewGatewayPost(t *testing.T) {
+	srv := setupTestServer(t)
+	client := newTestBrowser()
+	service := "https://app.example.com/validate"
+	loginUrl := srv.URL + "/cas/login?" + url.Values{"service": {service}}.Encode()
+
+	// gateway without session redirects back to service without ticket
+	req, _ := http.NewRequest(http.MethodGet, loginUrl+"&gateway=true", nil)
+	if location := expectRedirect(t, client, req); location.String() != service {
+		t.Fatalf("gateway redirected to %s, want %s", location, service)
+	}
+
+	location := expectRedirect(t, client, newLoginRequest(t, client, loginUrl, "casuser", "Mellon"))
+	ticket := location.Query().Get("ticket")
+	resp := getServiceResponse(t, srv.URL+"/cas/p3/serviceValidate?"+url.Values{"ticket": {ticket}, "service": {service}, "renew": {"true"}, "format": {"JSON"}}.Encode())
+	if resp.ServiceResponse.AuthenticationSuccess == nil {
+		t.Fatalf("renew validate of new login ticket failed: %+v", resp.ServiceResponse.AuthenticationFailure)
+	}
+
+	// ticket from single sign-on session is rejected by renew validation
+	req, _ = http.NewRequest(http.MethodGet, loginUrl, nil)
+	ticket = expectRedirect(t, client, req).Query().Get("ticket")
+	resp = getServiceResponse(t, srv.URL+"/cas/p3/serviceValidate?"+url.Values{"ticket": {ticket}, "service": {service}, "renew": {"true"}, "format": {"JSON"}}.Encode())
+	if resp.ServiceResponse.AuthenticationFailure == nil || resp.ServiceResponse.AuthenticationFailure.Code != "INVALID_TICKET" {
+		t.Fatalf("renew validate of sso ticket should fail with INVALID_TICKET, got %+v", resp.ServiceResponse)
+	}
+
+	// renew ignores the session and shows login form
+	httpResp, err := client.Get(loginUrl + "&renew=true&gateway=true")
+	if err != nil {
+		t.Fatalf("login request error: %v", err)
+	}
+	body, _ := io.ReadAll(httpResp.Body)
+	httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK || !strings.Contains(string(body), `name="password"`) {
+		t.Fatalf("renew login got %d, want login form", httpResp.StatusCode)
+	}
+
+	// method=POST returns an auto-submitting form instead of redirection
+	httpResp, err = client.Get(loginUrl + "&method=POST")
+	if err != nil {
+		t.Fatalf("login request error: %v", err)
+	}
+	body, _ = io.ReadAll(httpResp.Body)
+	httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK || !strings.Contains(string(body), `action="`+service+`"`) || !strings.Contains(string(body), `name="ticket" value="ST-`) {
+		t.Fatalf("method=POST login got %d %s, want auto-submitting form", httpResp.StatusCode, body)
+	}
+}
+
+func TestLoginCsrfAndRedirectValidation(t *testing.T) {
+	srv := setupTestServer(t)
+	service := "https://app.example.com/validate"
+	loginUrl := srv.URL + "/cas/login?" + url.Values{"service": {service}}.Encode()
+	postStatus := func(client *http.Client, req *http.Request) int {
+		t.Helper()
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s error: %v", req.Method, req.URL, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// login form without execution, or with execution from another browser, is rejected
+	client := newTestBrowser()
+	req, _ := http.NewRequest(http.MethodPost, loginUrl, strings.NewReader(url.Values{"username": {"casuser"}, "password": {"Mellon"}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if status := postStatus(client, req); status != http.StatusForbidden {
+		t.Fatalf("login without execution got %d, want 403", status)
+	}
+	if status := postStatus(newTestBrowser(), newLoginRequest(t, client, loginUrl, "casuser", "Mellon")); status != http.StatusForbidden {
+		t.Fatalf("login with execution of another browser got %d, want 403", status)
+	}
+
+	// forged or expired execution is rejected even if the cookie matches it
+	lt := TP.GenerateLoginTicket()
+	forged := lt[:len(lt)-1] + "0"
+	if forged == lt {
+		forged = lt[:len(lt)-1] + "1"
+	}
+	for _, bad := range []string{forged, strings.Replace(lt, "LT-", "ST-", 1), TP.signLoginTicket(time.Now().Add(-time.Second))} {
+		form := url.Values{"username": {"casuser"}, "password": {"Mellon"}, "execution": {bad}}
+		req, _ = http.NewRequest(http.MethodPost, loginUrl, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: LoginTicketCookieName, Value: bad})
+		if status := postStatus(newTestBrowser(), req); status != http.StatusForbidden {
+			t.Fatalf("login with execution %s got %d, want 403", bad, status)
+		}
+	}
+
+	// login forms in multiple tabs share the same execution, and it is not kept in ticket store
+	execution := func(req *http.Request) string {
+		form, _ := io.ReadAll(req.Body)
+		req.Body = io.NopCloser(strings.NewReader(string(form)))
+		values, _ := url.ParseQuery(string(form))
+		return values.Get("execution")
+	}
+	first := execution(newLoginRequest(t, client, loginUrl, "casuser", "Mellon"))
+	req = newLoginRequest(t, client, loginUrl, "casuser", "Mellon")
+	if second := execution(req); second != first {
+		t.Fatalf("login form in another tab got execution %s, want %s", second, first)
+	}
+	if tickets, _ := TP.adapter.List(t.Context(), LoginTicketPrefix); len(tickets) != 0 {
+		t.Fatalf("login tickets are kept in ticket store: %v", tickets)
+	}
+	expectRedirect(t, client, req)
+
+	// submitted login form cannot be replayed, even with its cookie
+	form := url.Values{"username": {"casuser"}, "password": {"Mellon"}, "execution": {first}}
+	req, _ = http.NewRequest(http.MethodPost, loginUrl, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(&http.Cookie{Name: LoginTicketCookieName, Value: first})
+	if status := postStatus(newTestBrowser(), req); status != http.StatusForbidden {
+		t.Fatalf("login with replayed execution got %d, want 403", status)
+	}
+
+	// services other than http or https urls are rejected even without service registry
+	for _, bad := range []string{"javascript:alert(1)", "//evil.example.com/", "/relative"} {
+		resp, err := client.Get(srv.URL + "/cas/login?" + url.Values{"service": {bad}}.Encode())
+		if err != nil {
+			t.Fatalf("login request error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("login with service %s got %d, want 403", bad, resp.StatusCode)
+		}
+	}
+
+	// services at other origins are rejected without service registry, unless allow-any-service is set
+	CFG.AllowAnyService = false
+	resp, err := client.Get(srv.URL + "/cas/login?" + url.Values{"service": {"https://evil.example.com/"}}.Encode())
+	if err != nil {
+		t.Fatalf("login request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("login with service of other origin got %d, want 403", resp.StatusCode)
+	}
+
+	// logout does not redirect to unregistered service
+	if SR, err = ParseServiceRegistry([]byte("services:\n  - pattern: https://app\\.example\\.com/.*\n")); err != nil {
+		t.Fatalf("ParseServiceRegistry error: %v", err)
+	}
+	resp, err = client.Get(srv.URL + "/cas/logout?service=" + url.QueryEscape("https://evil.example.com/"))
+	if err != nil {
+		t.Fatalf("logout request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("logout with unregistered service got %d, want 200 without redirect", resp.StatusCode)
+	}
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+"/cas/logout?service="+url.QueryEscape(service), nil)
+	if location := expectRedirect(t, client, req); location.String() != service {
+		t.Fatalf("logout redirected to %s, want %s", location, service)
+	}
+}
+
+func TestTicketGrantingCookieAttributes(t *testing.T) {
+	casServerUrlPrefix := CFG.CasServerUrlPrefix
+	t.Cleanup(func() { CFG.CasServerUrlPrefix = casServerUrlPrefix })
+
+	for _, c := range []struct {
+		prefix string
+		secure bool
+	}{{"http://cas.example.com/cas", false}, {"https://cas.example.com/cas", true}} {
+		CFG.CasServerUrlPrefix = c.prefix
+		cookie := ticketGrantingCookie("TGT-1-abc-xyz")
+		if cookie.Secure != c.secure || cookie.SameSite != http.SameSiteLaxMode || !cookie.HttpOnly || cookie.Path != "/cas" {
+			t.Fatalf("cookie for %s is %s", c.prefix, cookie)
+		}
+		if cookie = ticketGrantingCookie(""); cookie.MaxAge >= 0 || cookie.Secure != c.secure {
+			t.Fatalf("deleting cookie for %s is %s", c.prefix, cookie)
+		}
+	}
+}
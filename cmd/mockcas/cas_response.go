@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"encoding/xml"
+	"maps"
+	"slices"
+	"strconv"
 	"time"
 )
 
@@ -57,25 +61,47 @@ func encodeServiceResponseFailure(code, desc, format string) ([]byte, error) {
 //	  <cas:authenticationSuccess>
 //	    <cas:user>casuser</cas:user>
 //	    <cas:attributes>
+//	      <cas:authenticationDate>2026-01-04T14:25:34.123Z</cas:authenticationDate>
+//	      <cas:longTermAuthenticationRequestTokenUsed>false</cas:longTermAuthenticationRequestTokenUsed>
+//	      <cas:isFromNewLogin>true</cas:isFromNewLogin>
 //	      <cas:mail>casuser@example.org</cas:mail>
-//	      <cas:mobile>12345678910</cas:mobile>
+//	      <cas:memberOf>cn=users,ou=groups,dc=example,dc=com</cas:memberOf>
+//	      <cas:memberOf>cn=developers,ou=groups,dc=example,dc=com</cas:memberOf>
 //	    </cas:attributes>
 //	  </cas:authenticationSuccess>
 //	</cas:serviceResponse>
 //
-// Example JSON authenticationSuccess response:
+// Example JSON authenticationSuccess response, single-valued attribute is encoded as string and multi-valued one as array:
 //
 //	{
 //	  "serviceResponse": {
 //	    "authenticationSuccess": {
 //	      "user": "casuser",
 //	      "attributes": {
+//	        "authenticationDate": "2026-01-04T14:25:34.123Z",
+//	        "longTermAuthenticationRequestTokenUsed": false,
+//	        "isFromNewLogin": true,
 //	        "mail": "casuser@example.org",
-//	        "mobile": "12345678910"
+//	        "memberOf": ["cn=users,ou=groups,dc=example,dc=com", "cn=developers,ou=groups,dc=example,dc=com"]
 //	      }
 //	    }
 //	  }
 //	}
+//
+// The proxyGrantingTicket is present only if pgtUrl is requested and its callback succeeded,
+// and the proxies are present only in /proxyValidate response for proxy tickets:
+//
+//	<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">
+//	  <cas:authenticationSuccess>
+//	    <cas:user>casuser</cas:user>
+//	    <cas:attributes>...</cas:attributes>
+//	    <cas:proxyGrantingTicket>PGTIOU-4-JBWTCA5BY3MX5NHNNWVGCNQBZCJWRRJP-t8k2n0</cas:proxyGrantingTicket>
+//	    <cas:proxies>
+//	      <cas:proxy>https://proxy2/pgtUrl</cas:proxy>
+//	      <cas:proxy>https://proxy1/pgtUrl</cas:proxy>
+//	    </cas:proxies>
+//	  </cas:authenticationSuccess>
+//	</cas:serviceResponse>
 type ServiceResponseSuccessWrapper struct {
 	ServiceResponseSuccess `json:"serviceResponse"`
 }
@@ -87,28 +113,170 @@ type ServiceResponseSuccess struct {
 }
 
 type AuthenticationSuccess struct {
-	User  string         `xml:"cas:user" json:"user"`
-	Attrs UserAttributes `xml:"cas:attributes" json:"attributes"`
+	User                string         `xml:"cas:user" json:"user"`
+	Attrs               UserAttributes `xml:"cas:attributes" json:"attributes"`
+	ProxyGrantingTicket string         `xml:"cas:proxyGrantingTicket,omitempty" json:"proxyGrantingTicket,omitempty"`
+	Proxies             []string       `xml:"-" json:"proxies,omitempty"`
+	XMLProxies          *XMLProxies    `xml:"cas:proxies,omitempty" json:"-"` // encoding/xml cannot omit empty parent of a>b
+}
+
+type XMLProxies struct {
+	Proxy []string `xml:"cas:proxy"`
 }
 
+// UserAttributes contains the standard CAS authentication attributes and the released user attributes.
 type UserAttributes struct {
-	Mail   string `xml:"cas:mail" json:"mail"`
-	Mobile string `xml:"cas:mobile" json:"mobile"`
+	AuthenticationDate                     time.Time
+	LongTermAuthenticationRequestTokenUsed bool
+	IsFromNewLogin                         bool
+	Values                                 map[string][]string
+}
+
+func (a UserAttributes) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	elem := func(name string, value any) error {
+		return e.EncodeElement(value, xml.StartElement{Name: xml.Name{Local: "cas:" + name}})
+	}
+	if err := elem("authenticationDate", a.AuthenticationDate.UTC().Format(time.RFC3339Nano)); err != nil {
+		return err
+	}
+	if err := elem("longTermAuthenticationRequestTokenUsed", a.LongTermAuthenticationRequestTokenUsed); err != nil {
+		return err
+	}
+	if err := elem("isFromNewLogin", a.IsFromNewLogin); err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(a.Values)) {
+		for _, value := range a.Values[name] {
+			if err := elem(name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return e.EncodeToken(start.End())
 }
 
-func encodeServiceResponseSuccess(username, mail, mobile, format string) ([]byte, error) {
+func (a UserAttributes) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"authenticationDate":`)
+	data, _ := json.Marshal(a.AuthenticationDate.UTC().Format(time.RFC3339Nano))
+	buf.Write(data)
+	buf.WriteString(`,"longTermAuthenticationRequestTokenUsed":` + strconv.FormatBool(a.LongTermAuthenticationRequestTokenUsed))
+	buf.WriteString(`,"isFromNewLogin":` + strconv.FormatBool(a.IsFromNewLogin))
+	for _, name := range slices.Sorted(maps.Keys(a.Values)) {
+		var value any = a.Values[name]
+		if len(a.Values[name]) == 1 {
+			value = a.Values[name][0]
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		key, _ := json.Marshal(name)
+		buf.WriteByte(',')
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(data)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func encodeServiceResponseSuccess(user *User, data *TicketData, pgtIou string, proxies []string, format string) ([]byte, error) {
 	resp := ServiceResponseSuccessWrapper{
 		ServiceResponseSuccess{
 			Xmlns: "http://www.yale.edu/tp/cas",
 			Content: AuthenticationSuccess{
-				User: username,
+				User: user.Username,
 				Attrs: UserAttributes{
-					Mail:   mail,
-					Mobile: mobile,
+					AuthenticationDate: data.AuthTime,
+					IsFromNewLogin:     data.NewLogin,
+					Values:             user.Attributes,
 				},
+				ProxyGrantingTicket: pgtIou,
+				Proxies:             proxies,
 			},
 		},
 	}
+	if len(proxies) > 0 {
+		resp.Content.XMLProxies = &XMLProxies{Proxy: proxies}
+	}
+	if format == "JSON" {
+		return json.MarshalIndent(resp, "", "  ")
+	} else {
+		return xml.MarshalIndent(resp, "", "  ")
+	}
+}
+
+// Example XML proxySuccess response:
+//
+//	<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">
+//	  <cas:proxySuccess>
+//	    <cas:proxyTicket>PT-5-YIQFDTNRHHBHBMZ7JIAPCVDSBGEZYMTP-t8k2n3</cas:proxyTicket>
+//	  </cas:proxySuccess>
+//	</cas:serviceResponse>
+//
+// Example JSON proxySuccess response:
+//
+//	{
+//	  "serviceResponse": {
+//	    "proxySuccess": {
+//	      "proxyTicket": "PT-5-YIQFDTNRHHBHBMZ7JIAPCVDSBGEZYMTP-t8k2n3"
+//	    }
+//	  }
+//	}
+type ProxyResponseSuccessWrapper struct {
+	ProxyResponseSuccess `json:"serviceResponse"`
+}
+
+type ProxyResponseSuccess struct {
+	XMLName xml.Name     `xml:"cas:serviceResponse" json:"-"`
+	Xmlns   string       `xml:"xmlns:cas,attr" json:"-"`
+	Content ProxySuccess `xml:"cas:proxySuccess" json:"proxySuccess"`
+}
+
+type ProxySuccess struct {
+	ProxyTicket string `xml:"cas:proxyTicket" json:"proxyTicket"`
+}
+
+func encodeProxyResponseSuccess(pt, format string) ([]byte, error) {
+	resp := ProxyResponseSuccessWrapper{
+		ProxyResponseSuccess{
+			Xmlns:   "http://www.yale.edu/tp/cas",
+			Content: ProxySuccess{ProxyTicket: pt},
+		},
+	}
+	if format == "JSON" {
+		return json.MarshalIndent(resp, "", "  ")
+	} else {
+		return xml.MarshalIndent(resp, "", "  ")
+	}
+}
+
+// Example XML proxyFailure response:
+//
+//	<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">
+//	  <cas:proxyFailure code="INVALID_TICKET">Ticket PGT-3-XXX-t8k2n0 not recognized</cas:proxyFailure>
+//	</cas:serviceResponse>
+type ProxyResponseFailureWrapper struct {
+	ProxyResponseFailure `json:"serviceResponse"`
+}
+
+type ProxyResponseFailure struct {
+	XMLName xml.Name              `xml:"cas:serviceResponse" json:"-"`
+	Xmlns   string                `xml:"xmlns:cas,attr" json:"-"`
+	Content AuthenticationFailure `xml:"cas:proxyFailure" json:"proxyFailure"`
+}
+
+func encodeProxyResponseFailure(code, desc, format string) ([]byte, error) {
+	resp := ProxyResponseFailureWrapper{
+		ProxyResponseFailure{
+			Xmlns:   "http://www.yale.edu/tp/cas",
+			Content: AuthenticationFailure{Code: code, Description: desc},
+		},
+	}
 	if format == "JSON" {
 		return json.MarshalIndent(resp, "", "  ")
 	} else {
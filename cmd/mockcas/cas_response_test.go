@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeServiceResponseSuccess(t *testing.T) {
+	user := &User{Username: "casuser", Attributes: map[string][]string{
+		"mail":     {"casuser@example.org"},
+		"memberOf": {"cn=users", "cn=developers"},
+	}}
+	data := &TicketData{AuthTime: time.Date(2026, 1, 4, 14, 25, 34, 123000000, time.UTC), NewLogin: true}
+
+	xmlData, err := encodeServiceResponseSuccess(user, data, "", nil, "XML")
+	if err != nil {
+		t.Fatalf("encodeServiceResponseSuccess(XML) error: %v", err)
+	}
+	wantXML := `<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">
+  <cas:authenticationSuccess>
+    <cas:user>casuser</cas:user>
+    <cas:attributes>
+      <cas:authenticationDate>2026-01-04T14:25:34.123Z</cas:authenticationDate>
+      <cas:longTermAuthenticationRequestTokenUsed>false</cas:longTermAuthenticationRequestTokenUsed>
+      <cas:isFromNewLogin>true</cas:isFromNewLogin>
+      <cas:mail>casuser@example.org</cas:mail>
+      <cas:memberOf>cn=users</cas:memberOf>
+      <cas:memberOf>cn=developers</cas:memberOf>
+    </cas:attributes>
+  </cas:authenticationSuccess>
+</cas:serviceResponse>`
+	if string(xmlData) != wantXML {
+		t.Errorf("encodeServiceResponseSuccess(XML) =\n%s\nwant\n%s", xmlData, wantXML)
+	}
+
+	jsonData, err := encodeServiceResponseSuccess(user, data, "", nil, "JSON")
+	if err != nil {
+		t.Fatalf("encodeServiceResponseSuccess(JSON) error: %v", err)
+	}
+	var compact strings.Builder
+	enc := json.NewEncoder(&compact)
+	var v any
+	json.Unmarshal(jsonData, &v)
+	enc.Encode(v)
+	wantJSON := `{"serviceResponse":{"authenticationSuccess":{"attributes":{"authenticationDate":"2026-01-04T14:25:34.123Z","isFromNewLogin":true,"longTermAuthenticationRequestTokenUsed":false,"mail":"casuser@example.org","memberOf":["cn=users","cn=developers"]},"user":"casuser"}}}` + "\n"
+	if compact.String() != wantJSON {
+		t.Errorf("encodeServiceResponseSuccess(JSON) =\n%s\nwant\n%s", compact.String(), wantJSON)
+	}
+}
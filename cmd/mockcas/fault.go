@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/whoisnian/glb/httpd"
+	"sigs.k8s.io/yaml"
+)
+
+// Duration is time.Duration in JSON or YAML, which is written as string like '500ms'.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	v, err := time.ParseDuration(s)
+	*d = Duration(v)
+	return err
+}
+
+var InjectedInternalError = errors.New("injected internal error")
+
+// Fault describes failures injected into ticket validation and single logout, and the zero value injects nothing.
+// The rates are probabilities between 0 and 1, and 1 means always.
+type Fault struct {
+	Latency           Duration `json:"latency,omitempty"`           // delay before validation response
+	HttpErrorRate     float64  `json:"httpErrorRate,omitempty"`     // respond http error instead of validation response
+	HttpStatus        int      `json:"httpStatus,omitempty"`        // status code of http error, 503 if empty
+	InternalErrorRate float64  `json:"internalErrorRate,omitempty"` // respond INTERNAL_ERROR validation failure
+	MalformedRate     float64  `json:"malformedRate,omitempty"`     // truncate validation response to malformed XML or JSON
+	ExpiredTicketRate float64  `json:"expiredTicketRate,omitempty"` // treat ticket as expired
+	WrongUser         string   `json:"wrongUser,omitempty"`         // username returned in validation success instead of the real one
+	DropLogoutRate    float64  `json:"dropLogoutRate,omitempty"`    // drop single logout requests
+}
+
+// ServiceFault applies fault to services whose url matches pattern.
+type ServiceFault struct {
+	Pattern string `json:"pattern"`
+	Fault
+
+	re *regexp.Regexp
+}
+
+// FaultScenario injects faults globally, or to matching services instead, and the first matched service fault is used:
+//
+//	global:
+//	  latency: 500ms
+//	services:
+//	  - pattern: https://app\.example\.com/.*
+//	    internalErrorRate: 0.5
+//	    dropLogoutRate: 1
+type FaultScenario struct {
+	Global   Fault           `json:"global"`
+	Services []*ServiceFault `json:"services,omitempty"`
+}
+
+func (sc *FaultScenario) compile() error {
+	for i, sf := range sc.Services {
+		re, err := regexp.Compile(`^(?:` + sf.Pattern + `)$`)
+		if err != nil {
+			return fmt.Errorf("service fault %d: %w", i, err)
+		}
+		sf.re = re
+	}
+	return nil
+}
+
+// FaultInjector holds named scenarios from file and the active scenario, which can be switched at runtime.
+type FaultInjector struct {
+	mu        sync.RWMutex
+	scenarios map[string]*FaultScenario
+	name      string
+	active    *FaultScenario
+}
+
+// FI injects nothing until a scenario is activated.
+var FI = NewFaultInjector(nil)
+
+func setupFaultInjector(ctx context.Context) {
+	if CFG.FaultScenarios != "" {
+		data, err := os.ReadFile(CFG.FaultScenarios)
+		if err != nil {
+			LOG.Fatalf(ctx, "read fault scenarios error: %v", err)
+		}
+		scenarios, err := ParseFaultScenarios(data)
+		if err != nil {
+			LOG.Fatalf(ctx, "parse fault scenarios error: %v", err)
+		}
+		FI = NewFaultInjector(scenarios)
+		LOG.Infof(ctx, "loaded %d fault scenarios from %s", len(scenarios), CFG.FaultScenarios)
+	}
+	if CFG.FaultScenario != "" {
+		if err := FI.Activate(CFG.FaultScenario); err != nil {
+			LOG.Fatalf(ctx, "activate fault scenario error: %v", err)
+		}
+		LOG.Warnf(ctx, "fault scenario %s is active", CFG.FaultScenario)
+	}
+}
+
+// ParseFaultScenarios parses named scenarios in YAML or JSON format:
+//
+//	scenarios:
+//	  slow:
+//	    global:
+//	      latency: 2s
+func ParseFaultScenarios(data []byte) (map[string]*FaultScenario, error) {
+	var file struct {
+		Scenarios map[string]*FaultScenario `json:"scenarios"`
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, err
+	}
+	for name, sc := range file.Scenarios {
+		if sc == nil {
+			return nil, fmt.Errorf("scenario %q is empty", name)
+		}
+		if err := sc.compile(); err != nil {
+			return nil, fmt.Errorf("scenario %q: %w", name, err)
+		}
+	}
+	return file.Scenarios, nil
+}
+
+func NewFaultInjector(scenarios map[string]*FaultScenario) *FaultInjector {
+	if scenarios == nil {
+		scenarios = make(map[string]*FaultScenario)
+	}
+	return &FaultInjector{scenarios: scenarios}
+}
+
+// Activate switches to the named scenario.
+func (fi *FaultInjector) Activate(name string) error {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	sc, ok := fi.scenarios[name]
+	if !ok {
+		return fmt.Errorf("fault scenario %q not found", name)
+	}
+	fi.name, fi.active = name, sc
+	return nil
+}
+
+// Set switches to an unnamed scenario, and nil sc disables fault injection.
+func (fi *FaultInjector) Set(sc *FaultScenario) error {
+	if sc != nil {
+		if err := sc.compile(); err != nil {
+			return err
+		}
+	}
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+
+	fi.name, fi.active = "", sc
+	return nil
+}
+
+// Status returns name and content of the active scenario, and names of all named scenarios.
+func (fi *FaultInjector) Status() (name string, active *FaultScenario, names []string) {
+	fi.mu.RLock()
+	defer fi.mu.RUnlock()
+
+	for n := range fi.scenarios {
+		names = append(names, n)
+	}
+	slices.Sort(names)
+	return fi.name, fi.active, names
+}
+
+// Match returns the fault for service in the active scenario, or nil if nothing should be injected.
+func (fi *FaultInjector) Match(service string) *Fault {
+	fi.mu.RLock()
+	defer fi.mu.RUnlock()
+
+	if fi.active == nil {
+		return nil
+	}
+	for _, sf := range fi.active.Services {
+		if sf.re.MatchString(service) {
+			return &sf.Fault
+		}
+	}
+	if fi.active.Global == (Fault{}) {
+		return nil
+	}
+	return &fi.active.Global
+}
+
+func roll(rate float64) bool {
+	return rate > 0 && rand.Float64() < rate
+}
+
+// BeforeValidate sleeps for latency, and responds http error by chance.
+// It returns true if the response is written and validation should be skipped. It is safe to call on nil fault.
+func (f *Fault) BeforeValidate(store *httpd.Store) (done bool) {
+	if f == nil {
+		return false
+	}
+	if f.Latency > 0 {
+		select {
+		case <-store.R.Context().Done():
+			return true
+		case <-time.After(time.Duration(f.Latency)):
+		}
+	}
+	if roll(f.HttpErrorRate) {
+		status := f.HttpStatus
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(store.W, "injected http error "+strconv.Itoa(status), status)
+		return true
+	}
+	return false
+}
+
+// ExpireTicket deletes ticket by chance, so the following validation fails as if it was expired. It is safe to call on nil fault.
+func (f *Fault) ExpireTicket(ctx context.Context, ticket string) {
+	if f == nil || !roll(f.ExpiredTicketRate) {
+		return
+	}
+	if _, err := TP.DeleteTicket(ctx, ticket); err != nil && !errors.Is(err, InvalidTicket) {
+		LOG.Warnf(ctx, "delete ticket error: %v", err)
+	}
+}
+
+// InternalError returns InjectedInternalError by chance, and validation should fail with INTERNAL_ERROR then. It is safe to call on nil fault.
+func (f *Fault) InternalError() error {
+	if f != nil && roll(f.InternalErrorRate) {
+		return InjectedInternalError
+	}
+	return nil
+}
+
+// User returns a copy of user with wrong username if configured. It is safe to call on nil fault.
+func (f *Fault) User(user *User) *User {
+	if f == nil || f.WrongUser == "" {
+		return user
+	}
+	wrong := *user
+	wrong.Username = f.WrongUser
+	return &wrong
+}
+
+// Malform truncates response data by chance. It is safe to call on nil fault.
+func (f *Fault) Malform(data []byte) []byte {
+	if f != nil && roll(f.MalformedRate) {
+		return data[:len(data)/2]
+	}
+	return data
+}
+
+// DropLogout reports whether single logout request should be dropped. It is safe to call on nil fault.
+func (f *Fault) DropLogout() bool {
+	return f != nil && roll(f.DropLogoutRate)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testFaultScenarios = `
+scenarios:
+  broken:
+    global:
+      latency: 50ms
+      wrongUser: mallory
+    services:
+      - pattern: https://error\.example\.com/.*
+        internalErrorRate: 1
+      - pattern: https://down\.example\.com/.*
+        httpErrorRate: 1
+        httpStatus: 502
+      - pattern: https://expired\.example\.com/.*
+        expiredTicketRate: 1
+      - pattern: https://malformed\.example\.com/.*
+        malformedRate: 1
+`
+
+func TestParseFaultScenarios(t *testing.T) {
+	scenarios, err := ParseFaultScenarios([]byte(testFaultScenarios))
+	if err != nil {
+		t.Fatalf("ParseFaultScenarios error: %v", err)
+	}
+	fi := NewFaultInjector(scenarios)
+	if f := fi.Match("https://error.example.com/"); f != nil {
+		t.Fatalf("inactive injector matched %+v", f)
+	}
+	if err = fi.Activate("missing"); err == nil {
+		t.Fatal("activate missing scenario should fail")
+	}
+	if err = fi.Activate("broken"); err != nil {
+		t.Fatalf("Activate error: %v", err)
+	}
+	if f := fi.Match("https://error.example.com/"); f == nil || f.InternalErrorRate != 1 {
+		t.Fatalf("service fault is not matched: %+v", f)
+	}
+	if f := fi.Match("https://app.example.com/"); f == nil || time.Duration(f.Latency) != 50*time.Millisecond || f.WrongUser != "mallory" {
+		t.Fatalf("global fault is not matched: %+v", f)
+	}
+
+	for _, data := range []string{
+		"scenarios:\n  bad:\n    global:\n      latency: fast\n",
+		"scenarios:\n  bad:\n    services:\n      - pattern: '('\n",
+		"scenarios:\n  bad:\n    global:\n      unknown: 1\n",
+	} {
+		if _, err = ParseFaultScenarios([]byte(data)); err == nil {
+			t.Fatalf("ParseFaultScenarios(%q) should fail", data)
+		}
+	}
+}
+
+func TestFaultInjection(t *testing.T) {
+	srv := setupTestServer(t)
+	scenarios, err := ParseFaultScenarios([]byte(testFaultScenarios))
+	if err != nil {
+		t.Fatalf("ParseFaultScenarios error: %v", err)
+	}
+	FI = NewFaultInjector(scenarios)
+	if err = FI.Activate("broken"); err != nil {
+		t.Fatalf("Activate error: %v", err)
+	}
+
+	validate := func(service string) (*http.Response, string) {
+		t.Helper()
+		tgt, _ := TP.GenerateTicketGrantingTicket(t.Context(), "casuser")
+		st, _ := TP.GenerateServiceTicket(t.Context(), tgt, service, false)
+		resp, err := http.Get(srv.URL + "/cas/p3/serviceValidate?" + url.Values{"ticket": {st}, "service": {service}, "format": {"JSON"}}.Encode())
+		if err != nil {
+			t.Fatalf("serviceValidate request error: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+	decode := func(body string) (result testServiceResponse) {
+		t.Helper()
+		if err := json.Unmarshal([]byte(body), &result); err != nil {
+			t.Fatalf("unmarshal service response %q error: %v", body, err)
+		}
+		return result
+	}
+
+	start := time.Now()
+	_, body := validate("https://app.example.com/")
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("validation took %s, want latency of 50ms", elapsed)
+	}
+	if success := decode(body).ServiceResponse.AuthenticationSuccess; success == nil || success.User != "mallory" {
+		t.Fatalf("validation with wrong user got %s", body)
+	}
+	_, body = validate("https://error.example.com/")
+	if failure := decode(body).ServiceResponse.AuthenticationFailure; failure == nil || failure.Code != "INTERNAL_ERROR" {
+		t.Fatalf("validation with internal error got %s", body)
+	}
+	_, body = validate("https://expired.example.com/")
+	if failure := decode(body).ServiceResponse.AuthenticationFailure; failure == nil || failure.Code != "INVALID_TICKET" {
+		t.Fatalf("validation with expired ticket got %s", body)
+	}
+	if resp, _ := validate("https://down.example.com/"); resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("validation with http error got %d, want 502", resp.StatusCode)
+	}
+	if _, body = validate("https://malformed.example.com/"); json.Valid([]byte(body)) {
+		t.Fatalf("validation with malformed response got valid json %s", body)
+	}
+
+	FI.Set(nil)
+	if _, body = validate("https://error.example.com/"); decode(body).ServiceResponse.AuthenticationSuccess == nil {
+		t.Fatalf("validation without fault got %s", body)
+	}
+}
+
+func TestAdminFaultApi(t *testing.T) {
+	srv := setupTestServer(t)
+	password := CFG.AdminPassword
+	t.Cleanup(func() { CFG.AdminPassword = password })
+	CFG.AdminPassword = "secret"
+	scenarios, err := ParseFaultScenarios([]byte(testFaultScenarios))
+	if err != nil {
+		t.Fatalf("ParseFaultScenarios error: %v", err)
+	}
+	FI = NewFaultInjector(scenarios)
+
+	var status FaultStatus
+	if code := adminRequest(t, http.MethodGet, srv.URL+"/admin/api/fault", nil, &status); code != http.StatusOK || status.Scenario != nil || len(status.Scenarios) != 1 {
+		t.Fatalf("get fault status got %d %+v", code, status)
+	}
+	if code := adminRequest(t, http.MethodPost, srv.URL+"/admin/api/fault", url.Values{"scenario": {"missing"}}, nil); code != http.StatusNotFound {
+		t.Fatalf("activate missing scenario got %d, want 404", code)
+	}
+	if code := adminRequest(t, http.MethodPost, srv.URL+"/admin/api/fault", url.Values{"scenario": {"broken"}}, &status); code != http.StatusOK || status.Active != "broken" {
+		t.Fatalf("activate scenario got %d %+v", code, status)
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/admin/api/fault", strings.NewReader(`{"global":{"dropLogoutRate":1}}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth("admin", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("put fault scenario error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("put fault scenario got %d", resp.StatusCode)
+	}
+
+	// single logout requests are dropped, so no service is notified
+	tgt, _ := TP.GenerateTicketGrantingTicket(t.Context(), "casuser")
+	st, _ := TP.GenerateServiceTicket(t.Context(), tgt, "https://app.example.com/", false)
+	TP.BindTicketToGroup(t.Context(), tgt, st, "https://app.example.com/")
+	if notices := destroySession(t.Context(), tgt); len(notices) != 0 {
+		t.Fatalf("got %d logout notices, want all dropped", len(notices))
+	}
+
+	if code := adminRequest(t, http.MethodDelete, srv.URL+"/admin/api/fault", nil, &status); code != http.StatusOK || status.Active != "" || status.Scenario != nil {
+		t.Fatalf("reset fault got %d %+v", code, status)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/whoisnian/glb/httpd"
+)
+
+const (
+	HealthStatusUp   = "up"
+	HealthStatusDown = "down"
+)
+
+// HealthCheck is the result of checking a dependency, e.g. the LDAP server.
+type HealthCheck struct {
+	Status  string         `json:"status"`
+	Error   string         `json:"error,omitempty"`
+	Latency string         `json:"latency,omitempty"`
+	Details map[string]any `json:"details,omitempty"`
+}
+
+type HealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+const healthCheckTimeout = 5 * time.Second
+
+// healthzHandler responds 200 if all dependencies are up, otherwise 503. It is not protected for probes of load balancers.
+func healthzHandler(store *httpd.Store) {
+	ctx, cancel := context.WithTimeout(store.R.Context(), healthCheckTimeout)
+	defer cancel()
+
+	resp := HealthResponse{Status: HealthStatusUp, Checks: make(map[string]HealthCheck)}
+	if p, ok := UP.(*LdapUserProvider); ok {
+		resp.Checks["ldap"] = p.HealthCheck(ctx)
+	}
+	for _, check := range resp.Checks {
+		if check.Status != HealthStatusUp {
+			resp.Status = HealthStatusDown
+		}
+	}
+	if resp.Status != HealthStatusUp {
+		store.RespondJson(http.StatusServiceUnavailable, resp)
+		return
+	}
+	store.RespondJson(http.StatusOK, resp)
+}
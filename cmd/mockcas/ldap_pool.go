@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ldapPool keeps at most size connections which are bound as the admin DN, and idle connections are reused.
+type ldapPool struct {
+	dial  func(ctx context.Context) (*ldap.Conn, error) // dials and binds a new connection
+	idle  chan *ldap.Conn
+	slots chan struct{} // each open connection takes a slot
+}
+
+func newLdapPool(size int, dial func(ctx context.Context) (*ldap.Conn, error)) *ldapPool {
+	size = max(size, 1)
+	return &ldapPool{
+		dial:  dial,
+		idle:  make(chan *ldap.Conn, size),
+		slots: make(chan struct{}, size),
+	}
+}
+
+// Get returns an idle connection with reused as true, or dials a new one if pool is not full.
+// Otherwise it waits until a connection is released or ctx is done.
+func (p *ldapPool) Get(ctx context.Context) (conn *ldap.Conn, reused bool, err error) {
+	for {
+		select {
+		case conn := <-p.idle:
+			if conn.IsClosing() {
+				p.discard(conn)
+				continue
+			}
+			return conn, true, nil
+		default:
+		}
+
+		select {
+		case conn := <-p.idle:
+			if conn.IsClosing() {
+				p.discard(conn)
+				continue
+			}
+			return conn, true, nil
+		case p.slots <- struct{}{}:
+			if err = ctx.Err(); err == nil {
+				conn, err = p.dial(ctx)
+			}
+			if err != nil {
+				<-p.slots
+				return nil, false, err
+			}
+			return conn, false, nil
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+}
+
+// Put releases conn back to pool, and conn is closed instead if broken is true.
+// The conn must be bound as the admin DN again before released, otherwise it should be marked as broken.
+func (p *ldapPool) Put(conn *ldap.Conn, broken bool) {
+	if broken || conn.IsClosing() {
+		p.discard(conn)
+		return
+	}
+	p.idle <- conn // never blocks because idle connections are less than slots
+}
+
+func (p *ldapPool) discard(conn *ldap.Conn) {
+	conn.Close()
+	<-p.slots
+}
+
+// Close closes idle connections, and connections in use are closed when they are released.
+func (p *ldapPool) Close() {
+	for {
+		select {
+		case conn := <-p.idle:
+			p.discard(conn)
+		default:
+			return
+		}
+	}
+}
+
+// Stats returns the number of open connections and idle ones in them.
+func (p *ldapPool) Stats() (open, idle int) {
+	return len(p.slots), len(p.idle)
+}
@@ -20,16 +20,65 @@ var CFG struct {
 	Debug      bool   `flag:"d,false,Enable debug output"`
 	ListenAddr string `flag:"l,0.0.0.0:9090,Server listen addr"`
 
-	CasServerUrlPrefix  string `flag:"cas-server-url-prefix,,URL prefix of the CAS server, auto detected if empty"`
-	CasClientServiceUrl string `flag:"cas-client-service-url,,Service URL of the CAS client application, auto detected if empty"`
-	CasClientLogoutUrl  string `flag:"cas-client-logout-url,,Logout URL of the CAS client application, auto detected if empty"`
-	CasAuthMethod       string `flag:"cas-auth-method,static,Authentication method of the CAS server, static or ldap"`
-
-	LDAPServerUrl    string `flag:"|ldap-server-url|ldap://127.0.0.1:3890|URL of the LDAP server"`
-	LDAPBindDN       string `flag:"|ldap-bind-dn|cn=admin,ou=people,dc=example,dc=com|DN to bind to the LDAP server"`
-	LDAPBindPass     string `flag:"|ldap-bind-pass|password|Password for the LDAP bind DN"`
-	LDAPBaseDN       string `flag:"|ldap-base-dn|ou=people,dc=example,dc=com|Base DN for LDAP search"`
-	LDAPSearchFilter string `flag:"|ldap-search-filter|(uid=%s)|Filter for LDAP search"`
+	TLS     bool   `flag:"tls,false,Serve HTTPS with a self-signed certificate generated at startup, or with tls-cert if specified"`
+	TLSCert string `flag:"tls-cert,,Path of TLS certificate in PEM format, HTTPS is enabled if specified"`
+	TLSKey  string `flag:"tls-key,,Path of TLS private key in PEM format"`
+
+	CasServerUrlPrefix        string `flag:"cas-server-url-prefix,,URL prefix of the CAS server, auto detected if empty"`
+	CasClientServiceUrl       string `flag:"cas-client-service-url,,Service URL of the CAS client application, auto detected if empty"`
+	CasClientLogoutUrl        string `flag:"cas-client-logout-url,,Logout URL of the CAS client application, auto detected if empty"`
+	CasClientProxyCallbackUrl string `flag:"cas-client-proxy-callback-url,,Proxy callback URL of the CAS client application, auto detected if empty"`
+	CasAuthMethod             string `flag:"cas-auth-method,static,Authentication method of the CAS server, static or file or ldap"`
+	ServiceRegistry           string `flag:"service-registry,,Path of service registry file in YAML or JSON, only services at the origins of cas client and server urls are allowed if empty"`
+	AllowAnyService           bool   `flag:"allow-any-service,false,Allow all http and https services if service-registry is empty, which makes login an open redirect"`
+	AllowHttpProxyCallback    bool   `flag:"allow-http-proxy-callback,false,Allow proxy callback URLs over plain http, only https is allowed by default"`
+
+	OidcIssuer     string `flag:"oidc-issuer,,Issuer URL of the OIDC provider, auto detected if empty"`
+	OidcSigningKey string `flag:"oidc-signing-key,,Path of RSA private key in PEM format to sign OIDC id tokens, generated at startup if empty"`
+
+	SingleLogoutMode    string        `flag:"slo-mode,back,Single logout mode, back for back-channel or front for front-channel or none"`
+	SingleLogoutWorkers int           `flag:"slo-workers,4,Number of workers to send back-channel single logout requests"`
+	SingleLogoutRetries int           `flag:"slo-retries,3,Max retries of failed back-channel single logout request"`
+	SingleLogoutBackoff time.Duration `flag:"slo-backoff,1s,Delay before the first retry of back-channel single logout request, doubled for each following retry"`
+
+	LoginAllowedGroups string `flag:"login-allowed-groups,,Groups allowed to login separated by comma, all users are allowed if empty"`
+	GroupsAttribute    string `flag:"groups-attribute,groups,Name of the multi-valued CAS attribute to release groups of user, groups are not released if empty"`
+
+	AdminPassword string `flag:"admin-password,,Password of user 'admin' for /admin console and API with basic auth, disabled if empty"`
+
+	FaultScenarios string `flag:"fault-scenarios,,Path of fault scenarios file in YAML or JSON, scenarios can be switched at runtime with admin API"`
+	FaultScenario  string `flag:"fault-scenario,,Name of the fault scenario active at startup, no fault is injected if empty"`
+
+	TicketStore    string `flag:"ticket-store,mem,Storage backend of tickets, mem or file or redis"`
+	TicketFile     string `flag:"ticket-file,mockcas-tickets.log,Path of the append-only log for file ticket store"`
+	TicketRedisUrl string `flag:"ticket-redis-url,redis://127.0.0.1:6379/0,URL of the redis server for redis ticket store"`
+	LoginTicketKey string `flag:"login-ticket-key,,Secret key to sign login forms, should be shared by replicas with file or redis ticket store, generated at startup if empty"`
+
+	STLifetime          time.Duration `flag:"st-lifetime,10s,Max lifetime of service ticket"`
+	TGTMaxLifetime      time.Duration `flag:"tgt-max-lifetime,8h,Max lifetime of ticket granting ticket since login"`
+	TGTIdleTimeout      time.Duration `flag:"tgt-idle-timeout,2h,Ticket granting ticket expires if not used within this duration"`
+	TicketSweepInterval time.Duration `flag:"ticket-sweep-interval,1m,Interval to remove expired tickets from ticket store, 0 to disable"`
+
+	UsersFile string `flag:"users-file,users.yaml,Path of users file in YAML or JSON or htpasswd format for file authentication"`
+
+	LDAPServerUrl    string        `flag:"|ldap-server-url|ldap://127.0.0.1:3890|URL of the LDAP server"`
+	LDAPBindDN       string        `flag:"|ldap-bind-dn|cn=admin,ou=people,dc=example,dc=com|DN to bind to the LDAP server"`
+	LDAPBindPass     string        `flag:"|ldap-bind-pass|password|Password for the LDAP bind DN"`
+	LDAPBaseDN       string        `flag:"|ldap-base-dn|ou=people,dc=example,dc=com|Base DN for LDAP search"`
+	LDAPSearchFilter string        `flag:"|ldap-search-filter|(uid=%s)|Filter for LDAP search"`
+	LDAPAttributes   string        `flag:"|ldap-attributes|mail,mobile,displayName,memberOf|LDAP attributes released as CAS attributes, 'ldapName' or 'ldapName=casName' separated by comma"`
+	LDAPStartTLS     bool          `flag:"|ldap-start-tls|false|Upgrade ldap:// connections with StartTLS"`
+	LDAPCaCert       string        `flag:"|ldap-ca-cert||Path of CA certificates in PEM format to verify the LDAP server, system roots are used if empty"`
+	LDAPDialTimeout  time.Duration `flag:"|ldap-dial-timeout|5s|Timeout of connecting to the LDAP server"`
+	LDAPTimeout      time.Duration `flag:"|ldap-timeout|10s|Timeout of each LDAP request"`
+	LDAPPoolSize     int           `flag:"|ldap-pool-size|8|Max number of pooled connections to the LDAP server"`
+	LDAPCacheTTL     time.Duration `flag:"|ldap-cache-ttl|5m|Duration to cache users found in LDAP, 0 to disable cache"`
+
+	LDAPGroupMemberOf     string `flag:"|ldap-group-member-of|memberOf|Attribute of LDAP user entry with DNs of its groups, used if ldap-group-search-filter is empty"`
+	LDAPGroupBaseDN       string `flag:"|ldap-group-base-dn|ou=groups,dc=example,dc=com|Base DN for LDAP group search"`
+	LDAPGroupSearchFilter string `flag:"|ldap-group-search-filter||Filter for LDAP group search, '%s' is replaced with DN of the member, e.g. (member=%s)"`
+	LDAPGroupName         string `flag:"|ldap-group-name|cn|Attribute of LDAP group name, the first RDN value of group DN is used if missing"`
+	LDAPGroupNested       bool   `flag:"|ldap-group-nested|false|Also resolve groups which the LDAP groups are member of"`
 }
 
 var LOG *logger.Logger
@@ -57,20 +106,53 @@ func main() {
 
 	setupUserProvider(ctx)
 	setupTicketProvider(ctx)
+	setupServiceRegistry(ctx)
+	setupSingleLogout(ctx)
+	setupFaultInjector(ctx)
+	if CFG.TicketSweepInterval > 0 {
+		go TP.RunSweeper(ctx, CFG.TicketSweepInterval)
+	}
 
 	mux := httpd.NewMux()
 	mux.HandleMiddleware(LOG.NewMiddleware())
+	mux.Handle("/healthz", http.MethodGet, healthzHandler)
 	mux.Handle("/cas/login", http.MethodGet, loginPageHandler)
 	mux.Handle("/cas/login", http.MethodPost, loginCheckHandler)
 	mux.Handle("/cas/logout", http.MethodGet, logoutHandler)
 	mux.Handle("/cas/validate", http.MethodGet, validateHandler)
 	mux.Handle("/cas/p3/serviceValidate", http.MethodGet, serviceValidateHandler)
-	mux.Handle("/cas/p3/proxyValidate", http.MethodGet, proxyValidateHandler) // not implemented
+	mux.Handle("/cas/p3/proxyValidate", http.MethodGet, proxyValidateHandler)
+	mux.Handle("/cas/proxy", http.MethodGet, proxyHandler)
+	mux.Handle("/cas/samlValidate", http.MethodPost, samlValidateHandler)
+
+	mux.Handle("/oidc/.well-known/openid-configuration", http.MethodGet, oidcDiscoveryHandler)
+	mux.Handle("/oidc/jwks", http.MethodGet, oidcJwksHandler)
+	mux.Handle("/oidc/authorize", http.MethodGet, oidcAuthorizeHandler)
+	mux.Handle("/oidc/callback", http.MethodGet, oidcCallbackHandler)
+	mux.Handle("/oidc/token", http.MethodPost, oidcTokenHandler)
+	mux.Handle("/oidc/userinfo", http.MethodGet, oidcUserinfoHandler)
+	mux.Handle("/oidc/userinfo", http.MethodPost, oidcUserinfoHandler)
+
+	if CFG.AdminPassword != "" {
+		mux.Handle("/admin", http.MethodGet, adminAuth(adminPageHandler))
+		mux.Handle("/admin/api/sessions", http.MethodGet, adminAuth(adminSessionsHandler))
+		mux.Handle("/admin/api/logout", http.MethodPost, adminAuth(adminLogoutHandler))
+		mux.Handle("/admin/api/tickets", http.MethodPost, adminAuth(adminTicketsHandler))
+		mux.Handle("/admin/api/failures", http.MethodGet, adminAuth(adminFailuresHandler))
+		mux.Handle("/admin/api/cache", http.MethodDelete, adminAuth(adminCacheHandler))
+		mux.Handle("/admin/api/fault", http.MethodGet, adminAuth(adminFaultHandler))
+		mux.Handle("/admin/api/fault", http.MethodPost, adminAuth(adminFaultActivateHandler))
+		mux.Handle("/admin/api/fault", http.MethodPut, adminAuth(adminFaultSetHandler))
+		mux.Handle("/admin/api/fault", http.MethodDelete, adminAuth(adminFaultResetHandler))
+	}
 
 	mux.Handle("/app/login", http.MethodGet, appLoginHandler)
 	mux.Handle("/app/validate", http.MethodGet, appValidateHandler)
+	mux.Handle("/app/validate", http.MethodPost, appValidateHandler)
 	mux.Handle("/app/logout", http.MethodGet, appLogoutHandler)
 	mux.Handle("/app/logout", http.MethodPost, appSingleLogoutHandler)
+	mux.Handle("/app/proxyCallback", http.MethodGet, appProxyCallbackHandler)
+	mux.Handle("/app/proxy", http.MethodGet, appProxyHandler)
 
 	predictAddr := CFG.ListenAddr
 	if host, port, err := net.SplitHostPort(CFG.ListenAddr); err == nil && (host == "" || host == "0.0.0.0") {
@@ -78,23 +160,44 @@ func main() {
 			predictAddr = net.JoinHostPort(ip.String(), port)
 		}
 	}
+	predictHost, _, _ := net.SplitHostPort(predictAddr)
+	tlsConfig := setupTLS(ctx, "localhost", "127.0.0.1", "::1", predictHost)
+	scheme := "http"
+	if tlsConfig != nil {
+		scheme = "https"
+	}
 	if CFG.CasServerUrlPrefix == "" {
-		CFG.CasServerUrlPrefix = "http://" + predictAddr + "/cas"
+		CFG.CasServerUrlPrefix = scheme + "://" + predictAddr + "/cas"
 	}
 	if CFG.CasClientServiceUrl == "" {
-		CFG.CasClientServiceUrl = "http://" + predictAddr + "/app/validate"
+		CFG.CasClientServiceUrl = scheme + "://" + predictAddr + "/app/validate"
 	}
 	if CFG.CasClientLogoutUrl == "" {
-		CFG.CasClientLogoutUrl = "http://" + predictAddr + "/app/logout"
+		CFG.CasClientLogoutUrl = scheme + "://" + predictAddr + "/app/logout"
+	}
+	if CFG.CasClientProxyCallbackUrl == "" {
+		CFG.CasClientProxyCallbackUrl = scheme + "://" + predictAddr + "/app/proxyCallback"
+	}
+	if CFG.OidcIssuer == "" {
+		CFG.OidcIssuer = scheme + "://" + predictAddr + "/oidc"
 	}
 	LOG.Infof(ctx, "using cas server url prefix:  %s", CFG.CasServerUrlPrefix)
 	LOG.Infof(ctx, "using cas client service url: %s", CFG.CasClientServiceUrl)
 	LOG.Infof(ctx, "using cas client logout url:  %s", CFG.CasClientLogoutUrl)
+	LOG.Infof(ctx, "using cas client proxy url:   %s", CFG.CasClientProxyCallbackUrl)
+	LOG.Infof(ctx, "using oidc issuer:            %s", CFG.OidcIssuer)
+	setupOidcProvider(ctx)
 
-	server := &http.Server{Addr: CFG.ListenAddr, Handler: mux}
+	server := &http.Server{Addr: CFG.ListenAddr, Handler: mux, TLSConfig: tlsConfig}
 	go func() {
-		LOG.Infof(ctx, "service started: http://%s", CFG.ListenAddr)
-		if err := server.ListenAndServe(); errors.Is(err, http.ErrServerClosed) {
+		LOG.Infof(ctx, "service started: %s://%s", scheme, CFG.ListenAddr)
+		var err error
+		if tlsConfig != nil {
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if errors.Is(err, http.ErrServerClosed) {
 			LOG.Warn(ctx, "service shutting down")
 		} else if err != nil {
 			LOG.Fatal(ctx, "service start", logger.Error(err))
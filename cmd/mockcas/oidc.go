@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+)
+
+// OidcRequest is the authorization request of oidc client, it is kept in oidc tickets until the code is exchanged.
+type OidcRequest struct {
+	ClientId            string `json:"clientId"`
+	RedirectUri         string `json:"redirectUri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state,omitempty"`
+	Nonce               string `json:"nonce,omitempty"`
+	CodeChallenge       string `json:"codeChallenge,omitempty"`
+	CodeChallengeMethod string `json:"codeChallengeMethod,omitempty"` // S256 or plain
+}
+
+func (req *OidcRequest) HasScope(scope string) bool {
+	return slices.Contains(strings.Fields(req.Scope), scope)
+}
+
+// VerifyCodeVerifier checks code_verifier of token request against code_challenge of authorization request (RFC 7636).
+// It always succeeds if the client did not use PKCE, which is allowed only for clients with secret.
+func (req *OidcRequest) VerifyCodeVerifier(verifier string) bool {
+	switch req.CodeChallengeMethod {
+	case "":
+		return true
+	case "S256":
+		sum := sha256.Sum256([]byte(verifier))
+		return subtle.ConstantTimeCompare([]byte(base64.RawURLEncoding.EncodeToString(sum[:])), []byte(req.CodeChallenge)) == 1
+	case "plain":
+		return subtle.ConstantTimeCompare([]byte(verifier), []byte(req.CodeChallenge)) == 1
+	}
+	return false
+}
+
+type OidcProvider struct {
+	issuer string
+	key    *rsa.PrivateKey
+	keyID  string
+}
+
+var OP *OidcProvider
+
+func setupOidcProvider(ctx context.Context) {
+	var key *rsa.PrivateKey
+	var err error
+	if CFG.OidcSigningKey != "" {
+		if key, err = LoadRSAPrivateKey(CFG.OidcSigningKey); err != nil {
+			LOG.Fatalf(ctx, "load oidc signing key error: %v", err)
+		}
+	} else {
+		LOG.Warnf(ctx, "oidc signing key is not specified, issued id tokens are invalid after restart")
+		if key, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			LOG.Fatalf(ctx, "generate oidc signing key error: %v", err)
+		}
+	}
+	OP = NewOidcProvider(CFG.OidcIssuer, key)
+}
+
+func NewOidcProvider(issuer string, key *rsa.PrivateKey) *OidcProvider {
+	p := &OidcProvider{issuer: issuer, key: key}
+	// use the JWK thumbprint as key id (RFC 7638), the members are in lexicographic order
+	jwk := p.jwk()
+	thumbprint, _ := json.Marshal(struct {
+		E   string `json:"e"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+	}{jwk.E, jwk.Kty, jwk.N})
+	sum := sha256.Sum256(thumbprint)
+	p.keyID = base64.RawURLEncoding.EncodeToString(sum[:])
+	return p
+}
+
+// LoadRSAPrivateKey reads RSA private key from PEM file in PKCS #1 or PKCS #8 format.
+func LoadRSAPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+		return rsaKey, nil
+	}
+	return nil, errors.New("not an RSA private key")
+}
+
+// isOidcCallbackUrl reports whether service is the internal callback, which receives service ticket from /cas/login for oidc authorization.
+func isOidcCallbackUrl(service string) bool {
+	return CFG.OidcIssuer != "" && strings.HasPrefix(service, CFG.OidcIssuer+"/callback?")
+}
+
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+func (p *OidcProvider) jwk() JSONWebKey {
+	return JSONWebKey{
+		Kty: "RSA",
+		Use: "sig",
+		Alg: "RS256",
+		Kid: p.keyID,
+		N:   base64.RawURLEncoding.EncodeToString(p.key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(p.key.E)).Bytes()),
+	}
+}
+
+func (p *OidcProvider) JWKS() JSONWebKeySet {
+	return JSONWebKeySet{Keys: []JSONWebKey{p.jwk()}}
+}
+
+// SignJWT encodes claims as a JSON Web Token signed with RS256 (RFC 7519).
+func (p *OidcProvider) SignJWT(claims map[string]any) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": p.keyID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(nil, p.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+type OidcDiscovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JwksUri                           string   `json:"jwks_uri"`
+	EndSessionEndpoint                string   `json:"end_session_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+func (p *OidcProvider) Discovery() OidcDiscovery {
+	return OidcDiscovery{
+		Issuer:                            p.issuer,
+		AuthorizationEndpoint:             p.issuer + "/authorize",
+		TokenEndpoint:                     p.issuer + "/token",
+		UserinfoEndpoint:                  p.issuer + "/userinfo",
+		JwksUri:                           p.issuer + "/jwks",
+		EndSessionEndpoint:                CFG.CasServerUrlPrefix + "/logout",
+		ScopesSupported:                   []string{"openid", "profile", "email", "phone", "groups"},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{"RS256"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256", "plain"},
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "preferred_username", "name", "email", "phone_number", "groups"},
+	}
+}
+
+// oidcScopeClaims maps scope to its standard claims, and each claim is taken from the first value of a user attribute.
+var oidcScopeClaims = map[string]map[string]string{ // scope => claim name => user attribute name
+	"profile": {"name": "displayName"},
+	"email":   {"email": "mail"},
+	"phone":   {"phone_number": "mobile"},
+}
+
+// oidcUserClaims returns claims about user allowed by scope of req, which are shared by id token and userinfo response.
+func oidcUserClaims(user *User, req *OidcRequest) map[string]any {
+	claims := map[string]any{"sub": user.Username}
+	for scope, mapping := range oidcScopeClaims {
+		if !req.HasScope(scope) {
+			continue
+		}
+		for claim, attr := range mapping {
+			if values := user.Attributes[attr]; len(values) > 0 {
+				claims[claim] = values[0]
+			}
+		}
+	}
+	if req.HasScope("profile") {
+		claims["preferred_username"] = user.Username
+	}
+	if req.HasScope("groups") && len(user.Groups) > 0 {
+		claims["groups"] = user.Groups
+	}
+	return claims
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/whoisnian/glb/httpd"
+	"github.com/whoisnian/glb/logger"
+)
+
+const (
+	oidcRequestLifetime     = 10 * time.Minute // user should login within this duration after authorization request
+	oidcCodeLifetime        = time.Minute
+	oidcAccessTokenLifetime = time.Hour
+)
+
+// OidcError is the error response of token and userinfo endpoints (RFC 6749 section 5.2).
+type OidcError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+type OidcTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+func oidcDiscoveryHandler(store *httpd.Store) {
+	store.RespondJson(http.StatusOK, OP.Discovery())
+}
+
+func oidcJwksHandler(store *httpd.Store) {
+	store.RespondJson(http.StatusOK, OP.JWKS())
+}
+
+// oidcAuthorizeHandler starts authorization code flow. The authorization request is saved as a ticket, and the user is sent to
+// /cas/login with the internal callback as service, so the login page and ticket granting cookie are shared with CAS protocol.
+func oidcAuthorizeHandler(store *httpd.Store) {
+	query := store.R.URL.Query()
+	req := &OidcRequest{
+		ClientId:            query.Get("client_id"),
+		RedirectUri:         query.Get("redirect_uri"),
+		Scope:               query.Get("scope"),
+		State:               query.Get("state"),
+		Nonce:               query.Get("nonce"),
+		CodeChallenge:       query.Get("code_challenge"),
+		CodeChallengeMethod: query.Get("code_challenge_method"),
+	}
+	if req.ClientId == "" || req.RedirectUri == "" {
+		http.Error(store.W, "client_id or redirect_uri is empty", http.StatusBadRequest)
+		return
+	}
+	// errors are not redirected to redirect_uri until it is known to belong to the client
+	svc := SR.Find(req.RedirectUri)
+	if svc == nil || svc == oidcCallbackService || !svc.AcceptsClient(req.ClientId) {
+		http.Error(store.W, "redirect_uri "+req.RedirectUri+" is not authorized for client "+req.ClientId, http.StatusForbidden)
+		return
+	}
+
+	if query.Get("response_type") != "code" {
+		oidcRedirectError(store, req, "unsupported_response_type", "only authorization code flow is supported")
+		return
+	} else if !req.HasScope("openid") {
+		oidcRedirectError(store, req, "invalid_scope", "openid scope is required")
+		return
+	}
+	if req.CodeChallenge != "" && req.CodeChallengeMethod == "" {
+		req.CodeChallengeMethod = "plain"
+	}
+	if (req.CodeChallenge == "") != (req.CodeChallengeMethod == "") || (req.CodeChallengeMethod != "" && req.CodeChallengeMethod != "S256" && req.CodeChallengeMethod != "plain") {
+		oidcRedirectError(store, req, "invalid_request", "invalid code_challenge or code_challenge_method")
+		return
+	}
+	if svc.ClientSecret == "" && req.CodeChallengeMethod != "S256" { // authorization code of public client can be redeemed by anyone who intercepts it
+		oidcRedirectError(store, req, "invalid_request", "code_challenge with S256 method is required for public client")
+		return
+	}
+
+	ticket, _, err := TP.GenerateOidcTicket(store.R.Context(), OidcRequestPrefix, &TicketData{OIDC: req}, oidcRequestLifetime)
+	if err != nil {
+		LOG.Error(store.R.Context(), "generate oidc ticket error", logger.Error(err))
+		store.Error500("generate oidc ticket error")
+		return
+	}
+	loginQuery := url.Values{"service": {oidcCallbackUrl(ticket)}}
+	if prompt := strings.Fields(query.Get("prompt")); slices.Contains(prompt, "login") {
+		loginQuery.Set("renew", "true")
+	} else if slices.Contains(prompt, "none") {
+		loginQuery.Set("gateway", "true")
+	}
+	store.Redirect(http.StatusFound, CFG.CasServerUrlPrefix+"/login?"+loginQuery.Encode())
+}
+
+func oidcCallbackUrl(request string) string {
+	return CFG.OidcIssuer + "/callback?" + url.Values{"request": {request}}.Encode()
+}
+
+func oidcRedirectError(store *httpd.Store, req *OidcRequest, code, desc string) {
+	query := url.Values{"error": {code}, "error_description": {desc}}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	store.Redirect(http.StatusFound, appendQuery(req.RedirectUri, query))
+}
+
+// oidcCallbackHandler receives service ticket from /cas/login, and redirects the user back to client with an authorization code.
+// The ticket is absent if 'prompt=none' is requested and the user has no single sign-on session.
+func oidcCallbackHandler(store *httpd.Store) {
+	request := store.R.URL.Query().Get("request")
+	ticket := store.R.URL.Query().Get("ticket")
+	if request == "" {
+		http.Error(store.W, "request is empty", http.StatusBadRequest)
+		return
+	} else if ticket == "" {
+		if reqData, err := TP.ValidateOidcTicket(store.R.Context(), request, OidcRequestPrefix, true); err == nil {
+			oidcRedirectError(store, reqData.OIDC, "login_required", "user is not logged in")
+		} else {
+			http.Error(store.W, "authorization request is invalid or expired", http.StatusBadRequest)
+		}
+		return
+	}
+
+	user, tktData, err := TP.ValidateServiceTicket(store.R.Context(), ticket, oidcCallbackUrl(request), false)
+	if err != nil {
+		if !isTicketValidationError(err) {
+			LOG.Warnf(store.R.Context(), "validate ticket error: %v", err)
+		}
+		http.Error(store.W, "ticket "+ticket+" is invalid", http.StatusBadRequest)
+		return
+	}
+	reqData, err := TP.ValidateOidcTicket(store.R.Context(), request, OidcRequestPrefix, true)
+	if err != nil {
+		if !errors.Is(err, InvalidTicket) {
+			LOG.Warnf(store.R.Context(), "validate oidc ticket error: %v", err)
+		}
+		http.Error(store.W, "authorization request is invalid or expired", http.StatusBadRequest)
+		return
+	}
+	req := reqData.OIDC
+	if err = CheckLoginAllowed(user); err != nil {
+		oidcRedirectError(store, req, "access_denied", "user "+user.Username+" is not allowed to login")
+		return
+	}
+	if _, err = SR.CheckAccess(req.RedirectUri, user); err != nil {
+		oidcRedirectError(store, req, "access_denied", "user "+user.Username+" is not authorized to access client "+req.ClientId)
+		return
+	}
+
+	code, _, err := TP.GenerateOidcTicket(store.R.Context(), OidcCodePrefix, &TicketData{
+		Username: user.Username,
+		Service:  req.RedirectUri,
+		Granting: tktData.Granting,
+		AuthTime: tktData.AuthTime,
+		NewLogin: tktData.NewLogin,
+		OIDC:     req,
+	}, oidcCodeLifetime)
+	if err != nil {
+		LOG.Error(store.R.Context(), "generate oidc ticket error", logger.Error(err))
+		store.Error500("generate oidc ticket error")
+		return
+	}
+	query := url.Values{"code": {code}}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	store.Redirect(http.StatusFound, appendQuery(req.RedirectUri, query))
+}
+
+// oidcTokenHandler exchanges authorization code for access token and id token.
+// Confidential clients authenticate with client_secret_basic or client_secret_post, and public clients should use PKCE.
+func oidcTokenHandler(store *httpd.Store) {
+	store.W.Header().Set("Cache-Control", "no-store")
+	if grantType := store.R.PostFormValue("grant_type"); grantType != "authorization_code" {
+		store.RespondJson(http.StatusBadRequest, OidcError{"unsupported_grant_type", "grant_type " + grantType + " is not supported"})
+		return
+	}
+	clientId, clientSecret, ok := store.R.BasicAuth()
+	if !ok {
+		clientId, clientSecret = store.R.PostFormValue("client_id"), store.R.PostFormValue("client_secret")
+	}
+	code := store.R.PostFormValue("code")
+	redirectUri := store.R.PostFormValue("redirect_uri")
+	if clientId == "" || code == "" || redirectUri == "" {
+		store.RespondJson(http.StatusBadRequest, OidcError{"invalid_request", "client_id, code and redirect_uri are all required"})
+		return
+	}
+
+	svc := SR.Find(redirectUri)
+	if svc == nil || svc == oidcCallbackService || !svc.AcceptsClient(clientId) ||
+		(svc.ClientSecret != "" && subtle.ConstantTimeCompare([]byte(svc.ClientSecret), []byte(clientSecret)) != 1) {
+		store.W.Header().Set("WWW-Authenticate", `Basic realm="mockcas"`)
+		store.RespondJson(http.StatusUnauthorized, OidcError{"invalid_client", "client authentication failed"})
+		return
+	}
+	data, err := TP.ValidateOidcTicket(store.R.Context(), code, OidcCodePrefix, true)
+	if err != nil {
+		if !errors.Is(err, InvalidTicket) {
+			LOG.Warnf(store.R.Context(), "validate oidc ticket error: %v", err)
+		}
+		store.RespondJson(http.StatusBadRequest, OidcError{"invalid_grant", "code is invalid or expired"})
+		return
+	}
+	req := data.OIDC
+	if req.ClientId != clientId || req.RedirectUri != redirectUri {
+		store.RespondJson(http.StatusBadRequest, OidcError{"invalid_grant", "code was issued to another client or redirect_uri"})
+		return
+	} else if !req.VerifyCodeVerifier(store.R.PostFormValue("code_verifier")) {
+		store.RespondJson(http.StatusBadRequest, OidcError{"invalid_grant", "code_verifier does not match code_challenge"})
+		return
+	}
+	user, err := UP.FindUser(store.R.Context(), data.Username)
+	if err != nil {
+		LOG.Warnf(store.R.Context(), "find user error: %v", err)
+		store.RespondJson(http.StatusBadRequest, OidcError{"invalid_grant", "user " + data.Username + " is not available"})
+		return
+	}
+
+	accessToken, expiresIn, err := TP.GenerateOidcTicket(store.R.Context(), OidcAccessTokenPrefix, &TicketData{
+		Username: data.Username,
+		Service:  data.Service,
+		Granting: data.Granting,
+		AuthTime: data.AuthTime,
+		OIDC:     req,
+	}, oidcAccessTokenLifetime)
+	if err != nil {
+		LOG.Error(store.R.Context(), "generate oidc ticket error", logger.Error(err))
+		store.Error500("generate oidc ticket error")
+		return
+	}
+
+	now := time.Now()
+	claims := oidcUserClaims(svc.ReleasedUser(user), req)
+	claims["iss"] = OP.issuer
+	claims["aud"] = clientId
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(expiresIn).Unix()
+	claims["auth_time"] = data.AuthTime.Unix()
+	if req.Nonce != "" {
+		claims["nonce"] = req.Nonce
+	}
+	idToken, err := OP.SignJWT(claims)
+	if err != nil {
+		LOG.Error(store.R.Context(), "sign id token error", logger.Error(err))
+		store.Error500("sign id token error")
+		return
+	}
+	store.RespondJson(http.StatusOK, OidcTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(expiresIn / time.Second),
+		IDToken:     idToken,
+		Scope:       req.Scope,
+	})
+}
+
+// oidcUserinfoHandler returns claims about the user of bearer access token.
+func oidcUserinfoHandler(store *httpd.Store) {
+	token, found := strings.CutPrefix(store.R.Header.Get("Authorization"), "Bearer ")
+	if !found || token == "" {
+		store.W.Header().Set("WWW-Authenticate", `Bearer realm="mockcas"`)
+		store.RespondJson(http.StatusUnauthorized, OidcError{"invalid_request", "bearer access token is required"})
+		return
+	}
+	var user *User
+	var svc *RegisteredService
+	data, err := TP.ValidateOidcTicket(store.R.Context(), token, OidcAccessTokenPrefix, false)
+	if err == nil {
+		if user, err = UP.FindUser(store.R.Context(), data.Username); err == nil {
+			svc, err = SR.CheckAccess(data.Service, user)
+		}
+	}
+	if err != nil {
+		if !isTicketValidationError(err) {
+			LOG.Warnf(store.R.Context(), "validate access token error: %v", err)
+		}
+		store.W.Header().Set("WWW-Authenticate", `Bearer realm="mockcas", error="invalid_token"`)
+		store.RespondJson(http.StatusUnauthorized, OidcError{"invalid_token", "access token is invalid or expired"})
+		return
+	}
+	store.RespondJson(http.StatusOK, oidcUserClaims(svc.ReleasedUser(user), data.OIDC))
+}
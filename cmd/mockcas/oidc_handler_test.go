@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const testRedirectUri = "https://client.example.com/callback"
+
+func setupOidcTestServer(t *testing.T) (*httptest.Server, *http.Client) {
+	t.Helper()
+	srv := setupTestServer(t)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key error: %v", err)
+	}
+	casServerUrlPrefix, oidcIssuer := CFG.CasServerUrlPrefix, CFG.OidcIssuer
+	t.Cleanup(func() { CFG.CasServerUrlPrefix, CFG.OidcIssuer = casServerUrlPrefix, oidcIssuer })
+	CFG.CasServerUrlPrefix = srv.URL + "/cas"
+	CFG.OidcIssuer = srv.URL + "/oidc"
+	OP = NewOidcProvider(CFG.OidcIssuer, key)
+
+	return srv, newTestBrowser()
+}
+
+func oidcAuthorize(t *testing.T, srv *httptest.Server, client *http.Client, verifier string, login bool) (code string) {
+	t.Helper()
+	sum := sha256.Sum256([]byte(verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"client"},
+		"redirect_uri":          {testRedirectUri},
+		"scope":                 {"openid profile email"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/oidc/authorize?"+query.Encode(), nil)
+	location := expectRedirect(t, client, req)
+	if location.Path != "/cas/login" || !isOidcCallbackUrl(location.Query().Get("service")) {
+		t.Fatalf("authorize redirected to %s, want /cas/login with oidc callback", location)
+	}
+
+	if login {
+		req = newLoginRequest(t, client, srv.URL+location.RequestURI(), "casuser", "Mellon")
+	} else {
+		req, _ = http.NewRequest(http.MethodGet, srv.URL+location.RequestURI(), nil)
+	}
+	location = expectRedirect(t, client, req)
+	if location.Path != "/oidc/callback" || location.Query().Get("ticket") == "" {
+		t.Fatalf("login redirected to %s, want oidc callback with ticket", location)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+location.RequestURI(), nil)
+	location = expectRedirect(t, client, req)
+	if got := location.Scheme + "://" + location.Host + location.Path; got != testRedirectUri || location.Query().Get("state") != "xyz" {
+		t.Fatalf("callback redirected to %s, want %s with state", location, testRedirectUri)
+	}
+	return location.Query().Get("code")
+}
+
+func oidcToken(t *testing.T, srv *httptest.Server, code, verifier string) (int, map[string]any) {
+	t.Helper()
+	resp, err := http.PostForm(srv.URL+"/oidc/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"client"},
+		"code":          {code},
+		"redirect_uri":  {testRedirectUri},
+		"code_verifier": {verifier},
+	})
+	if err != nil {
+		t.Fatalf("token request error: %v", err)
+	}
+	defer resp.Body.Close()
+	var result map[string]any
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("decode token response error: %v", err)
+	}
+	return resp.StatusCode, result
+}
+
+// verifyIDToken checks signature of id token with the published JWKS, and returns its claims.
+func verifyIDToken(t *testing.T, srv *httptest.Server, idToken string) map[string]any {
+	t.Helper()
+	var jwks JSONWebKeySet
+	if err := json.Unmarshal([]byte(httpGetString(t, srv.URL+"/oidc/jwks")), &jwks); err != nil || len(jwks.Keys) != 1 {
+		t.Fatalf("unmarshal jwks error: %v", err)
+	}
+	n, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[0].N)
+	e, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[0].E)
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		t.Fatalf("id token has %d parts, want 3", len(parts))
+	}
+	var header map[string]string
+	data, _ := base64.RawURLEncoding.DecodeString(parts[0])
+	if err := json.Unmarshal(data, &header); err != nil || header["alg"] != "RS256" || header["kid"] != jwks.Keys[0].Kid {
+		t.Fatalf("unexpected id token header %s", data)
+	}
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig); err != nil {
+		t.Fatalf("verify id token signature error: %v", err)
+	}
+	var claims map[string]any
+	data, _ = base64.RawURLEncoding.DecodeString(parts[1])
+	if err := json.Unmarshal(data, &claims); err != nil {
+		t.Fatalf("unmarshal id token claims error: %v", err)
+	}
+	return claims
+}
+
+func TestOidcFlow(t *testing.T) {
+	srv, client := setupOidcTestServer(t)
+
+	var discovery OidcDiscovery
+	if err := json.Unmarshal([]byte(httpGetString(t, srv.URL+"/oidc/.well-known/openid-configuration")), &discovery); err != nil {
+		t.Fatalf("unmarshal discovery error: %v", err)
+	}
+	if discovery.Issuer != CFG.OidcIssuer || discovery.TokenEndpoint != CFG.OidcIssuer+"/token" {
+		t.Fatalf("unexpected discovery %+v", discovery)
+	}
+
+	// prompt=none without session is rejected with login_required
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/oidc/authorize?"+url.Values{
+		"response_type": {"code"}, "client_id": {"client"}, "redirect_uri": {testRedirectUri}, "scope": {"openid"}, "prompt": {"none"},
+		"code_challenge": {"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"}, "code_challenge_method": {"S256"},
+	}.Encode(), nil)
+	location := expectRedirect(t, client, req)
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+location.RequestURI(), nil)
+	location = expectRedirect(t, client, req)
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+location.RequestURI(), nil)
+	if location = expectRedirect(t, client, req); location.Query().Get("error") != "login_required" {
+		t.Fatalf("prompt=none redirected to %s, want login_required error", location)
+	}
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	code := oidcAuthorize(t, srv, client, verifier, true)
+	if status, result := oidcToken(t, srv, code, "wrong-verifier"); status != http.StatusBadRequest || result["error"] != "invalid_grant" {
+		t.Fatalf("token with wrong verifier got %d %v, want invalid_grant", status, result)
+	}
+
+	code = oidcAuthorize(t, srv, client, verifier, false) // single sign-on with ticket granting cookie
+	status, result := oidcToken(t, srv, code, verifier)
+	if status != http.StatusOK || result["token_type"] != "Bearer" {
+		t.Fatalf("token got %d %v", status, result)
+	}
+	if status, result := oidcToken(t, srv, code, verifier); status != http.StatusBadRequest || result["error"] != "invalid_grant" {
+		t.Fatalf("token with used code got %d %v, want invalid_grant", status, result)
+	}
+
+	claims := verifyIDToken(t, srv, result["id_token"].(string))
+	for name, want := range map[string]any{
+		"iss":                CFG.OidcIssuer,
+		"sub":                "casuser",
+		"aud":                "client",
+		"nonce":              "n-0S6",
+		"email":              "casuser@example.org",
+		"name":               "CAS User",
+		"preferred_username": "casuser",
+	} {
+		if claims[name] != want {
+			t.Fatalf("id token claim %s = %v, want %v", name, claims[name], want)
+		}
+	}
+	if _, ok := claims["phone_number"]; ok {
+		t.Fatalf("id token should not contain phone_number without phone scope")
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+"/oidc/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+result["access_token"].(string))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("userinfo request error: %v", err)
+	}
+	var userinfo map[string]any
+	json.NewDecoder(resp.Body).Decode(&userinfo)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || userinfo["sub"] != "casuser" || userinfo["email"] != "casuser@example.org" {
+		t.Fatalf("userinfo got %d %v", resp.StatusCode, userinfo)
+	}
+
+	// logout of CAS revokes access tokens granted by the same ticket granting ticket
+	logoutReq, _ := http.NewRequest(http.MethodGet, srv.URL+"/cas/logout", nil)
+	if resp, err = client.Do(logoutReq); err != nil {
+		t.Fatalf("logout request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatalf("userinfo request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("userinfo after logout got %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestOidcAuthorizeRejectsUnregisteredClient(t *testing.T) {
+	srv, client := setupOidcTestServer(t)
+	var err error
+	SR, err = ParseServiceRegistry([]byte(`
+services:
+  - name: client
+    pattern: https://client\.example\.com/.*
+    clientId: client
+`))
+	if err != nil {
+		t.Fatalf("ParseServiceRegistry error: %v", err)
+	}
+
+	for _, query := range []url.Values{
+		{"response_type": {"code"}, "client_id": {"other"}, "redirect_uri": {testRedirectUri}, "scope": {"openid"}},
+		{"response_type": {"code"}, "client_id": {"client"}, "redirect_uri": {"https://evil.example.com/"}, "scope": {"openid"}},
+	} {
+		resp, err := client.Get(srv.URL + "/oidc/authorize?" + query.Encode())
+		if err != nil {
+			t.Fatalf("authorize request error: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("authorize %v got %d, want 403", query, resp.StatusCode)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/oidc/authorize?"+url.Values{
+		"response_type": {"code"}, "client_id": {"client"}, "redirect_uri": {testRedirectUri}, "scope": {"profile"}, "state": {"s"},
+	}.Encode(), nil)
+	location := expectRedirect(t, client, req)
+	if location.Query().Get("error") != "invalid_scope" || location.Query().Get("state") != "s" {
+		t.Fatalf("authorize without openid scope redirected to %s, want invalid_scope error", location)
+	}
+}
+
+func TestOidcAuthorizeRequiresPkceForPublicClient(t *testing.T) {
+	srv, client := setupOidcTestServer(t)
+	var err error
+	SR, err = ParseServiceRegistry([]byte(`
+services:
+  - name: public
+    pattern: https://client\.example\.com/.*
+    clientId: client
+  - name: confidential
+    pattern: https://confidential\.example\.com/.*
+    clientId: confidential
+    clientSecret: secret
+`))
+	if err != nil {
+		t.Fatalf("ParseServiceRegistry error: %v", err)
+	}
+
+	tests := []struct {
+		clientId, redirectUri, challengeMethod string
+		wantError                              string
+	}{
+		{"client", testRedirectUri, "", "invalid_request"},
+		{"client", testRedirectUri, "plain", "invalid_request"},
+		{"client", testRedirectUri, "S256", ""},
+		{"confidential", "https://confidential.example.com/callback", "", ""},
+	}
+	for _, test := range tests {
+		query := url.Values{"response_type": {"code"}, "client_id": {test.clientId}, "redirect_uri": {test.redirectUri}, "scope": {"openid"}}
+		if test.challengeMethod != "" {
+			query.Set("code_challenge", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM")
+			query.Set("code_challenge_method", test.challengeMethod)
+		}
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/oidc/authorize?"+query.Encode(), nil)
+		location := expectRedirect(t, client, req)
+		if got := location.Query().Get("error"); got != test.wantError {
+			t.Errorf("authorize %s with challenge method %q got error %q, want %q", test.clientId, test.challengeMethod, got, test.wantError)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	samlTimeFormat         = "2006-01-02T15:04:05.000Z"
+	samlAttributeNamespace = "http://www.ja-sig.org/products/cas/"
+	samlAssertionLifetime  = 30 * time.Second
+)
+
+// Example SOAP request for SAML 1.1 ticket validation:
+//
+//	<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/">
+//	  <SOAP-ENV:Header/>
+//	  <SOAP-ENV:Body>
+//	    <samlp:Request xmlns:samlp="urn:oasis:names:tc:SAML:1.0:protocol" MajorVersion="1" MinorVersion="1" RequestID="_192.168.16.51.1024506224022" IssueInstant="2026-01-04T14:25:34.123Z">
+//	      <samlp:AssertionArtifact>ST-2-VKZLNNLFTRAZM2OBO34H6DMFFPGFFKJR-tn58fw</samlp:AssertionArtifact>
+//	    </samlp:Request>
+//	  </SOAP-ENV:Body>
+//	</SOAP-ENV:Envelope>
+type SamlValidateRequest struct {
+	XMLName xml.Name `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
+	Body    struct {
+		Request struct {
+			RequestID         string `xml:"RequestID,attr"`
+			AssertionArtifact string `xml:"urn:oasis:names:tc:SAML:1.0:protocol AssertionArtifact"`
+		} `xml:"urn:oasis:names:tc:SAML:1.0:protocol Request"`
+	} `xml:"http://schemas.xmlsoap.org/soap/envelope/ Body"`
+}
+
+func decodeSamlValidateRequest(data []byte) (*SamlValidateRequest, error) {
+	var req SamlValidateRequest
+	if err := xml.Unmarshal(data, &req); err != nil {
+		return nil, err
+	}
+	if req.Body.Request.AssertionArtifact == "" {
+		return nil, errors.New("assertion artifact is empty")
+	}
+	return &req, nil
+}
+
+// Example SOAP response for SAML 1.1 ticket validation:
+//
+//	<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/">
+//	  <SOAP-ENV:Header></SOAP-ENV:Header>
+//	  <SOAP-ENV:Body>
+//	    <samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:1.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:1.0:assertion" ResponseID="_5c94b5431c540365e5a70b2874b75996" InResponseTo="_192.168.16.51.1024506224022" IssueInstant="2026-01-04T14:25:35.817Z" MajorVersion="1" MinorVersion="1" Recipient="https://app.example.com/">
+//	      <samlp:Status>
+//	        <samlp:StatusCode Value="samlp:Success"></samlp:StatusCode>
+//	      </samlp:Status>
+//	      <saml:Assertion AssertionID="_e5c23ff7a3889e12fa01802a47331653" IssueInstant="2026-01-04T14:25:35.817Z" Issuer="mockcas" MajorVersion="1" MinorVersion="1">
+//	        <saml:Conditions NotBefore="2026-01-04T14:25:35.817Z" NotOnOrAfter="2026-01-04T14:26:05.817Z">
+//	          <saml:AudienceRestrictionCondition>
+//	            <saml:Audience>https://app.example.com/</saml:Audience>
+//	          </saml:AudienceRestrictionCondition>
+//	        </saml:Conditions>
+//	        <saml:AttributeStatement>
+//	          <saml:Subject>...</saml:Subject>
+//	          <saml:Attribute AttributeName="mail" AttributeNamespace="http://www.ja-sig.org/products/cas/">
+//	            <saml:AttributeValue>casuser@example.org</saml:AttributeValue>
+//	          </saml:Attribute>
+//	        </saml:AttributeStatement>
+//	        <saml:AuthenticationStatement AuthenticationInstant="2026-01-04T14:25:34.123Z" AuthenticationMethod="urn:oasis:names:tc:SAML:1.0:am:password">
+//	          <saml:Subject>
+//	            <saml:NameIdentifier>casuser</saml:NameIdentifier>
+//	            <saml:SubjectConfirmation>
+//	              <saml:ConfirmationMethod>urn:oasis:names:tc:SAML:1.0:cm:artifact</saml:ConfirmationMethod>
+//	            </saml:SubjectConfirmation>
+//	          </saml:Subject>
+//	        </saml:AuthenticationStatement>
+//	      </saml:Assertion>
+//	    </samlp:Response>
+//	  </SOAP-ENV:Body>
+//	</SOAP-ENV:Envelope>
+//
+// On failure, the StatusCode is samlp:Requester or samlp:Responder with a StatusMessage, and there is no Assertion.
+type SamlValidateResponse struct {
+	XMLName xml.Name     `xml:"SOAP-ENV:Envelope"`
+	Xmlns   string       `xml:"xmlns:SOAP-ENV,attr"`
+	Header  struct{}     `xml:"SOAP-ENV:Header"`
+	Body    SamlResponse `xml:"SOAP-ENV:Body>samlp:Response"`
+}
+
+type SamlResponse struct {
+	XmlnsSamlp   string         `xml:"xmlns:samlp,attr"`
+	XmlnsSaml    string         `xml:"xmlns:saml,attr"`
+	ResponseID   string         `xml:"ResponseID,attr"`
+	InResponseTo string         `xml:"InResponseTo,attr,omitempty"`
+	IssueInstant string         `xml:"IssueInstant,attr"`
+	MajorVersion int            `xml:"MajorVersion,attr"`
+	MinorVersion int            `xml:"MinorVersion,attr"`
+	Recipient    string         `xml:"Recipient,attr,omitempty"`
+	Status       SamlStatus     `xml:"samlp:Status"`
+	Assertion    *SamlAssertion `xml:"saml:Assertion,omitempty"`
+}
+
+type SamlStatus struct {
+	StatusCode    SamlStatusCode `xml:"samlp:StatusCode"`
+	StatusMessage string         `xml:"samlp:StatusMessage,omitempty"`
+}
+
+type SamlStatusCode struct {
+	Value string `xml:"Value,attr"`
+}
+
+type SamlAssertion struct {
+	AssertionID             string                      `xml:"AssertionID,attr"`
+	IssueInstant            string                      `xml:"IssueInstant,attr"`
+	Issuer                  string                      `xml:"Issuer,attr"`
+	MajorVersion            int                         `xml:"MajorVersion,attr"`
+	MinorVersion            int                         `xml:"MinorVersion,attr"`
+	Conditions              SamlConditions              `xml:"saml:Conditions"`
+	AttributeStatement      SamlAttributeStatement      `xml:"saml:AttributeStatement"`
+	AuthenticationStatement SamlAuthenticationStatement `xml:"saml:AuthenticationStatement"`
+}
+
+type SamlConditions struct {
+	NotBefore    string `xml:"NotBefore,attr"`
+	NotOnOrAfter string `xml:"NotOnOrAfter,attr"`
+	Audience     string `xml:"saml:AudienceRestrictionCondition>saml:Audience"`
+}
+
+type SamlSubject struct {
+	NameIdentifier     string `xml:"saml:NameIdentifier"`
+	ConfirmationMethod string `xml:"saml:SubjectConfirmation>saml:ConfirmationMethod"`
+}
+
+type SamlAttributeStatement struct {
+	Subject    SamlSubject     `xml:"saml:Subject"`
+	Attributes []SamlAttribute `xml:"saml:Attribute"`
+}
+
+type SamlAttribute struct {
+	AttributeName      string   `xml:"AttributeName,attr"`
+	AttributeNamespace string   `xml:"AttributeNamespace,attr"`
+	AttributeValues    []string `xml:"saml:AttributeValue"`
+}
+
+type SamlAuthenticationStatement struct {
+	AuthenticationInstant string      `xml:"AuthenticationInstant,attr"`
+	AuthenticationMethod  string      `xml:"AuthenticationMethod,attr"`
+	Subject               SamlSubject `xml:"saml:Subject"`
+}
+
+func newSamlID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return "_" + hex.EncodeToString(buf)
+}
+
+func newSamlValidateResponse(requestID, recipient string) SamlValidateResponse {
+	return SamlValidateResponse{
+		Xmlns: "http://schemas.xmlsoap.org/soap/envelope/",
+		Body: SamlResponse{
+			XmlnsSamlp:   "urn:oasis:names:tc:SAML:1.0:protocol",
+			XmlnsSaml:    "urn:oasis:names:tc:SAML:1.0:assertion",
+			ResponseID:   newSamlID(),
+			InResponseTo: requestID,
+			IssueInstant: time.Now().UTC().Format(samlTimeFormat),
+			MajorVersion: 1,
+			MinorVersion: 1,
+			Recipient:    recipient,
+		},
+	}
+}
+
+// encodeSamlValidateFailure encodes failure with code samlp:Requester for invalid request, or samlp:Responder for invalid ticket.
+func encodeSamlValidateFailure(requestID, recipient, code, message string) ([]byte, error) {
+	resp := newSamlValidateResponse(requestID, recipient)
+	resp.Body.Status = SamlStatus{StatusCode: SamlStatusCode{Value: code}, StatusMessage: message}
+	return xml.MarshalIndent(resp, "", "  ")
+}
+
+func encodeSamlValidateSuccess(requestID, recipient string, user *User, data *TicketData) ([]byte, error) {
+	now := time.Now().UTC()
+	subject := SamlSubject{NameIdentifier: user.Username, ConfirmationMethod: "urn:oasis:names:tc:SAML:1.0:cm:artifact"}
+	attributes := []SamlAttribute{
+		{"authenticationDate", samlAttributeNamespace, []string{data.AuthTime.UTC().Format(time.RFC3339Nano)}},
+		{"longTermAuthenticationRequestTokenUsed", samlAttributeNamespace, []string{"false"}},
+		{"isFromNewLogin", samlAttributeNamespace, []string{strconv.FormatBool(data.NewLogin)}},
+	}
+	for _, name := range slices.Sorted(maps.Keys(user.Attributes)) {
+		attributes = append(attributes, SamlAttribute{name, samlAttributeNamespace, user.Attributes[name]})
+	}
+
+	resp := newSamlValidateResponse(requestID, recipient)
+	resp.Body.Status = SamlStatus{StatusCode: SamlStatusCode{Value: "samlp:Success"}}
+	resp.Body.Assertion = &SamlAssertion{
+		AssertionID:  newSamlID(),
+		IssueInstant: now.Format(samlTimeFormat),
+		Issuer:       "mockcas",
+		MajorVersion: 1,
+		MinorVersion: 1,
+		Conditions: SamlConditions{
+			NotBefore:    now.Format(samlTimeFormat),
+			NotOnOrAfter: now.Add(samlAssertionLifetime).Format(samlTimeFormat),
+			Audience:     recipient,
+		},
+		AttributeStatement: SamlAttributeStatement{Subject: subject, Attributes: attributes},
+		AuthenticationStatement: SamlAuthenticationStatement{
+			AuthenticationInstant: data.AuthTime.UTC().Format(samlTimeFormat),
+			AuthenticationMethod:  "urn:oasis:names:tc:SAML:1.0:am:password",
+			Subject:               subject,
+		},
+	}
+	return xml.MarshalIndent(resp, "", "  ")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const testSamlValidateRequest = `<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/">
+  <SOAP-ENV:Header/>
+  <SOAP-ENV:Body>
+    <samlp:Request xmlns:samlp="urn:oasis:names:tc:SAML:1.0:protocol" MajorVersion="1" MinorVersion="1" RequestID="_192.168.16.51.1024506224022" IssueInstant="2026-01-04T14:25:34.123Z">
+      <samlp:AssertionArtifact>%s</samlp:AssertionArtifact>
+    </samlp:Request>
+  </SOAP-ENV:Body>
+</SOAP-ENV:Envelope>`
+
+type testSamlResponse struct {
+	Response struct {
+		InResponseTo string `xml:"InResponseTo,attr"`
+		StatusCode   struct {
+			Value string `xml:"Value,attr"`
+		} `xml:"Status>StatusCode"`
+		Assertion *struct {
+			Audience       string `xml:"Conditions>AudienceRestrictionCondition>Audience"`
+			NameIdentifier string `xml:"AuthenticationStatement>Subject>NameIdentifier"`
+			Attributes     []struct {
+				Name   string   `xml:"AttributeName,attr"`
+				Values []string `xml:"AttributeValue"`
+			} `xml:"AttributeStatement>Attribute"`
+		} `xml:"Assertion"`
+	} `xml:"Body>Response"`
+}
+
+func postSamlValidate(t *testing.T, srvUrl, target, ticket string) (resp testSamlResponse) {
+	t.Helper()
+	body := strings.Replace(testSamlValidateRequest, "%s", ticket, 1)
+	r, err := http.Post(srvUrl+"/cas/samlValidate?"+url.Values{"TARGET": {target}}.Encode(), "text/xml", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST samlValidate error: %v", err)
+	}
+	defer r.Body.Close()
+	data, _ := io.ReadAll(r.Body)
+	if err = xml.Unmarshal(data, &resp); err != nil {
+		t.Fatalf("unmarshal samlValidate response error: %v\n%s", err, data)
+	}
+	return resp
+}
+
+func TestSamlValidate(t *testing.T) {
+	srv := setupTestServer(t)
+	tgt, _ := TP.GenerateTicketGrantingTicket(t.Context(), "casuser")
+	st, _ := TP.GenerateServiceTicket(t.Context(), tgt, "https://legacy.example.com/", true)
+
+	resp := postSamlValidate(t, srv.URL, "https://legacy.example.com/", st)
+	if resp.Response.StatusCode.Value != "samlp:Success" || resp.Response.InResponseTo != "_192.168.16.51.1024506224022" || resp.Response.Assertion == nil {
+		t.Fatalf("samlValidate got %+v, want success", resp.Response)
+	}
+	assertion := resp.Response.Assertion
+	if assertion.Audience != "https://legacy.example.com/" || assertion.NameIdentifier != "casuser" {
+		t.Errorf("samlValidate got assertion %+v, want audience and casuser", assertion)
+	}
+	attrs := make(map[string][]string)
+	for _, attr := range assertion.Attributes {
+		attrs[attr.Name] = attr.Values
+	}
+	if got := attrs["isFromNewLogin"]; len(got) != 1 || got[0] != "true" {
+		t.Errorf("samlValidate got isFromNewLogin %v, want [true]", got)
+	}
+	if got := attrs["memberOf"]; len(got) != 2 {
+		t.Errorf("samlValidate got memberOf %v, want 2 values", got)
+	}
+
+	resp = postSamlValidate(t, srv.URL, "https://legacy.example.com/", st)
+	if resp.Response.StatusCode.Value != "samlp:Responder" || resp.Response.Assertion != nil {
+		t.Errorf("samlValidate(consumed st) got %+v, want samlp:Responder", resp.Response)
+	}
+	resp = postSamlValidate(t, srv.URL, "https://legacy.example.com/", "")
+	if resp.Response.StatusCode.Value != "samlp:Requester" {
+		t.Errorf("samlValidate(empty artifact) got %+v, want samlp:Requester", resp.Response)
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	InvalidService      = errors.New("invalid service")
+	UnauthorizedService = errors.New("unauthorized service")
+)
+
+// RegisteredService is an entry of service registry file in YAML or JSON format, services are matched in order:
+//
+//	services:
+//	  - name: app
+//	    pattern: https://app\.example\.com/.*
+//	    allowedUsers: [casuser]
+//	    allowedGroups: [admins]
+//	    releasedAttributes: [mail]
+//	    logoutUrl: https://app.example.com/logout
+//	    clientId: app
+//	    clientSecret: secret
+//
+// The pattern is a regular expression which must match the whole service url. Empty allowedUsers and allowedGroups
+// allow all users, and a user is allowed if matching either of them. Empty releasedAttributes releases all attributes.
+// Single logout requests are sent to logoutUrl, or to the cas-client-logout-url if it is empty.
+// For oidc clients, the pattern is matched against redirect_uri. A non-empty clientId must equal client_id of requests,
+// and a non-empty clientSecret makes the client confidential, otherwise it is a public client.
+type RegisteredService struct {
+	Name               string   `json:"name"`
+	Pattern            string   `json:"pattern"`
+	AllowedUsers       []string `json:"allowedUsers"`
+	AllowedGroups      []string `json:"allowedGroups"`
+	ReleasedAttributes []string `json:"releasedAttributes"`
+	LogoutUrl          string   `json:"logoutUrl"`
+	ClientId           string   `json:"clientId"`
+	ClientSecret       string   `json:"clientSecret"`
+
+	re *regexp.Regexp
+}
+
+type ServiceRegistry struct {
+	services []*RegisteredService
+}
+
+// SR is nil if service registry file is not specified, and then only services at the origins of cas client service url
+// and cas server url prefix are allowed, or all services if allow-any-service is set.
+var SR *ServiceRegistry
+
+// defaultService is used for allowed services when service registry is not specified.
+var defaultService = &RegisteredService{Name: "default"}
+
+// oidcCallbackService is used for the internal callback of oidc authorization, whose client is checked by redirect_uri instead.
+var oidcCallbackService = &RegisteredService{Name: "oidc"}
+
+func setupServiceRegistry(ctx context.Context) {
+	if CFG.ServiceRegistry == "" {
+		if CFG.AllowAnyService {
+			LOG.Warnf(ctx, "service registry is not specified and allow-any-service is set, all services are allowed")
+		} else {
+			LOG.Infof(ctx, "service registry is not specified, only services at the origins of cas client and server urls are allowed")
+		}
+		return
+	}
+	data, err := os.ReadFile(CFG.ServiceRegistry)
+	if err != nil {
+		LOG.Fatalf(ctx, "read service registry error: %v", err)
+	}
+	if SR, err = ParseServiceRegistry(data); err != nil {
+		LOG.Fatalf(ctx, "parse service registry error: %v", err)
+	}
+	LOG.Infof(ctx, "loaded %d services from %s", len(SR.services), CFG.ServiceRegistry)
+}
+
+func ParseServiceRegistry(data []byte) (*ServiceRegistry, error) {
+	var file struct {
+		Services []*RegisteredService `json:"services"`
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, err
+	}
+	for i, svc := range file.Services {
+		if svc.Pattern == "" {
+			return nil, fmt.Errorf("service %d %q: pattern is empty", i, svc.Name)
+		}
+		re, err := regexp.Compile(`^(?:` + svc.Pattern + `)$`)
+		if err != nil {
+			return nil, fmt.Errorf("service %d %q: %w", i, svc.Name, err)
+		}
+		svc.re = re
+	}
+	return &ServiceRegistry{services: file.Services}, nil
+}
+
+// Find returns the first registered service matching service url, or nil if none matches.
+// Urls other than absolute http or https urls are never matched, even if service registry is not specified.
+func (r *ServiceRegistry) Find(service string) *RegisteredService {
+	if !isHttpUrl(service) {
+		return nil
+	}
+	if isOidcCallbackUrl(service) {
+		return oidcCallbackService
+	}
+	if r == nil {
+		if CFG.AllowAnyService || isImplicitService(service) {
+			return defaultService
+		}
+		return nil
+	}
+	for _, svc := range r.services {
+		if svc.re.MatchString(service) {
+			return svc
+		}
+	}
+	return nil
+}
+
+// isImplicitService reports whether service has the same origin as the cas client or server urls, which are allowed without service registry.
+func isImplicitService(service string) bool {
+	u, err := url.Parse(service)
+	if err != nil {
+		return false
+	}
+	for _, rawUrl := range []string{CFG.CasClientServiceUrl, CFG.CasClientProxyCallbackUrl, CFG.CasServerUrlPrefix} {
+		if origin, err := url.Parse(rawUrl); err == nil && origin.Host != "" && origin.Scheme == u.Scheme && strings.EqualFold(origin.Host, u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckAccess returns InvalidService if service is not registered, or UnauthorizedService if user is not allowed to access it.
+func (r *ServiceRegistry) CheckAccess(service string, user *User) (*RegisteredService, error) {
+	svc := r.Find(service)
+	if svc == nil {
+		return nil, InvalidService
+	}
+	if user != nil && !svc.IsAuthorized(user) {
+		return svc, UnauthorizedService
+	}
+	return svc, nil
+}
+
+func (svc *RegisteredService) IsAuthorized(user *User) bool {
+	if len(svc.AllowedUsers) == 0 && len(svc.AllowedGroups) == 0 {
+		return true
+	}
+	if slices.Contains(svc.AllowedUsers, user.Username) {
+		return true
+	}
+	for _, group := range user.Groups {
+		if slices.Contains(svc.AllowedGroups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+func (svc *RegisteredService) Releases(attr string) bool {
+	return len(svc.ReleasedAttributes) == 0 || slices.Contains(svc.ReleasedAttributes, attr)
+}
+
+// ReleasedUser returns a copy of user with only released attributes kept, and groups are released as attribute groups-attribute.
+// Groups are also dropped from the copy if groups-attribute is not released, so that the oidc groups claim follows the same rule.
+func (svc *RegisteredService) ReleasedUser(user *User) *User {
+	released := *user
+	released.Attributes = make(map[string][]string, len(user.Attributes)+1)
+	for name, values := range user.Attributes {
+		if svc.Releases(name) {
+			released.Attributes[name] = values
+		}
+	}
+	if !svc.Releases(CFG.GroupsAttribute) {
+		released.Groups = nil
+	} else if CFG.GroupsAttribute != "" && len(user.Groups) > 0 {
+		released.Attributes[CFG.GroupsAttribute] = user.Groups
+	}
+	return &released
+}
+
+// AcceptsClient reports whether clientId is allowed to use this service as redirect_uri.
+func (svc *RegisteredService) AcceptsClient(clientId string) bool {
+	return svc.ClientId == "" || svc.ClientId == clientId
+}
+
+// SingleLogoutUrl returns the url to receive single logout requests of this service.
+func (svc *RegisteredService) SingleLogoutUrl() string {
+	if svc.LogoutUrl != "" {
+		return svc.LogoutUrl
+	}
+	return CFG.CasClientLogoutUrl
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+const testServiceRegistryYAML = `
+services:
+  - name: admin
+    pattern: https://admin\.example\.com/.*
+    allowedGroups: [admins]
+    releasedAttributes: [mail]
+    logoutUrl: https://admin.example.com/logout
+  - name: app
+    pattern: https://app\.example\.com/.*
+    allowedUsers: [casuser]
+  - name: any
+    pattern: http://localhost:\d+/.*
+`
+
+func TestServiceRegistry(t *testing.T) {
+	sr, err := ParseServiceRegistry([]byte(testServiceRegistryYAML))
+	if err != nil {
+		t.Fatalf("ParseServiceRegistry() error: %v", err)
+	}
+	casuser := &User{Username: "casuser", Attributes: map[string][]string{"mail": {"casuser@example.org"}, "mobile": {"12345678910"}}}
+	admin := &User{Username: "admin", Groups: []string{"admins"}}
+
+	tests := []struct {
+		service string
+		user    *User
+		name    string
+		err     error
+	}{
+		{"https://admin.example.com/validate", admin, "admin", nil},
+		{"https://admin.example.com/validate", casuser, "admin", UnauthorizedService},
+		{"https://app.example.com/", casuser, "app", nil},
+		{"https://app.example.com/", admin, "app", UnauthorizedService},
+		{"https://app.example.com.evil.com/", casuser, "", InvalidService},
+		{"http://localhost:8080/app", admin, "any", nil},
+		{"http://localhost/app", admin, "", InvalidService},
+	}
+	for _, test := range tests {
+		svc, err := sr.CheckAccess(test.service, test.user)
+		if !errors.Is(err, test.err) || (svc != nil && svc.Name != test.name) || (svc == nil && test.name != "") {
+			t.Errorf("CheckAccess(%q, %s) = %v, %v, want %s, %v", test.service, test.user.Username, svc, err, test.name, test.err)
+		}
+	}
+
+	released := sr.Find("https://admin.example.com/validate").ReleasedUser(casuser)
+	if !reflect.DeepEqual(released.Attributes, map[string][]string{"mail": {"casuser@example.org"}}) || len(casuser.Attributes) != 2 {
+		t.Errorf("ReleasedUser() = %+v, want only mail released", released)
+	}
+	req := &OidcRequest{Scope: "openid email groups"}
+	if claims := oidcUserClaims(sr.Find("https://admin.example.com/validate").ReleasedUser(admin), req); claims["groups"] != nil {
+		t.Errorf("oidcUserClaims(admin) = %v, want no groups claim without groups released", claims)
+	}
+	if claims := oidcUserClaims(sr.Find("https://app.example.com/").ReleasedUser(admin), req); !reflect.DeepEqual(claims["groups"], []string{"admins"}) {
+		t.Errorf("oidcUserClaims(admin) = %v, want groups claim with all attributes released", claims)
+	}
+	if got := sr.Find("https://admin.example.com/validate").SingleLogoutUrl(); got != "https://admin.example.com/logout" {
+		t.Errorf("SingleLogoutUrl() = %q, want %q", got, "https://admin.example.com/logout")
+	}
+
+	if _, err := ParseServiceRegistry([]byte("services:\n  - name: bad\n    pattern: '('\n")); err == nil {
+		t.Errorf("ParseServiceRegistry(invalid pattern) error = nil, want error")
+	}
+
+	// without service registry, only origins of cas client and server urls are allowed unless allow-any-service is set
+	clientUrl, serverUrl := CFG.CasClientServiceUrl, CFG.CasServerUrlPrefix
+	t.Cleanup(func() {
+		CFG.CasClientServiceUrl, CFG.CasServerUrlPrefix, CFG.AllowAnyService = clientUrl, serverUrl, false
+	})
+	CFG.CasClientServiceUrl, CFG.CasServerUrlPrefix = "http://192.168.1.2:9090/app/validate", "https://cas.example.com/cas"
+	var nilRegistry *ServiceRegistry
+	for service, want := range map[string]error{
+		"http://192.168.1.2:9090/other": nil,
+		"https://CAS.example.com/app":   nil,
+		"https://192.168.1.2:9090/app":  InvalidService,
+		"http://cas.example.com/app":    InvalidService,
+		"https://any.example.com/":      InvalidService,
+	} {
+		if _, err := nilRegistry.CheckAccess(service, casuser); !errors.Is(err, want) {
+			t.Errorf("nil registry CheckAccess(%q) error = %v, want %v", service, err, want)
+		}
+	}
+	CFG.AllowAnyService = true
+	if _, err := nilRegistry.CheckAccess("https://any.example.com/", casuser); err != nil {
+		t.Errorf("nil registry CheckAccess() with allow-any-service error = %v, want nil", err)
+	}
+}
+
+func TestServiceValidateWithRegistry(t *testing.T) {
+	srv := setupTestServer(t)
+	SR, _ = ParseServiceRegistry([]byte(testServiceRegistryYAML))
+	tgt, _ := TP.GenerateTicketGrantingTicket(t.Context(), "casuser")
+
+	st, _ := TP.GenerateServiceTicket(t.Context(), tgt, "https://admin.example.com/validate", false)
+	resp := getServiceResponse(t, srv.URL+"/cas/p3/serviceValidate?"+url.Values{
+		"ticket": {st}, "service": {"https://admin.example.com/validate"}, "format": {"JSON"},
+	}.Encode())
+	if f := resp.ServiceResponse.AuthenticationFailure; f == nil || f.Code != "UNAUTHORIZED_SERVICE" {
+		t.Errorf("serviceValidate(unauthorized) got %+v, want UNAUTHORIZED_SERVICE", resp.ServiceResponse)
+	}
+
+	st, _ = TP.GenerateServiceTicket(t.Context(), tgt, "https://unknown.example.com/", false)
+	resp = getServiceResponse(t, srv.URL+"/cas/p3/serviceValidate?"+url.Values{
+		"ticket": {st}, "service": {"https://unknown.example.com/"}, "format": {"JSON"},
+	}.Encode())
+	if f := resp.ServiceResponse.AuthenticationFailure; f == nil || f.Code != "INVALID_SERVICE" {
+		t.Errorf("serviceValidate(unknown) got %+v, want INVALID_SERVICE", resp.ServiceResponse)
+	}
+
+	st, _ = TP.GenerateServiceTicket(t.Context(), tgt, "https://app.example.com/", false)
+	resp = getServiceResponse(t, srv.URL+"/cas/p3/serviceValidate?"+url.Values{
+		"ticket": {st}, "service": {"https://app.example.com/"}, "format": {"JSON"},
+	}.Encode())
+	if s := resp.ServiceResponse.AuthenticationSuccess; s == nil || s.User != "casuser" || s.Attributes["mobile"] != "12345678910" {
+		t.Errorf("serviceValidate(app) got %+v, want success with all attributes", resp.ServiceResponse)
+	}
+
+	st, _ = TP.GenerateServiceTicket(t.Context(), tgt, "https://app.example.com/", false)
+	resp = getServiceResponse(t, srv.URL+"/cas/p3/serviceValidate?"+url.Values{
+		"ticket": {st}, "service": {"https://app.example.com/"}, "pgtUrl": {"https://unknown.example.com/callback"}, "format": {"JSON"},
+	}.Encode())
+	if f := resp.ServiceResponse.AuthenticationFailure; f == nil || f.Code != "INVALID_PROXY_CALLBACK" {
+		t.Errorf("serviceValidate(unregistered pgtUrl) got %+v, want INVALID_PROXY_CALLBACK", resp.ServiceResponse)
+	}
+}
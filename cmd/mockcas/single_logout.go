@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// LogoutNotice is a single logout request to be sent to logout url of the service which the session belongs to.
+type LogoutNotice struct {
+	LogoutUrl    string
+	Username     string
+	SessionIndex string // the service ticket or proxy ticket issued for the service
+}
+
+// SingleLogoutDispatcher sends back-channel single logout requests from a worker pool, so the user does not wait for services in logout.
+// Failed requests are retried with exponential backoff.
+type SingleLogoutDispatcher struct {
+	client  *http.Client
+	queue   chan queuedLogoutNotice
+	workers int
+	retries int           // max retries after the first attempt
+	backoff time.Duration // delay before the first retry, and doubled for each following retry
+	wg      sync.WaitGroup
+}
+
+type queuedLogoutNotice struct {
+	ctx    context.Context
+	notice LogoutNotice
+}
+
+// SLO is nil if single logout is disabled. In front-channel mode it is still used for logout without browser, e.g. from admin console.
+var SLO *SingleLogoutDispatcher
+
+func setupSingleLogout(ctx context.Context) {
+	switch CFG.SingleLogoutMode {
+	case "back", "front":
+		SLO = NewSingleLogoutDispatcher(CFG.SingleLogoutWorkers, CFG.SingleLogoutRetries, CFG.SingleLogoutBackoff)
+		SLO.Start(ctx)
+	case "none":
+	default:
+		LOG.Fatalf(ctx, "unknown single logout mode: %s", CFG.SingleLogoutMode)
+	}
+}
+
+func NewSingleLogoutDispatcher(workers, retries int, backoff time.Duration) *SingleLogoutDispatcher {
+	workers = max(workers, 1)
+	return &SingleLogoutDispatcher{
+		client:  &http.Client{Timeout: 10 * time.Second},
+		queue:   make(chan queuedLogoutNotice, workers*64),
+		workers: workers,
+		retries: max(retries, 0),
+		backoff: backoff,
+	}
+}
+
+// Start runs workers until ctx is done, notices left in queue are dropped then.
+func (d *SingleLogoutDispatcher) Start(ctx context.Context) {
+	for range d.workers {
+		d.wg.Go(func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case q := <-d.queue:
+					d.deliver(ctx, q)
+				}
+			}
+		})
+	}
+}
+
+// Wait blocks until all workers exit after ctx of Start is done.
+func (d *SingleLogoutDispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// Dispatch queues notice without blocking, and the notice is dropped if queue is full.
+// The ctx is only used for logging, it can be the request context which is canceled soon.
+func (d *SingleLogoutDispatcher) Dispatch(ctx context.Context, notice LogoutNotice) {
+	select {
+	case d.queue <- queuedLogoutNotice{context.WithoutCancel(ctx), notice}:
+	default:
+		LOG.Warnf(ctx, "single logout queue is full, drop request to %s for %s", notice.LogoutUrl, notice.SessionIndex)
+	}
+}
+
+func (d *SingleLogoutDispatcher) deliver(workerCtx context.Context, q queuedLogoutNotice) {
+	delay := d.backoff
+	for attempt := 0; ; attempt++ {
+		err := d.send(q.ctx, q.notice)
+		if err == nil {
+			LOG.Infof(q.ctx, "single logout request to %s for %s succeeded", q.notice.LogoutUrl, q.notice.SessionIndex)
+			return
+		}
+		if attempt >= d.retries {
+			LOG.Warnf(q.ctx, "single logout request to %s for %s failed after %d attempts: %v", q.notice.LogoutUrl, q.notice.SessionIndex, attempt+1, err)
+			return
+		}
+		LOG.Debugf(q.ctx, "single logout request to %s failed, retry in %s: %v", q.notice.LogoutUrl, delay, err)
+		select {
+		case <-workerCtx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func (d *SingleLogoutDispatcher) send(ctx context.Context, notice LogoutNotice) error {
+	logoutReqData, err := encodeSingleLogoutRequest(notice.Username, notice.SessionIndex)
+	if err != nil {
+		return err
+	}
+	body := url.Values{"logoutRequest": {string(logoutReqData)}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, notice.LogoutUrl, bytes.NewBufferString(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	buf := make([]byte, 4096)
+	n, _ := io.ReadFull(resp.Body, buf)
+	return fmt.Errorf("unexpected status %s: %s", resp.Status, buf[:n])
+}
+
+// frontChannelLogoutUrl returns the url for browser to notify service in front-channel single logout.
+// The logout request is deflated and base64 encoded in 'logoutRequest' parameter, like SAML HTTP-Redirect binding.
+func frontChannelLogoutUrl(notice LogoutNotice) (string, error) {
+	logoutReqData, err := encodeSingleLogoutRequest(notice.Username, notice.SessionIndex)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	fw, _ := flate.NewWriter(&buf, flate.BestCompression)
+	fw.Write(logoutReqData)
+	if err = fw.Close(); err != nil {
+		return "", err
+	}
+	return appendQuery(notice.LogoutUrl, url.Values{"logoutRequest": {base64.StdEncoding.EncodeToString(buf.Bytes())}}), nil
+}
+
+// decodeFrontChannelLogoutRequest reverses the encoding of logout request in frontChannelLogoutUrl.
+func decodeFrontChannelLogoutRequest(value string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	result, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(data)), 64*1024))
+	if err != nil {
+		return nil, err
+	} else if len(result) == 0 {
+		return nil, errors.New("empty logout request")
+	}
+	return result, nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"html"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newLogoutReceiver(t *testing.T, failures int32) (*httptest.Server, <-chan LogoutRequest, *atomic.Int32) {
+	t.Helper()
+	received := make(chan LogoutRequest, 16)
+	attempts := new(atomic.Int32)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) <= failures {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		var req LogoutRequest
+		if err := xml.Unmarshal([]byte(r.FormValue("logoutRequest")), &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		received <- req
+	}))
+	t.Cleanup(srv.Close)
+	return srv, received, attempts
+}
+
+func TestSingleLogoutDispatcherRetries(t *testing.T) {
+	setupTestServer(t)
+	receiver, received, attempts := newLogoutReceiver(t, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := NewSingleLogoutDispatcher(2, 3, 10*time.Millisecond)
+	d.Start(ctx)
+	defer func() { cancel(); d.Wait() }()
+
+	d.Dispatch(ctx, LogoutNotice{LogoutUrl: receiver.URL, Username: "casuser", SessionIndex: "ST-1-abc-xyz"})
+	select {
+	case req := <-received:
+		if req.NameID != "casuser" || req.SessionIndex != "ST-1-abc-xyz" {
+			t.Fatalf("received logout request %+v", req)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("logout request is not received")
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Fatalf("got %d attempts, want 3", n)
+	}
+}
+
+func TestSingleLogoutDispatcherGivesUp(t *testing.T) {
+	setupTestServer(t)
+	receiver, _, attempts := newLogoutReceiver(t, 100)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := NewSingleLogoutDispatcher(1, 1, 10*time.Millisecond)
+	d.Start(ctx)
+	d.Dispatch(ctx, LogoutNotice{LogoutUrl: receiver.URL, Username: "casuser", SessionIndex: "ST-1-abc-xyz"})
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+	d.Wait()
+	if n := attempts.Load(); n != 2 {
+		t.Fatalf("got %d attempts, want 2", n)
+	}
+}
+
+func TestFrontChannelLogoutUrl(t *testing.T) {
+	logoutUrl, err := frontChannelLogoutUrl(LogoutNotice{LogoutUrl: "https://app.example.com/logout?a=1", Username: "casuser", SessionIndex: "ST-1-abc-xyz"})
+	if err != nil {
+		t.Fatalf("frontChannelLogoutUrl error: %v", err)
+	}
+	u, err := url.Parse(logoutUrl)
+	if err != nil || u.Query().Get("a") != "1" {
+		t.Fatalf("unexpected front channel logout url %s", logoutUrl)
+	}
+	xmlData, err := decodeFrontChannelLogoutRequest(u.Query().Get("logoutRequest"))
+	if err != nil {
+		t.Fatalf("decodeFrontChannelLogoutRequest error: %v", err)
+	}
+	var req LogoutRequest
+	if err = xml.Unmarshal(xmlData, &req); err != nil || req.NameID != "casuser" || req.SessionIndex != "ST-1-abc-xyz" {
+		t.Fatalf("unexpected logout request %s, error: %v", xmlData, err)
+	}
+}
+
+func TestLogoutNotifiesServices(t *testing.T) {
+	srv := setupTestServer(t)
+	receiverA, receivedA, _ := newLogoutReceiver(t, 0)
+	receiverB, receivedB, _ := newLogoutReceiver(t, 0)
+	var err error
+	SR, err = ParseServiceRegistry([]byte(`
+services:
+  - name: a
+    pattern: https://a\.example\.com/.*
+    logoutUrl: ` + receiverA.URL + `
+  - name: b
+    pattern: https://b\.example\.com/.*
+    logoutUrl: ` + receiverB.URL + `
+`))
+	if err != nil {
+		t.Fatalf("ParseServiceRegistry error: %v", err)
+	}
+	mode := CFG.SingleLogoutMode
+	t.Cleanup(func() { CFG.SingleLogoutMode, SLO = mode, nil })
+
+	login := func(client *http.Client) (ticketA, ticketB string) {
+		location := expectRedirect(t, client, newLoginRequest(t, client, srv.URL+"/cas/login?service="+url.QueryEscape("https://a.example.com/"), "casuser", "Mellon"))
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/cas/login?service="+url.QueryEscape("https://b.example.com/"), nil)
+		return location.Query().Get("ticket"), expectRedirect(t, client, req).Query().Get("ticket")
+	}
+
+	t.Run("back", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		CFG.SingleLogoutMode, SLO = "back", NewSingleLogoutDispatcher(2, 0, 0)
+		SLO.Start(ctx)
+		defer func() { cancel(); SLO.Wait() }()
+
+		client := newTestBrowser()
+		ticketA, ticketB := login(client)
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/cas/logout?service="+url.QueryEscape("https://a.example.com/"), nil)
+		expectRedirect(t, client, req)
+		for _, c := range []struct {
+			received <-chan LogoutRequest
+			ticket   string
+		}{{receivedA, ticketA}, {receivedB, ticketB}} {
+			select {
+			case req := <-c.received:
+				if req.SessionIndex != c.ticket {
+					t.Fatalf("received logout request for %s, want %s", req.SessionIndex, c.ticket)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("logout request for %s is not received", c.ticket)
+			}
+		}
+	})
+
+	t.Run("front", func(t *testing.T) {
+		CFG.SingleLogoutMode, SLO = "front", nil
+		client := newTestBrowser()
+		ticketA, ticketB := login(client)
+		resp, err := client.Get(srv.URL + "/cas/logout?service=" + url.QueryEscape("javascript:alert(document.domain)"))
+		if err != nil {
+			t.Fatalf("logout request error: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if strings.Contains(string(body), "alert") {
+			t.Fatalf("logout page redirects to javascript url:\n%s", body)
+		}
+
+		matches := regexp.MustCompile(`<iframe src="([^"]+)"`).FindAllStringSubmatch(string(body), -1)
+		if len(matches) != 2 {
+			t.Fatalf("got %d iframes in logout page, want 2:\n%s", len(matches), body)
+		}
+		for i, want := range []struct{ prefix, ticket string }{{receiverA.URL, ticketA}, {receiverB.URL, ticketB}} {
+			u, _ := url.Parse(html.UnescapeString(matches[i][1]))
+			xmlData, err := decodeFrontChannelLogoutRequest(u.Query().Get("logoutRequest"))
+			if !strings.HasPrefix(u.String(), want.prefix) || err != nil || !strings.Contains(string(xmlData), want.ticket) {
+				t.Fatalf("iframe %d src %s does not notify %s for %s", i, u, want.prefix, want.ticket)
+			}
+		}
+	})
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileTicketAdapter persists tickets into an append-only log file, so that sessions survive restarts.
+// All tickets are kept in memory, and the log is replayed and compacted with only live records on open.
+// Sweep compacts the log again only after enough dead records are appended, see fileTicketCompactThreshold.
+// Each line is a JSON record:
+//
+//	{"op":"set","key":"TGT-1-XXX-t8k2mz","value":"casuser","exp":1767225600000}
+//	{"op":"del","key":"TGT-1-XXX-t8k2mz"}
+//	{"op":"push","key":"TGT-1-XXX-t8k2mz","value":"ST-2-XXX-t8k2n0","exp":1767225600000}
+//	{"op":"delgroup","key":"TGT-1-XXX-t8k2mz"}
+type FileTicketAdapter struct {
+	mem  *MemTicketAdapter
+	path string
+	file *os.File
+	fMux *sync.Mutex
+
+	records int // number of records in the log file, guarded by fMux
+}
+
+// fileTicketCompactThreshold is the minimum number of dead records in the log file before Sweep compacts it.
+// The log is also not compacted until dead records outnumber live ones, so that the rewrite cost is amortized.
+const fileTicketCompactThreshold = 1024
+
+type fileTicketRecord struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+	Exp   int64  `json:"exp,omitempty"` // unix milliseconds
+}
+
+func NewFileTicketAdapter(path string) (*FileTicketAdapter, error) {
+	ad := &FileTicketAdapter{mem: NewMemTicketAdapter(), path: path, fMux: new(sync.Mutex)}
+	if err := ad.replay(); err != nil {
+		return nil, err
+	}
+	if err := ad.compact(); err != nil {
+		return nil, err
+	}
+	return ad, nil
+}
+
+func (ad *FileTicketAdapter) replay() error {
+	file, err := os.Open(ad.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			return nil // the last incomplete line is dropped, which may be written partially before crash
+		} else if err != nil {
+			return err
+		}
+		var rec fileTicketRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return fmt.Errorf("%s:%d: %w", ad.path, line, err)
+		}
+		switch rec.Op {
+		case "set":
+			ad.mem.setUntil(rec.Key, rec.Value, time.UnixMilli(rec.Exp))
+		case "del":
+			ad.mem.Del(context.Background(), rec.Key)
+		case "push":
+			ad.mem.pushUntil(rec.Key, rec.Value, time.UnixMilli(rec.Exp))
+		case "delgroup":
+			ad.mem.DeleteGroup(context.Background(), rec.Key)
+		default:
+			return fmt.Errorf("%s:%d: unknown op %q", ad.path, line, rec.Op)
+		}
+	}
+}
+
+// compact rewrites the log file with live records only, and keeps it open for appending.
+// It should be called with fMux held, or before the adapter is used.
+func (ad *FileTicketAdapter) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(ad.path), ".mockcas-tickets-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	records := 0
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	ad.liveRecords(func(rec fileTicketRecord) {
+		encoder.Encode(rec)
+		records++
+	})
+	if err = writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), ad.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(ad.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if ad.file != nil {
+		ad.file.Close()
+	}
+	ad.file = file
+	ad.records = records
+	return nil
+}
+
+// liveRecords calls fn with the records of all unexpired tickets and group members in memory.
+func (ad *FileTicketAdapter) liveRecords(fn func(rec fileTicketRecord)) {
+	now := time.Now()
+	ad.mem.tMux.RLock()
+	for ticket, t := range ad.mem.tMap {
+		if now.Before(t.expireAt) {
+			fn(fileTicketRecord{Op: "set", Key: ticket, Value: t.value, Exp: t.expireAt.UnixMilli()})
+		}
+	}
+	ad.mem.tMux.RUnlock()
+	ad.mem.gMux.Lock()
+	for group, g := range ad.mem.gMap {
+		if now.Before(g.expireAt) {
+			for _, ticket := range g.tickets {
+				fn(fileTicketRecord{Op: "push", Key: group, Value: ticket, Exp: g.expireAt.UnixMilli()})
+			}
+		}
+	}
+	ad.mem.gMux.Unlock()
+}
+
+func (ad *FileTicketAdapter) append(rec fileTicketRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	ad.fMux.Lock()
+	defer ad.fMux.Unlock()
+
+	if _, err = ad.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	ad.records++
+	return nil
+}
+
+func (ad *FileTicketAdapter) Set(_ context.Context, ticket string, value string, ttl time.Duration) error {
+	expireAt := time.Now().Add(ttl)
+	ad.mem.setUntil(ticket, value, expireAt)
+	return ad.append(fileTicketRecord{Op: "set", Key: ticket, Value: value, Exp: expireAt.UnixMilli()})
+}
+
+func (ad *FileTicketAdapter) Get(ctx context.Context, ticket string) (value string, err error) {
+	return ad.mem.Get(ctx, ticket)
+}
+
+func (ad *FileTicketAdapter) Del(ctx context.Context, ticket string) (value string, err error) {
+	value, _ = ad.mem.Del(ctx, ticket)
+	return value, ad.append(fileTicketRecord{Op: "del", Key: ticket})
+}
+
+func (ad *FileTicketAdapter) PushToGroup(_ context.Context, groupname, ticket string, ttl time.Duration) error {
+	expireAt := time.Now().Add(ttl)
+	ad.mem.pushUntil(groupname, ticket, expireAt)
+	return ad.append(fileTicketRecord{Op: "push", Key: groupname, Value: ticket, Exp: expireAt.UnixMilli()})
+}
+
+func (ad *FileTicketAdapter) DeleteGroup(ctx context.Context, groupname string) []string {
+	list := ad.mem.DeleteGroup(ctx, groupname)
+	if err := ad.append(fileTicketRecord{Op: "delgroup", Key: groupname}); err != nil {
+		LOG.Warnf(ctx, "append ticket log error: %v", err)
+	}
+	return list
+}
+
+func (ad *FileTicketAdapter) List(ctx context.Context, prefix string) (map[string]string, error) {
+	return ad.mem.List(ctx, prefix)
+}
+
+func (ad *FileTicketAdapter) GetGroup(ctx context.Context, groupname string) ([]string, error) {
+	return ad.mem.GetGroup(ctx, groupname)
+}
+
+// Sweep removes expired tickets from memory, and compacts the log file if it has accumulated enough dead records.
+func (ad *FileTicketAdapter) Sweep(ctx context.Context) (n int, err error) {
+	ad.fMux.Lock()
+	defer ad.fMux.Unlock()
+
+	n, _ = ad.mem.Sweep(ctx)
+	live := 0
+	ad.liveRecords(func(fileTicketRecord) { live++ })
+	if dead := ad.records - live; dead < fileTicketCompactThreshold || dead < live {
+		return n, nil
+	}
+	return n, ad.compact()
+}
+
+func (ad *FileTicketAdapter) Close() error {
+	return ad.file.Close()
+}
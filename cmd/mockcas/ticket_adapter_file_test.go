@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileTicketAdapter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tickets.log")
+	ad, err := NewFileTicketAdapter(path)
+	if err != nil {
+		t.Fatalf("NewFileTicketAdapter() error: %v", err)
+	}
+	testTicketAdapter(t, ad)
+	ad.Close()
+}
+
+func TestFileTicketAdapterReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tickets.log")
+	ad, err := NewFileTicketAdapter(path)
+	if err != nil {
+		t.Fatalf("NewFileTicketAdapter() error: %v", err)
+	}
+	ad.Set(ctx, "TGT-1", "casuser", time.Minute)
+	ad.Set(ctx, "TGT-2", "deleted", time.Minute)
+	ad.Set(ctx, "ST-3", "expired", time.Millisecond)
+	ad.PushToGroup(ctx, "TGT-1", "ST-4", time.Minute)
+	ad.Del(ctx, "TGT-2")
+	ad.Close()
+
+	// simulate a crash in the middle of writing
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	f.WriteString(`{"op":"set","key":"TGT-5"`)
+	f.Close()
+	time.Sleep(10 * time.Millisecond)
+
+	if ad, err = NewFileTicketAdapter(path); err != nil {
+		t.Fatalf("NewFileTicketAdapter() reopen error: %v", err)
+	}
+	defer ad.Close()
+	for ticket, want := range map[string]string{"TGT-1": "casuser", "TGT-2": "", "ST-3": "", "TGT-5": ""} {
+		if got, _ := ad.Get(ctx, ticket); got != want {
+			t.Errorf("Get(%q) = %q, want %q", ticket, got, want)
+		}
+	}
+	if got := ad.DeleteGroup(ctx, "TGT-1"); len(got) != 1 || got[0] != "ST-4" {
+		t.Errorf("DeleteGroup() = %v, want [ST-4]", got)
+	}
+
+	data, _ := os.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != 3 { // set TGT-1, push ST-4, delgroup TGT-1
+		t.Errorf("compacted log has %d lines, want 3:\n%s", lines, data)
+	}
+}
+
+func TestFileTicketAdapterSweep(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tickets.log")
+	ad, err := NewFileTicketAdapter(path)
+	if err != nil {
+		t.Fatalf("NewFileTicketAdapter() error: %v", err)
+	}
+	defer ad.Close()
+	countLines := func() int {
+		data, _ := os.ReadFile(path)
+		return strings.Count(string(data), "\n")
+	}
+
+	ad.Set(ctx, "TGT-1", "casuser", time.Minute)
+	ad.Set(ctx, "ST-2", "expired", time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if n, err := ad.Sweep(ctx); err != nil || n != 1 {
+		t.Fatalf("Sweep() = %d, %v; want 1, nil", n, err)
+	}
+	if lines := countLines(); lines != 2 {
+		t.Errorf("log has %d lines after sweep below threshold, want 2", lines)
+	}
+
+	for i := range fileTicketCompactThreshold {
+		ad.Set(ctx, "ST-"+strconv.Itoa(i+3), "deleted", time.Minute)
+		ad.Del(ctx, "ST-"+strconv.Itoa(i+3))
+	}
+	if _, err := ad.Sweep(ctx); err != nil {
+		t.Fatalf("Sweep() error: %v", err)
+	}
+	if lines := countLines(); lines != 1 {
+		t.Errorf("log has %d lines after sweep above threshold, want 1", lines)
+	}
+}
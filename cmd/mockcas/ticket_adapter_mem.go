@@ -2,67 +2,148 @@ package main
 
 import (
 	"context"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 )
 
+type memTicket struct {
+	value    string
+	expireAt time.Time
+}
+
+type memGroup struct {
+	tickets  []string
+	expireAt time.Time
+}
+
 type MemTicketAdapter struct {
-	tMap map[string]string
+	tMap map[string]memTicket
 	tMux *sync.RWMutex
 
-	gMap map[string][]string
+	gMap map[string]memGroup
 	gMux *sync.Mutex
 }
 
 func NewMemTicketAdapter() *MemTicketAdapter {
 	return &MemTicketAdapter{
-		tMap: make(map[string]string),
+		tMap: make(map[string]memTicket),
 		tMux: new(sync.RWMutex),
-		gMap: make(map[string][]string),
+		gMap: make(map[string]memGroup),
 		gMux: new(sync.Mutex),
 	}
 }
 
-func (ad *MemTicketAdapter) Set(_ context.Context, ticket string, username string) error {
+func (ad *MemTicketAdapter) Set(_ context.Context, ticket string, value string, ttl time.Duration) error {
+	ad.setUntil(ticket, value, time.Now().Add(ttl))
+	return nil
+}
+
+func (ad *MemTicketAdapter) setUntil(ticket string, value string, expireAt time.Time) {
 	ad.tMux.Lock()
 	defer ad.tMux.Unlock()
 
-	ad.tMap[ticket] = username
-	return nil
+	ad.tMap[ticket] = memTicket{value: value, expireAt: expireAt}
 }
 
-func (ad *MemTicketAdapter) Get(_ context.Context, ticket string) (username string, err error) {
+func (ad *MemTicketAdapter) Get(_ context.Context, ticket string) (value string, err error) {
 	ad.tMux.RLock()
 	defer ad.tMux.RUnlock()
 
-	return ad.tMap[ticket], nil
+	if t, ok := ad.tMap[ticket]; ok && time.Now().Before(t.expireAt) {
+		return t.value, nil
+	}
+	return "", nil
 }
 
-func (ad *MemTicketAdapter) Del(_ context.Context, ticket string) (username string, err error) {
+func (ad *MemTicketAdapter) Del(_ context.Context, ticket string) (value string, err error) {
 	ad.tMux.Lock()
 	defer ad.tMux.Unlock()
 
-	username, ok := ad.tMap[ticket]
+	t, ok := ad.tMap[ticket]
 	if ok {
 		delete(ad.tMap, ticket)
 	}
-	return username, nil
+	if ok && time.Now().Before(t.expireAt) {
+		return t.value, nil
+	}
+	return "", nil
 }
 
-func (ad *MemTicketAdapter) PushToGroup(_ context.Context, groupname, ticket string) error {
+// PushToGroup appends the ticket to group, and extends the group expiration to ttl later.
+func (ad *MemTicketAdapter) PushToGroup(_ context.Context, groupname, ticket string, ttl time.Duration) error {
+	ad.pushUntil(groupname, ticket, time.Now().Add(ttl))
+	return nil
+}
+
+func (ad *MemTicketAdapter) pushUntil(groupname, ticket string, expireAt time.Time) {
 	ad.gMux.Lock()
 	defer ad.gMux.Unlock()
 
-	ad.gMap[groupname] = append(ad.gMap[groupname], ticket)
-	return nil
+	g := ad.gMap[groupname]
+	if time.Now().After(g.expireAt) {
+		g.tickets = nil
+	}
+	ad.gMap[groupname] = memGroup{tickets: append(g.tickets, ticket), expireAt: expireAt}
 }
 
 func (ad *MemTicketAdapter) DeleteGroup(_ context.Context, groupname string) []string {
 	ad.gMux.Lock()
 	defer ad.gMux.Unlock()
 
-	list, ok := ad.gMap[groupname]
+	g, ok := ad.gMap[groupname]
 	if ok {
 		delete(ad.gMap, groupname)
 	}
-	return list
+	if ok && time.Now().Before(g.expireAt) {
+		return g.tickets
+	}
+	return nil
+}
+
+func (ad *MemTicketAdapter) List(_ context.Context, prefix string) (map[string]string, error) {
+	ad.tMux.RLock()
+	defer ad.tMux.RUnlock()
+
+	now := time.Now()
+	result := make(map[string]string)
+	for ticket, t := range ad.tMap {
+		if strings.HasPrefix(ticket, prefix) && now.Before(t.expireAt) {
+			result[ticket] = t.value
+		}
+	}
+	return result, nil
+}
+
+func (ad *MemTicketAdapter) GetGroup(_ context.Context, groupname string) ([]string, error) {
+	ad.gMux.Lock()
+	defer ad.gMux.Unlock()
+
+	if g, ok := ad.gMap[groupname]; ok && time.Now().Before(g.expireAt) {
+		return slices.Clone(g.tickets), nil
+	}
+	return nil, nil
+}
+
+func (ad *MemTicketAdapter) Sweep(_ context.Context) (n int, err error) {
+	now := time.Now()
+	ad.tMux.Lock()
+	for ticket, t := range ad.tMap {
+		if !now.Before(t.expireAt) {
+			delete(ad.tMap, ticket)
+			n++
+		}
+	}
+	ad.tMux.Unlock()
+
+	ad.gMux.Lock()
+	for group, g := range ad.gMap {
+		if !now.Before(g.expireAt) {
+			delete(ad.gMap, group)
+			n++
+		}
+	}
+	ad.gMux.Unlock()
+	return n, nil
 }
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	redisTicketKeyPrefix = "mockcas:ticket:"
+	redisGroupKeyPrefix  = "mockcas:group:"
+	redisDialTimeout     = 5 * time.Second
+)
+
+// RedisTicketAdapter stores tickets in redis server with a minimal RESP client, so that multiple
+// mockcas replicas can share tickets. Ticket is saved as string, and group is saved as list.
+type RedisTicketAdapter struct {
+	addr     string
+	password string
+	db       int
+
+	conn *bufio.ReadWriter
+	raw  net.Conn
+	cMux *sync.Mutex
+}
+
+// NewRedisTicketAdapter parses url like 'redis://:password@127.0.0.1:6379/0', and connects lazily on first command.
+func NewRedisTicketAdapter(rawUrl string) (*RedisTicketAdapter, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" {
+		return nil, fmt.Errorf("unsupported redis url scheme: %s", u.Scheme)
+	}
+	ad := &RedisTicketAdapter{addr: u.Host, cMux: new(sync.Mutex)}
+	if _, port, _ := net.SplitHostPort(u.Host); port == "" {
+		ad.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		ad.password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if ad.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid redis db: %s", db)
+		}
+	}
+	return ad, nil
+}
+
+func (ad *RedisTicketAdapter) connect(ctx context.Context) error {
+	dialer := net.Dialer{Timeout: redisDialTimeout}
+	raw, err := dialer.DialContext(ctx, "tcp", ad.addr)
+	if err != nil {
+		return err
+	}
+	ad.raw = raw
+	ad.conn = bufio.NewReadWriter(bufio.NewReader(raw), bufio.NewWriter(raw))
+	if ad.password != "" {
+		if _, err = ad.roundTrip("AUTH", ad.password); err != nil {
+			ad.reset()
+			return err
+		}
+	}
+	if ad.db != 0 {
+		if _, err = ad.roundTrip("SELECT", strconv.Itoa(ad.db)); err != nil {
+			ad.reset()
+			return err
+		}
+	}
+	return nil
+}
+
+func (ad *RedisTicketAdapter) reset() {
+	ad.raw.Close()
+	ad.raw, ad.conn = nil, nil
+}
+
+// do sends commands in a pipeline and returns their replies in order.
+// The connection is dropped on any network or protocol error, and will be reconnected on next call.
+func (ad *RedisTicketAdapter) do(ctx context.Context, cmds ...[]string) (replies []any, err error) {
+	ad.cMux.Lock()
+	defer ad.cMux.Unlock()
+
+	if ad.conn == nil {
+		if err = ad.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		ad.raw.SetDeadline(deadline)
+	} else {
+		ad.raw.SetDeadline(time.Time{})
+	}
+
+	for _, cmd := range cmds {
+		writeRESPCommand(ad.conn.Writer, cmd)
+	}
+	if err = ad.conn.Flush(); err != nil {
+		ad.reset()
+		return nil, err
+	}
+	replies = make([]any, len(cmds))
+	var errReply error
+	for i := range cmds {
+		if replies[i], err = readRESPReply(ad.conn.Reader); err != nil {
+			if !errors.As(err, new(redisError)) {
+				ad.reset()
+				return nil, err
+			}
+			errReply = cmp.Or(errReply, err) // read remaining replies to keep the stream in sync
+		}
+	}
+	return replies, errReply
+}
+
+func (ad *RedisTicketAdapter) roundTrip(cmd ...string) (any, error) {
+	writeRESPCommand(ad.conn.Writer, cmd)
+	if err := ad.conn.Flush(); err != nil {
+		return nil, err
+	}
+	return readRESPReply(ad.conn.Reader)
+}
+
+// redisTTL formats ttl in milliseconds for PX and PEXPIRE. It is at least 1ms, because redis rejects
+// SET with PX 0 and deletes the key immediately with PEXPIRE 0.
+func redisTTL(ttl time.Duration) string {
+	return strconv.FormatInt(max(ttl.Milliseconds(), 1), 10)
+}
+
+func (ad *RedisTicketAdapter) Set(ctx context.Context, ticket string, value string, ttl time.Duration) error {
+	_, err := ad.do(ctx, []string{"SET", redisTicketKeyPrefix + ticket, value, "PX", redisTTL(ttl)})
+	return err
+}
+
+func (ad *RedisTicketAdapter) Get(ctx context.Context, ticket string) (value string, err error) {
+	replies, err := ad.do(ctx, []string{"GET", redisTicketKeyPrefix + ticket})
+	if err != nil {
+		return "", err
+	}
+	value, _ = replies[0].(string)
+	return value, nil
+}
+
+// Del uses GETDEL which requires redis server 6.2 or later.
+func (ad *RedisTicketAdapter) Del(ctx context.Context, ticket string) (value string, err error) {
+	replies, err := ad.do(ctx, []string{"GETDEL", redisTicketKeyPrefix + ticket})
+	if err != nil {
+		return "", err
+	}
+	value, _ = replies[0].(string)
+	return value, nil
+}
+
+func (ad *RedisTicketAdapter) PushToGroup(ctx context.Context, groupname, ticket string, ttl time.Duration) error {
+	key := redisGroupKeyPrefix + groupname
+	_, err := ad.do(ctx,
+		[]string{"MULTI"},
+		[]string{"RPUSH", key, ticket},
+		[]string{"PEXPIRE", key, redisTTL(ttl)},
+		[]string{"EXEC"},
+	)
+	return err
+}
+
+func (ad *RedisTicketAdapter) DeleteGroup(ctx context.Context, groupname string) []string {
+	key := redisGroupKeyPrefix + groupname
+	replies, err := ad.do(ctx,
+		[]string{"MULTI"},
+		[]string{"LRANGE", key, "0", "-1"},
+		[]string{"DEL", key},
+		[]string{"EXEC"},
+	)
+	if err != nil {
+		LOG.Warnf(ctx, "redis delete group error: %v", err)
+		return nil
+	}
+	results, _ := replies[3].([]any)
+	if len(results) == 0 {
+		return nil
+	}
+	items, _ := results[0].([]any)
+	if len(items) == 0 {
+		return nil
+	}
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// redisGlobEscaper escapes special characters of glob-style pattern in SCAN MATCH.
+var redisGlobEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// List iterates keys with SCAN and reads their values with MGET, which may be slow for a large database.
+func (ad *RedisTicketAdapter) List(ctx context.Context, prefix string) (map[string]string, error) {
+	result := make(map[string]string)
+	pattern := redisTicketKeyPrefix + redisGlobEscaper.Replace(prefix) + "*"
+	for cursor := "0"; ; {
+		replies, err := ad.do(ctx, []string{"SCAN", cursor, "MATCH", pattern, "COUNT", "1000"})
+		if err != nil {
+			return nil, err
+		}
+		reply, _ := replies[0].([]any)
+		if len(reply) != 2 {
+			return nil, errors.New("redis: unexpected SCAN reply")
+		}
+		cursor, _ = reply[0].(string)
+		keys, _ := reply[1].([]any)
+		if len(keys) > 0 {
+			cmd := []string{"MGET"}
+			for _, key := range keys {
+				s, _ := key.(string)
+				cmd = append(cmd, s)
+			}
+			if replies, err = ad.do(ctx, cmd); err != nil {
+				return nil, err
+			}
+			values, _ := replies[0].([]any)
+			for i, value := range values {
+				if s, ok := value.(string); ok && i+1 < len(cmd) {
+					result[strings.TrimPrefix(cmd[i+1], redisTicketKeyPrefix)] = s
+				}
+			}
+		}
+		if cursor == "0" || cursor == "" {
+			return result, nil
+		}
+	}
+}
+
+func (ad *RedisTicketAdapter) GetGroup(ctx context.Context, groupname string) ([]string, error) {
+	replies, err := ad.do(ctx, []string{"LRANGE", redisGroupKeyPrefix + groupname, "0", "-1"})
+	if err != nil {
+		return nil, err
+	}
+	items, _ := replies[0].([]any)
+	if len(items) == 0 {
+		return nil, nil
+	}
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			list = append(list, s)
+		}
+	}
+	return list, nil
+}
+
+// Sweep does nothing, because redis server removes expired keys itself.
+func (ad *RedisTicketAdapter) Sweep(_ context.Context) (n int, err error) {
+	return 0, nil
+}
+
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func writeRESPCommand(w *bufio.Writer, cmd []string) {
+	fmt.Fprintf(w, "*%d\r\n", len(cmd))
+	for _, arg := range cmd {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+}
+
+// readRESPReply reads one RESP2 reply. Simple and bulk strings are returned as string, integers as int64,
+// arrays as []any, and null bulk string or null array as nil. Error reply is returned as redisError.
+func readRESPReply(r *bufio.Reader) (any, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply line")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err = io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		arr := make([]any, n)
+		var errReply error
+		for i := range arr {
+			if arr[i], err = readRESPReply(r); err != nil {
+				if !errors.As(err, new(redisError)) {
+					return nil, err
+				}
+				errReply = cmp.Or(errReply, err)
+			}
+		}
+		return arr, errReply
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", line[0])
+	}
+}
+
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", errors.New("redis: invalid line terminator")
+	}
+	return line[:len(line)-2], nil
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedisServer is a tiny in-process RESP server, which only supports the commands used by RedisTicketAdapter.
+type fakeRedisServer struct {
+	ln       net.Listener
+	password string
+
+	mu      sync.Mutex
+	strings map[string]string
+	lists   map[string][]string
+	expire  map[string]time.Time
+}
+
+func newFakeRedisServer(t *testing.T, password string) *fakeRedisServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &fakeRedisServer{
+		ln:       ln,
+		password: password,
+		strings:  make(map[string]string),
+		lists:    make(map[string][]string),
+		expire:   make(map[string]time.Time),
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+	return srv
+}
+
+func (srv *fakeRedisServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r, w := bufio.NewReader(conn), bufio.NewWriter(conn)
+	authed := srv.password == ""
+	var queue [][]string
+	inMulti := false
+	for {
+		reply, err := readRESPReply(r)
+		if err != nil {
+			return
+		}
+		args, _ := reply.([]any)
+		cmd := make([]string, len(args))
+		for i, arg := range args {
+			cmd[i], _ = arg.(string)
+		}
+		name := strings.ToUpper(cmd[0])
+		switch {
+		case name == "AUTH":
+			if cmd[1] != srv.password {
+				w.WriteString("-WRONGPASS invalid password\r\n")
+			} else {
+				authed = true
+				w.WriteString("+OK\r\n")
+			}
+		case !authed:
+			w.WriteString("-NOAUTH Authentication required.\r\n")
+		case name == "MULTI":
+			inMulti = true
+			w.WriteString("+OK\r\n")
+		case name == "EXEC":
+			w.WriteString("*" + strconv.Itoa(len(queue)) + "\r\n")
+			for _, c := range queue {
+				srv.exec(w, c)
+			}
+			queue, inMulti = nil, false
+		case inMulti:
+			queue = append(queue, cmd)
+			w.WriteString("+QUEUED\r\n")
+		default:
+			srv.exec(w, cmd)
+		}
+		if err = w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (srv *fakeRedisServer) expired(key string) bool {
+	if exp, ok := srv.expire[key]; ok && !time.Now().Before(exp) {
+		delete(srv.strings, key)
+		delete(srv.lists, key)
+		delete(srv.expire, key)
+		return true
+	}
+	return false
+}
+
+func writeBulk(w *bufio.Writer, s string, ok bool) {
+	if !ok {
+		w.WriteString("$-1\r\n")
+		return
+	}
+	w.WriteString("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func (srv *fakeRedisServer) exec(w *bufio.Writer, cmd []string) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	name := strings.ToUpper(cmd[0])
+	if len(cmd) > 1 {
+		srv.expired(cmd[1])
+	}
+	switch name {
+	case "PING":
+		w.WriteString("+PONG\r\n")
+	case "SELECT":
+		w.WriteString("+OK\r\n")
+	case "SET":
+		var ms int64
+		if len(cmd) == 5 && strings.ToUpper(cmd[3]) == "PX" {
+			if ms, _ = strconv.ParseInt(cmd[4], 10, 64); ms <= 0 {
+				w.WriteString("-ERR invalid expire time in 'set' command\r\n")
+				return
+			}
+		}
+		srv.strings[cmd[1]] = cmd[2]
+		delete(srv.expire, cmd[1])
+		if ms > 0 {
+			srv.expire[cmd[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		}
+		w.WriteString("+OK\r\n")
+	case "GET":
+		s, ok := srv.strings[cmd[1]]
+		writeBulk(w, s, ok)
+	case "GETDEL":
+		s, ok := srv.strings[cmd[1]]
+		delete(srv.strings, cmd[1])
+		delete(srv.expire, cmd[1])
+		writeBulk(w, s, ok)
+	case "RPUSH":
+		srv.lists[cmd[1]] = append(srv.lists[cmd[1]], cmd[2:]...)
+		w.WriteString(":" + strconv.Itoa(len(srv.lists[cmd[1]])) + "\r\n")
+	case "PEXPIRE":
+		ms, _ := strconv.ParseInt(cmd[2], 10, 64)
+		srv.expire[cmd[1]] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+		w.WriteString(":1\r\n")
+	case "LRANGE":
+		list := srv.lists[cmd[1]]
+		w.WriteString("*" + strconv.Itoa(len(list)) + "\r\n")
+		for _, s := range list {
+			writeBulk(w, s, true)
+		}
+	case "SCAN": // SCAN 0 MATCH pattern COUNT n, all keys are returned in one iteration
+		var keys []string
+		for key := range srv.strings {
+			if ok, _ := path.Match(cmd[3], key); ok && !srv.expired(key) {
+				keys = append(keys, key)
+			}
+		}
+		w.WriteString("*2\r\n")
+		writeBulk(w, "0", true)
+		w.WriteString("*" + strconv.Itoa(len(keys)) + "\r\n")
+		for _, key := range keys {
+			writeBulk(w, key, true)
+		}
+	case "MGET":
+		w.WriteString("*" + strconv.Itoa(len(cmd)-1) + "\r\n")
+		for _, key := range cmd[1:] {
+			srv.expired(key)
+			s, ok := srv.strings[key]
+			writeBulk(w, s, ok)
+		}
+	case "DEL":
+		_, ok1 := srv.strings[cmd[1]]
+		_, ok2 := srv.lists[cmd[1]]
+		delete(srv.strings, cmd[1])
+		delete(srv.lists, cmd[1])
+		delete(srv.expire, cmd[1])
+		if ok1 || ok2 {
+			w.WriteString(":1\r\n")
+		} else {
+			w.WriteString(":0\r\n")
+		}
+	default:
+		w.WriteString("-ERR unknown command '" + cmd[0] + "'\r\n")
+	}
+}
+
+func TestRedisTicketAdapter(t *testing.T) {
+	srv := newFakeRedisServer(t, "secret")
+	ad, err := NewRedisTicketAdapter("redis://:secret@" + srv.ln.Addr().String() + "/1")
+	if err != nil {
+		t.Fatalf("NewRedisTicketAdapter() error: %v", err)
+	}
+	testTicketAdapter(t, ad)
+}
+
+func TestRedisTicketAdapterAuthError(t *testing.T) {
+	srv := newFakeRedisServer(t, "secret")
+	ad, err := NewRedisTicketAdapter("redis://:wrong@" + srv.ln.Addr().String())
+	if err != nil {
+		t.Fatalf("NewRedisTicketAdapter() error: %v", err)
+	}
+	if _, err = ad.Get(context.Background(), "TGT-1"); !errors.As(err, new(redisError)) {
+		t.Errorf("Get() error = %v; want redis error", err)
+	}
+}
+
+func testTicketAdapter(t *testing.T, ad TicketAdapter) {
+	t.Helper()
+	ctx := context.Background()
+	steps := []struct {
+		name string
+		run  func() (any, error)
+		want any
+	}{
+		{"Get missing", func() (any, error) { return ad.Get(ctx, "TGT-1") }, ""},
+		{"Set", func() (any, error) { return nil, ad.Set(ctx, "TGT-1", "casuser", time.Minute) }, nil},
+		{"Get", func() (any, error) { return ad.Get(ctx, "TGT-1") }, "casuser"},
+		{"List", func() (any, error) { return ad.List(ctx, "TGT-") }, map[string]string{"TGT-1": "casuser"}},
+		{"List other", func() (any, error) { return ad.List(ctx, "PGT-") }, map[string]string{}},
+		{"Set short", func() (any, error) { return nil, ad.Set(ctx, "ST-2", "casuser", 50*time.Millisecond) }, nil},
+		{"Get short", func() (any, error) { return ad.Get(ctx, "ST-2") }, "casuser"},
+		{"Set sub-millisecond", func() (any, error) { return nil, ad.Set(ctx, "ST-5", "casuser", time.Microsecond) }, nil},
+		{"Sleep", func() (any, error) { time.Sleep(100 * time.Millisecond); return nil, nil }, nil},
+		{"Get expired", func() (any, error) { return ad.Get(ctx, "ST-2") }, ""},
+		{"Del expired", func() (any, error) { return ad.Del(ctx, "ST-2") }, ""},
+		{"PushToGroup 1", func() (any, error) { return nil, ad.PushToGroup(ctx, "TGT-1", "ST-3", time.Minute) }, nil},
+		{"PushToGroup 2", func() (any, error) { return nil, ad.PushToGroup(ctx, "TGT-1", "ST-4", time.Minute) }, nil},
+		{"GetGroup", func() (any, error) { return ad.GetGroup(ctx, "TGT-1") }, []string{"ST-3", "ST-4"}},
+		{"DeleteGroup", func() (any, error) { return ad.DeleteGroup(ctx, "TGT-1"), nil }, []string{"ST-3", "ST-4"}},
+		{"GetGroup deleted", func() (any, error) { return ad.GetGroup(ctx, "TGT-1") }, []string(nil)},
+		{"DeleteGroup again", func() (any, error) { return ad.DeleteGroup(ctx, "TGT-1"), nil }, []string(nil)},
+		{"PushToGroup short", func() (any, error) { return nil, ad.PushToGroup(ctx, "TGT-5", "ST-6", 50*time.Millisecond) }, nil},
+		{"Sleep", func() (any, error) { time.Sleep(100 * time.Millisecond); return nil, nil }, nil},
+		{"DeleteGroup expired", func() (any, error) { return ad.DeleteGroup(ctx, "TGT-5"), nil }, []string(nil)},
+		{"Del", func() (any, error) { return ad.Del(ctx, "TGT-1") }, "casuser"},
+		{"Get deleted", func() (any, error) { return ad.Get(ctx, "TGT-1") }, ""},
+	}
+	for _, step := range steps {
+		got, err := step.run()
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", step.name, err)
+		}
+		if !reflect.DeepEqual(got, step.want) {
+			t.Errorf("%s: got %#v, want %#v", step.name, got, step.want)
+		}
+	}
+}
@@ -2,103 +2,527 @@ package main
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"slices"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 )
 
 const (
-	ServiceTicketTimeToLive      = 10 // 10 seconds
 	ServiceTicketRandSize        = 20
 	TicketGrantingTicketRandSize = 40
 	TicketGrantingCookieName     = "TGC-session"
+
+	TicketGrantingTicketPrefix   = "TGT"
+	ServiceTicketPrefix          = "ST"
+	ProxyGrantingTicketPrefix    = "PGT"
+	ProxyTicketPrefix            = "PT"
+	ProxyGrantingTicketIouPrefix = "PGTIOU"
+
+	OidcRequestPrefix     = "OAR" // pending oidc authorization request, before the user logs in
+	OidcCodePrefix        = "OC"  // oidc authorization code
+	OidcAccessTokenPrefix = "AT"  // oidc access token
+
+	LoginTicketPrefix     = "LT" // login ticket in 'execution' field of login form against csrf
+	LoginTicketCookieName = "LTC-session"
+	LoginTicketLifetime   = 30 * time.Minute
 )
 
-var InvalidTicket = errors.New("invalid ticket")
+var (
+	InvalidTicket     = errors.New("invalid ticket")
+	InvalidTicketSpec = errors.New("invalid ticket spec")
+)
+
+// TicketData is saved as json value of ticket in adapter.
+type TicketData struct {
+	Username string   `json:"username"`
+	Service  string   `json:"service,omitempty"`  // service of ST or PT, or callback url of PGT
+	Proxies  []string `json:"proxies,omitempty"`  // proxy callback urls of PT or PGT, the most recent first
+	Granting string   `json:"granting,omitempty"` // the original TGT of ST, PT and PGT
+
+	AuthTime time.Time `json:"authTime"`           // when the user provided credentials
+	NewLogin bool      `json:"newLogin,omitempty"` // whether ST is issued right after the user provided credentials
 
+	OIDC *OidcRequest `json:"oidc,omitempty"` // the authorization request of oidc tickets
+}
+
+// TicketAdapter stores tickets and ticket groups, entries should be invisible after their ttl expires.
 type TicketAdapter interface {
-	Set(ctx context.Context, ticket string, username string) error
-	Get(ctx context.Context, ticket string) (username string, err error)
-	Del(ctx context.Context, ticket string) (username string, err error)
+	Set(ctx context.Context, ticket string, value string, ttl time.Duration) error
+	Get(ctx context.Context, ticket string) (value string, err error)
+	Del(ctx context.Context, ticket string) (value string, err error)
 
-	PushToGroup(ctx context.Context, groupname, ticket string) error
+	PushToGroup(ctx context.Context, groupname, ticket string, ttl time.Duration) error
 	DeleteGroup(ctx context.Context, groupname string) []string
+
+	// List returns unexpired tickets whose name starts with prefix and their values, it is intended for admin console only.
+	List(ctx context.Context, prefix string) (map[string]string, error)
+	// GetGroup returns tickets in group without deleting it.
+	GetGroup(ctx context.Context, groupname string) ([]string, error)
+
+	// Sweep removes expired entries and returns the number of removed ones.
+	Sweep(ctx context.Context) (n int, err error)
 }
 
 type TicketProvider struct {
 	adapter  TicketAdapter
 	sequence *atomic.Uint64
+	loginKey []byte // signs login tickets, which are kept in adapter only after being used
+
+	stLifetime     time.Duration // max lifetime of service ticket
+	tgtMaxLifetime time.Duration // max lifetime of ticket granting ticket since creation
+	tgtIdleTimeout time.Duration // ticket granting ticket expires if not used within this duration
 }
 
 var TP *TicketProvider
 
-func setupTicketProvider(_ context.Context) {
-	TP = &TicketProvider{
-		adapter:  NewMemTicketAdapter(),
-		sequence: new(atomic.Uint64),
+func setupTicketProvider(ctx context.Context) {
+	var adapter TicketAdapter
+	switch CFG.TicketStore {
+	case "mem":
+		adapter = NewMemTicketAdapter()
+	case "file":
+		ad, err := NewFileTicketAdapter(CFG.TicketFile)
+		if err != nil {
+			LOG.Fatalf(ctx, "open ticket file error: %v", err)
+		}
+		adapter = ad
+	case "redis":
+		ad, err := NewRedisTicketAdapter(CFG.TicketRedisUrl)
+		if err != nil {
+			LOG.Fatalf(ctx, "parse redis url error: %v", err)
+		}
+		adapter = ad
+	default:
+		LOG.Fatalf(ctx, "unknown ticket store: %s", CFG.TicketStore)
+	}
+	TP = NewTicketProvider(adapter, CFG.STLifetime, CFG.TGTMaxLifetime, CFG.TGTIdleTimeout)
+	if CFG.LoginTicketKey != "" {
+		TP.loginKey = []byte(CFG.LoginTicketKey)
+	} else if CFG.TicketStore != "mem" {
+		LOG.Warnf(ctx, "login-ticket-key is empty, login forms are invalidated on restart and not accepted by other replicas")
+	}
+}
+
+func NewTicketProvider(adapter TicketAdapter, stLifetime, tgtMaxLifetime, tgtIdleTimeout time.Duration) *TicketProvider {
+	if tgtIdleTimeout <= 0 || tgtIdleTimeout > tgtMaxLifetime {
+		tgtIdleTimeout = tgtMaxLifetime
+	}
+	return &TicketProvider{
+		adapter:        adapter,
+		sequence:       new(atomic.Uint64),
+		loginKey:       []byte(rand.Text()),
+		stLifetime:     stLifetime,
+		tgtMaxLifetime: tgtMaxLifetime,
+		tgtIdleTimeout: tgtIdleTimeout,
+	}
+}
+
+// RunSweeper removes expired tickets from adapter periodically until ctx is done.
+func (p *TicketProvider) RunSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := p.adapter.Sweep(ctx); err != nil {
+				LOG.Warnf(ctx, "sweep expired tickets error: %v", err)
+			} else if n > 0 {
+				LOG.Debugf(ctx, "swept %d expired tickets", n)
+			}
+		}
 	}
 }
 
+// tgtRemaining returns the remaining lifetime of ticket granting ticket, which is limited by both max lifetime and idle timeout.
+func (p *TicketProvider) tgtRemaining(tkt *Ticket) time.Duration {
+	return min(p.tgtIdleTimeout, p.tgtMaxLifetime-time.Since(time.Unix(tkt.ctime, 0)))
+}
+
+// tgtMaxRemaining returns the remaining max lifetime of ticket granting ticket, tickets derived from it should not live longer.
+// InvalidTicket is returned if nothing is left, so that adapters never receive a non-positive ttl.
+func (p *TicketProvider) tgtMaxRemaining(tgt string) (time.Duration, error) {
+	tkt, err := ParseTicket(tgt)
+	if err != nil {
+		return 0, err
+	}
+	if remaining := p.tgtMaxLifetime - time.Since(time.Unix(tkt.ctime, 0)); remaining > 0 {
+		return remaining, nil
+	}
+	return 0, InvalidTicket
+}
+
+func (p *TicketProvider) setData(ctx context.Context, ticket string, data *TicketData, ttl time.Duration) error {
+	value, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return p.adapter.Set(ctx, ticket, string(value), ttl)
+}
+
+func decodeTicketData(value string) (*TicketData, error) {
+	if value == "" {
+		return nil, InvalidTicket
+	}
+	var data TicketData
+	if err := json.Unmarshal([]byte(value), &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
 func (p *TicketProvider) GenerateTicketGrantingTicket(ctx context.Context, username string) (ticket string, err error) {
-	tkt := NewTicket("TGT", p.sequence.Add(1), TicketGrantingTicketRandSize)
+	tkt := NewTicket(TicketGrantingTicketPrefix, p.sequence.Add(1), TicketGrantingTicketRandSize)
 	ticket = tkt.String()
-	if err = p.adapter.Set(ctx, ticket, username); err != nil {
+	if err = p.setData(ctx, ticket, &TicketData{Username: username, AuthTime: time.Now()}, p.tgtIdleTimeout); err != nil {
 		return "", err
 	}
 	return ticket, nil
 }
 
-func (p *TicketProvider) GenerateServiceTicket(ctx context.Context, username string) (ticket string, err error) {
-	tkt := NewTicket("ST", p.sequence.Add(1), ServiceTicketRandSize)
+// GenerateServiceTicket issues a service ticket for service, which is granted by ticket granting ticket tgt.
+// The newLogin should be true only if the user just provided credentials, instead of single sign-on with tgt.
+func (p *TicketProvider) GenerateServiceTicket(ctx context.Context, tgt, service string, newLogin bool) (ticket string, err error) {
+	value, err := p.adapter.Get(ctx, tgt)
+	if err != nil {
+		return "", err
+	}
+	tgtData, err := decodeTicketData(value)
+	if err != nil {
+		return "", err
+	}
+
+	tkt := NewTicket(ServiceTicketPrefix, p.sequence.Add(1), ServiceTicketRandSize)
 	ticket = tkt.String()
-	if err = p.adapter.Set(ctx, ticket, username); err != nil {
+	data := &TicketData{
+		Username: tgtData.Username,
+		Service:  service,
+		Granting: tgt,
+		AuthTime: tgtData.AuthTime,
+		NewLogin: newLogin,
+	}
+	if err = p.setData(ctx, ticket, data, p.stLifetime); err != nil {
 		return "", err
 	}
 	return ticket, nil
 }
 
-func (p *TicketProvider) ValidateTicket(ctx context.Context, ticket string, isST bool) (*User, error) {
+// GenerateProxyGrantingTicket issues a proxy granting ticket for pgtUrl after data of ST or PT is validated.
+// The pgtUrl is prepended to the proxy chain, and the PGT is bound to the original ticket granting ticket.
+func (p *TicketProvider) GenerateProxyGrantingTicket(ctx context.Context, data *TicketData, pgtUrl string) (ticket string, err error) {
+	ttl, err := p.tgtMaxRemaining(data.Granting)
+	if err != nil {
+		return "", err
+	}
+	tkt := NewTicket(ProxyGrantingTicketPrefix, p.sequence.Add(1), TicketGrantingTicketRandSize)
+	ticket = tkt.String()
+	pgtData := &TicketData{
+		Username: data.Username,
+		Service:  pgtUrl,
+		Proxies:  append([]string{pgtUrl}, data.Proxies...),
+		Granting: data.Granting,
+		AuthTime: data.AuthTime,
+	}
+	if err = p.setData(ctx, ticket, pgtData, ttl); err != nil {
+		return "", err
+	}
+	if err = p.BindTicketToGroup(ctx, data.Granting, ticket, pgtUrl); err != nil {
+		return "", err
+	}
+	return ticket, nil
+}
+
+// GenerateProxyTicket issues a proxy ticket for targetService with proxy granting ticket pgt.
+func (p *TicketProvider) GenerateProxyTicket(ctx context.Context, pgt, targetService string) (ticket string, err error) {
+	if tkt, err := ParseTicket(pgt); err != nil {
+		return "", err
+	} else if tkt.prefix != ProxyGrantingTicketPrefix {
+		return "", InvalidTicket
+	}
+	value, err := p.adapter.Get(ctx, pgt)
+	if err != nil {
+		return "", err
+	}
+	pgtData, err := decodeTicketData(value)
+	if err != nil {
+		return "", err
+	}
+
+	tkt := NewTicket(ProxyTicketPrefix, p.sequence.Add(1), ServiceTicketRandSize)
+	ticket = tkt.String()
+	data := &TicketData{
+		Username: pgtData.Username,
+		Service:  targetService,
+		Proxies:  pgtData.Proxies,
+		Granting: pgtData.Granting,
+		AuthTime: pgtData.AuthTime,
+	}
+	if err = p.setData(ctx, ticket, data, p.stLifetime); err != nil {
+		return "", err
+	}
+	if err = p.BindTicketToGroup(ctx, pgtData.Granting, ticket, targetService); err != nil {
+		LOG.Warnf(ctx, "bind ticket to group error: %v", err)
+	}
+	return ticket, nil
+}
+
+// ValidateTicketGrantingTicket checks the ticket granting ticket from cookie, and resets its idle timeout if it is valid.
+func (p *TicketProvider) ValidateTicketGrantingTicket(ctx context.Context, ticket string) (*User, error) {
 	tkt, err := ParseTicket(ticket)
 	if err != nil {
 		return nil, err
+	} else if tkt.prefix != TicketGrantingTicketPrefix {
+		return nil, InvalidTicket
 	}
-	var username string
-	if isST {
-		username, err = p.adapter.Del(ctx, ticket)
-		if time.Now().Unix()-tkt.ctime > ServiceTicketTimeToLive {
-			return nil, InvalidTicket
+	value, err := p.adapter.Get(ctx, ticket)
+	if err != nil {
+		return nil, err
+	}
+	if remaining := p.tgtRemaining(tkt); remaining <= 0 {
+		p.adapter.Del(ctx, ticket)
+		return nil, InvalidTicket
+	} else if value != "" {
+		// sliding expiration, the ticket granting ticket is used and its idle timeout is reset
+		if err = p.adapter.Set(ctx, ticket, value, remaining); err != nil {
+			return nil, err
 		}
-	} else {
-		username, err = p.adapter.Get(ctx, ticket)
+	}
+	data, err := decodeTicketData(value)
+	if err != nil {
+		return nil, err
+	}
+	return UP.FindUser(ctx, data.Username)
+}
+
+// ValidateServiceTicket consumes service ticket, or proxy ticket if allowProxy is true, and checks whether it is issued for service.
+func (p *TicketProvider) ValidateServiceTicket(ctx context.Context, ticket, service string, allowProxy bool) (*User, *TicketData, error) {
+	tkt, err := ParseTicket(ticket)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tkt.prefix == ProxyTicketPrefix && !allowProxy {
+		return nil, nil, InvalidTicketSpec
+	} else if tkt.prefix != ServiceTicketPrefix && tkt.prefix != ProxyTicketPrefix {
+		return nil, nil, InvalidTicket
+	}
+
+	value, err := p.adapter.Del(ctx, ticket) // expires with stLifetime as ttl of adapter
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := decodeTicketData(value)
+	if err != nil {
+		return nil, nil, err
+	}
+	if data.Service != service {
+		return nil, nil, InvalidService
+	}
+	user, err := UP.FindUser(ctx, data.Username)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, data, nil
+}
+
+// GenerateLoginTicket issues a login ticket for login form. It is signed like 'LT-<nonce>-<expiry>-<mac>',
+// so that showing login form to anonymous users does not write to ticket store.
+func (p *TicketProvider) GenerateLoginTicket() string {
+	return p.signLoginTicket(time.Now().Add(LoginTicketLifetime))
+}
+
+func (p *TicketProvider) signLoginTicket(expireAt time.Time) string {
+	buf := make([]byte, ServiceTicketRandSize)
+	rand.Read(buf)
+	payload := LoginTicketPrefix + "-" + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf) + "-" + strconv.FormatInt(expireAt.Unix(), 36)
+	return payload + "-" + p.loginTicketMac(payload)
+}
+
+func (p *TicketProvider) loginTicketMac(payload string) string {
+	mac := hmac.New(sha256.New, p.loginKey)
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// ValidateLoginTicket returns InvalidTicket if login ticket is forged or expired.
+func (p *TicketProvider) ValidateLoginTicket(ticket string) error {
+	_, err := p.parseLoginTicket(ticket)
+	return err
+}
+
+func (p *TicketProvider) parseLoginTicket(ticket string) (expireAt time.Time, err error) {
+	i := strings.LastIndexByte(ticket, '-')
+	if i < 0 || !hmac.Equal([]byte(ticket[i+1:]), []byte(p.loginTicketMac(ticket[:i]))) {
+		return time.Time{}, InvalidTicket
+	}
+	parts := strings.Split(ticket[:i], "-")
+	if len(parts) != 3 || parts[0] != LoginTicketPrefix {
+		return time.Time{}, InvalidTicket
+	}
+	exp, err := strconv.ParseInt(parts[2], 36, 64)
+	if err != nil || time.Now().Unix() >= exp {
+		return time.Time{}, InvalidTicket
+	}
+	return time.Unix(exp, 0), nil
+}
+
+// ConsumeLoginTicket validates login ticket and marks it as used until it expires, so that a login form is accepted only once.
+// Adapters have no set-if-absent, so the ticket is pushed to a group named by itself, and only the first push is accepted.
+func (p *TicketProvider) ConsumeLoginTicket(ctx context.Context, ticket string) error {
+	expireAt, err := p.parseLoginTicket(ticket)
+	if err != nil {
+		return err
+	}
+	if err = p.adapter.PushToGroup(ctx, ticket, "used", time.Until(expireAt)); err != nil {
+		return err
+	}
+	used, err := p.adapter.GetGroup(ctx, ticket)
+	if err != nil {
+		return err
+	} else if len(used) != 1 {
+		return InvalidTicket
+	}
+	return nil
+}
+
+// GenerateOidcTicket issues an oidc ticket of prefix with data. If data is granted by a ticket granting ticket,
+// the oidc ticket is bound to its group and does not live longer than it. The actual ttl is returned as expiresIn.
+func (p *TicketProvider) GenerateOidcTicket(ctx context.Context, prefix string, data *TicketData, ttl time.Duration) (ticket string, expiresIn time.Duration, err error) {
+	if data.Granting != "" {
+		remaining, err := p.tgtMaxRemaining(data.Granting)
 		if err != nil {
-			return nil, err
+			return "", 0, err
+		}
+		ttl = min(ttl, remaining)
+	}
+	randsize := ServiceTicketRandSize
+	if prefix == OidcAccessTokenPrefix {
+		randsize = TicketGrantingTicketRandSize
+	}
+	tkt := NewTicket(prefix, p.sequence.Add(1), randsize)
+	ticket = tkt.String()
+	if err = p.setData(ctx, ticket, data, ttl); err != nil {
+		return "", 0, err
+	}
+	if data.Granting != "" {
+		if err = p.BindTicketToGroup(ctx, data.Granting, ticket, data.Service); err != nil {
+			LOG.Warnf(ctx, "bind ticket to group error: %v", err)
 		}
 	}
-	if username == "" {
+	return ticket, ttl, nil
+}
+
+// ValidateOidcTicket returns data of oidc ticket of prefix, and the ticket is consumed if consume is true.
+func (p *TicketProvider) ValidateOidcTicket(ctx context.Context, ticket, prefix string, consume bool) (*TicketData, error) {
+	if tkt, err := ParseTicket(ticket); err != nil {
+		return nil, err
+	} else if tkt.prefix != prefix {
 		return nil, InvalidTicket
 	}
-	return UP.FindUser(ctx, username)
+	var value string
+	var err error
+	if consume {
+		value, err = p.adapter.Del(ctx, ticket)
+	} else {
+		value, err = p.adapter.Get(ctx, ticket)
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := decodeTicketData(value)
+	if err != nil {
+		return nil, err
+	}
+	if data.OIDC == nil {
+		return nil, InvalidTicket
+	}
+	return data, nil
 }
 
-func (p *TicketProvider) DeleteTicket(ctx context.Context, ticket string) (*User, error) {
+func (p *TicketProvider) DeleteTicket(ctx context.Context, ticket string) (*TicketData, error) {
 	_, err := ParseTicket(ticket)
 	if err != nil {
 		return nil, err
 	}
-	username, err := p.adapter.Del(ctx, ticket)
+	value, err := p.adapter.Del(ctx, ticket)
 	if err != nil {
 		return nil, err
 	}
-	if username == "" {
-		return nil, InvalidTicket
+	return decodeTicketData(value)
+}
+
+// GroupMember is saved as json value in ticket group, the service is kept for single logout after ticket is consumed.
+type GroupMember struct {
+	Ticket  string `json:"ticket"`
+	Service string `json:"service,omitempty"`
+}
+
+// BindTicketToGroup binds ticket to the group named by ticket granting ticket, and the group lives as long as the max lifetime of it.
+func (p *TicketProvider) BindTicketToGroup(ctx context.Context, groupname, ticket, service string) error {
+	ttl, err := p.tgtMaxRemaining(groupname)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(GroupMember{Ticket: ticket, Service: service})
+	if err != nil {
+		return err
+	}
+	return p.adapter.PushToGroup(ctx, groupname, string(value), ttl)
+}
+
+func (p *TicketProvider) DeleteTicketGroup(ctx context.Context, groupname string) []GroupMember {
+	values := p.adapter.DeleteGroup(ctx, groupname)
+	members := make([]GroupMember, len(values))
+	for i, value := range values {
+		if err := json.Unmarshal([]byte(value), &members[i]); err != nil {
+			LOG.Warnf(ctx, "decode ticket group member error: %v", err)
+		}
 	}
-	return UP.FindUser(ctx, username)
+	return members
 }
 
-func (p *TicketProvider) BindTicketToGroup(ctx context.Context, groupname string, ticket string) error {
-	return p.adapter.PushToGroup(ctx, groupname, ticket)
+// Session is a live ticket granting ticket with the tickets bound to it, tickets in members may be consumed already.
+type Session struct {
+	Ticket   string        `json:"ticket"`
+	Username string        `json:"username"`
+	AuthTime time.Time     `json:"authTime"`
+	Members  []GroupMember `json:"members"`
 }
 
-func (p *TicketProvider) DeleteTicketGroup(ctx context.Context, groupname string) []string {
-	return p.adapter.DeleteGroup(ctx, groupname)
+// ListSessions returns all live sessions, the most recent login first.
+func (p *TicketProvider) ListSessions(ctx context.Context) ([]Session, error) {
+	tickets, err := p.adapter.List(ctx, TicketGrantingTicketPrefix+"-")
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]Session, 0, len(tickets))
+	for ticket, value := range tickets {
+		data, err := decodeTicketData(value)
+		if err != nil {
+			LOG.Warnf(ctx, "decode ticket data of %s error: %v", ticket, err)
+			continue
+		}
+		values, err := p.adapter.GetGroup(ctx, ticket)
+		if err != nil {
+			return nil, err
+		}
+		session := Session{Ticket: ticket, Username: data.Username, AuthTime: data.AuthTime, Members: make([]GroupMember, len(values))}
+		for i, value := range values {
+			if err := json.Unmarshal([]byte(value), &session.Members[i]); err != nil {
+				LOG.Warnf(ctx, "decode ticket group member error: %v", err)
+			}
+		}
+		sessions = append(sessions, session)
+	}
+	slices.SortFunc(sessions, func(a, b Session) int { return b.AuthTime.Compare(a.AuthTime) })
+	return sessions, nil
 }
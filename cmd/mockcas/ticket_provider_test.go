@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTicketProviderExpiry(t *testing.T) {
+	ctx := context.Background()
+	UP = NewStaticUserProvider()
+	ad := NewMemTicketAdapter()
+	p := NewTicketProvider(ad, 50*time.Millisecond, time.Hour, 100*time.Millisecond)
+
+	tgt, err := p.GenerateTicketGrantingTicket(ctx, "casuser")
+	if err != nil {
+		t.Fatalf("GenerateTicketGrantingTicket() error: %v", err)
+	}
+	for range 3 { // idle timeout is reset on every use
+		time.Sleep(60 * time.Millisecond)
+		if _, err = p.ValidateTicketGrantingTicket(ctx, tgt); err != nil {
+			t.Fatalf("ValidateTicket(tgt) error: %v", err)
+		}
+	}
+	time.Sleep(120 * time.Millisecond)
+	if _, err = p.ValidateTicketGrantingTicket(ctx, tgt); !errors.Is(err, InvalidTicket) {
+		t.Errorf("ValidateTicket(idle tgt) error = %v, want %v", err, InvalidTicket)
+	}
+
+	// service ticket is valid within its lifetime, even if it is shorter than a second
+	tgt, _ = p.GenerateTicketGrantingTicket(ctx, "casuser")
+	st, err := p.GenerateServiceTicket(ctx, tgt, "http://app/validate", false)
+	if err != nil {
+		t.Fatalf("GenerateServiceTicket() error: %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, _, err = p.ValidateServiceTicket(ctx, st, "http://app/validate", false); err != nil {
+		t.Errorf("ValidateTicket(st) error: %v", err)
+	}
+	st, _ = p.GenerateServiceTicket(ctx, tgt, "http://app/validate", false)
+	time.Sleep(60 * time.Millisecond)
+	if _, _, err = p.ValidateServiceTicket(ctx, st, "http://app/validate", false); !errors.Is(err, InvalidTicket) {
+		t.Errorf("ValidateTicket(expired st) error = %v, want %v", err, InvalidTicket)
+	}
+
+	// ticket granting ticket created long ago exceeds max lifetime, even if it is still in store
+	old := NewTicket("TGT", 100, TicketGrantingTicketRandSize)
+	old.ctime -= 3601
+	ad.Set(ctx, old.String(), `{"username":"casuser"}`, time.Hour)
+	if _, err = p.ValidateTicketGrantingTicket(ctx, old.String()); !errors.Is(err, InvalidTicket) {
+		t.Errorf("ValidateTicket(old tgt) error = %v, want %v", err, InvalidTicket)
+	}
+	if username, _ := ad.Get(ctx, old.String()); username != "" {
+		t.Errorf("old tgt is still in store after validation")
+	}
+
+	// derived tickets are not issued with a non-positive ttl once the max lifetime is exhausted
+	if err = p.BindTicketToGroup(ctx, old.String(), st, "http://app/validate"); !errors.Is(err, InvalidTicket) {
+		t.Errorf("BindTicketToGroup(old tgt) error = %v, want %v", err, InvalidTicket)
+	}
+}
+
+func TestLoginTicketSharedKey(t *testing.T) {
+	ctx := context.Background()
+	ad := NewMemTicketAdapter()
+	p1 := NewTicketProvider(ad, time.Second, time.Hour, time.Hour)
+	p2 := NewTicketProvider(ad, time.Second, time.Hour, time.Hour)
+	lt := p1.GenerateLoginTicket()
+	if err := p2.ValidateLoginTicket(lt); !errors.Is(err, InvalidTicket) {
+		t.Errorf("ValidateLoginTicket() with another key error = %v, want %v", err, InvalidTicket)
+	}
+
+	// replicas with the same key accept login tickets of each other, but only once
+	p1.loginKey, p2.loginKey = []byte("secret"), []byte("secret")
+	lt = p1.GenerateLoginTicket()
+	if err := p2.ConsumeLoginTicket(ctx, lt); err != nil {
+		t.Errorf("ConsumeLoginTicket() error: %v", err)
+	}
+	if err := p1.ConsumeLoginTicket(ctx, lt); !errors.Is(err, InvalidTicket) {
+		t.Errorf("ConsumeLoginTicket(used) error = %v, want %v", err, InvalidTicket)
+	}
+}
+
+func TestMemTicketAdapterSweep(t *testing.T) {
+	ctx := context.Background()
+	ad := NewMemTicketAdapter()
+	ad.Set(ctx, "TGT-1", "casuser", time.Hour)
+	ad.Set(ctx, "ST-2", "casuser", time.Millisecond)
+	ad.PushToGroup(ctx, "TGT-1", "ST-2", time.Millisecond)
+	ad.PushToGroup(ctx, "TGT-3", "ST-4", time.Hour)
+	time.Sleep(10 * time.Millisecond)
+
+	if n, err := ad.Sweep(ctx); n != 2 || err != nil {
+		t.Errorf("Sweep() = %d, %v, want 2, nil", n, err)
+	}
+	if len(ad.tMap) != 1 || len(ad.gMap) != 1 {
+		t.Errorf("after Sweep() got %d tickets and %d groups, want 1 and 1", len(ad.tMap), len(ad.gMap))
+	}
+}
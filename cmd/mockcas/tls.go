@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+)
+
+// setupTLS returns nil if TLS is disabled. Otherwise the certificate is loaded from tls-cert and tls-key,
+// or a self-signed one is generated for hosts. The certificate is also trusted by http.DefaultTransport,
+// so the bundled client and callbacks to it still work with a self-signed certificate.
+func setupTLS(ctx context.Context, hosts ...string) *tls.Config {
+	if !CFG.TLS && CFG.TLSCert == "" {
+		return nil
+	}
+
+	var cert tls.Certificate
+	var err error
+	if CFG.TLSCert != "" {
+		if cert, err = tls.LoadX509KeyPair(CFG.TLSCert, CFG.TLSKey); err != nil {
+			LOG.Fatalf(ctx, "load tls certificate error: %v", err)
+		}
+		LOG.Infof(ctx, "loaded tls certificate from %s", CFG.TLSCert)
+	} else {
+		if cert, err = NewSelfSignedCertificate(hosts, 365*24*time.Hour); err != nil {
+			LOG.Fatalf(ctx, "generate self-signed certificate error: %v", err)
+		}
+		sum := sha256.Sum256(cert.Certificate[0])
+		LOG.Warnf(ctx, "using self-signed tls certificate for %v, sha256 fingerprint: %s", hosts, hex.EncodeToString(sum[:]))
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		LOG.Fatalf(ctx, "parse tls certificate error: %v", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	pool.AddCert(cert.Leaf)
+	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{RootCAs: pool}
+
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+}
+
+// NewSelfSignedCertificate generates an ECDSA P-256 certificate valid for hosts, which can be hostnames or ip addresses.
+func NewSelfSignedCertificate(hosts []string, validFor time.Duration) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "mockcas"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if host == "" {
+			continue
+		} else if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
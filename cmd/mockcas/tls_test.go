@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewSelfSignedCertificate(t *testing.T) {
+	cert, err := NewSelfSignedCertificate([]string{"localhost", "127.0.0.1", ""}, time.Hour)
+	if err != nil {
+		t.Fatalf("NewSelfSignedCertificate error: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("parse certificate error: %v", err)
+	}
+	if len(leaf.DNSNames) != 1 || len(leaf.IPAddresses) != 1 {
+		t.Fatalf("certificate has DNSNames %v and IPAddresses %v", leaf.DNSNames, leaf.IPAddresses)
+	}
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("https request error: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Fatalf("https response is %q", body)
+	}
+}
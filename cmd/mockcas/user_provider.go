@@ -3,19 +3,37 @@ package main
 import (
 	"context"
 	"errors"
+	"slices"
+	"strings"
 )
 
+// User has multi-valued attributes, which are released to services as CAS attributes.
 type User struct {
-	Username string
-	Mail     string
-	Mobile   string
+	Username   string
+	Attributes map[string][]string
+	Groups     []string
 }
 
 var (
 	UserNotFoundError              = errors.New("user not found")
 	InvalidUsernameOrPasswordError = errors.New("invalid username or password")
+	UserNotAllowedError            = errors.New("user is not allowed to login")
 )
 
+// CheckLoginAllowed returns UserNotAllowedError if login-allowed-groups is set and user is in none of them.
+// It is checked on password login, single sign-on with ticket granting cookie, oidc callback and tickets minted by admin.
+func CheckLoginAllowed(user *User) error {
+	if CFG.LoginAllowedGroups == "" {
+		return nil
+	}
+	for group := range strings.SplitSeq(CFG.LoginAllowedGroups, ",") {
+		if group = strings.TrimSpace(group); group != "" && slices.Contains(user.Groups, group) {
+			return nil
+		}
+	}
+	return UserNotAllowedError
+}
+
 type UserProvider interface {
 	FindUser(ctx context.Context, username string) (*User, error)
 	ValidateUser(ctx context.Context, username, password string) (*User, error)
@@ -26,13 +44,44 @@ var UP UserProvider
 func setupUserProvider(ctx context.Context) {
 	switch CFG.CasAuthMethod {
 	case "ldap":
+		attrMap, err := ParseAttributeMapping(CFG.LDAPAttributes)
+		if err != nil {
+			LOG.Fatalf(ctx, "parse ldap attributes error: %v", err)
+		}
+		tlsConfig, err := NewLdapTLSConfig(CFG.LDAPServerUrl, CFG.LDAPCaCert)
+		if err != nil {
+			LOG.Fatalf(ctx, "load ldap tls config error: %v", err)
+		}
 		UP = NewLdapUserProvider(
 			CFG.LDAPServerUrl,
 			CFG.LDAPBindDN,
 			CFG.LDAPBindPass,
 			CFG.LDAPBaseDN,
 			CFG.LDAPSearchFilter,
+			attrMap,
+			LdapOptions{
+				StartTLS:    CFG.LDAPStartTLS,
+				TLSConfig:   tlsConfig,
+				DialTimeout: CFG.LDAPDialTimeout,
+				Timeout:     CFG.LDAPTimeout,
+				PoolSize:    CFG.LDAPPoolSize,
+				CacheTTL:    CFG.LDAPCacheTTL,
+				Groups: LdapGroupOptions{
+					MemberOfAttribute: CFG.LDAPGroupMemberOf,
+					BaseDN:            CFG.LDAPGroupBaseDN,
+					SearchFilter:      CFG.LDAPGroupSearchFilter,
+					NameAttribute:     CFG.LDAPGroupName,
+					Nested:            CFG.LDAPGroupNested,
+				},
+			},
 		)
+	case "file":
+		p, err := NewFileUserProvider(CFG.UsersFile)
+		if err != nil {
+			LOG.Fatalf(ctx, "load users file error: %v", err)
+		}
+		go p.Watch(ctx, usersFileCheckInterval)
+		UP = p
 	case "static":
 		UP = NewStaticUserProvider()
 	default:
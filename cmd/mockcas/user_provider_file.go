@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"sigs.k8s.io/yaml"
+)
+
+const usersFileCheckInterval = 2 * time.Second
+
+// dummyPasswordHash is compared when user is not found, so that response time does not reveal whether username exists.
+const dummyPasswordHash = "$2a$10$fjUrqFjQxDsqsQOjUvbfbO4d6voJe7J94Mlnb4Ij8qCo6Bm/PpVr2"
+
+var (
+	UserDisabledError = errors.New("user is disabled")
+	UserLockedError   = errors.New("user is locked")
+)
+
+// fileUser is an entry of users file in YAML or JSON format:
+//
+//	users:
+//	  - username: casuser
+//	    password: $2a$10$INtSEAN3Gtwvh09D.uqVc.wtA7bhqz3IYMYoOpgJc/eZqAS9DcfKK
+//	    groups: [admins]
+//	    attributes:
+//	      mail: casuser@example.org
+//	      memberOf: [cn=users,ou=groups,dc=example,dc=com, cn=admins,ou=groups,dc=example,dc=com]
+//	  - username: olduser
+//	    password: $argon2id$v=19$m=65536,t=3,p=4$c2FsdHNhbHRzYWx0c2FsdA$4htYv63Wa0e/ZLgHK8AvRW5w+KJ1Fk7Pe/F5x7mVYJo
+//	    disabled: true
+//
+// The password should be hashed with bcrypt or argon2, and attribute value can be a string or a list of strings.
+// Or users file can be in htpasswd format with bcrypt hashes only, e.g. from 'htpasswd -nbB casuser Mellon'.
+type fileUser struct {
+	Username   string                `json:"username"`
+	Password   string                `json:"password"`
+	Disabled   bool                  `json:"disabled"`
+	Locked     bool                  `json:"locked"`
+	Groups     []string              `json:"groups"`
+	Attributes map[string]attrValues `json:"attributes"`
+}
+
+// attrValues accepts both a string and a list of strings.
+type attrValues []string
+
+func (v *attrValues) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '[' {
+		return json.Unmarshal(data, (*[]string)(v))
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*v = attrValues{s}
+	return nil
+}
+
+func (u *fileUser) toUser() *User {
+	user := &User{Username: u.Username, Attributes: make(map[string][]string, len(u.Attributes)), Groups: u.Groups}
+	for name, values := range u.Attributes {
+		user.Attributes[name] = values
+	}
+	return user
+}
+
+func (u *fileUser) check() error {
+	if u.Disabled {
+		return UserDisabledError
+	} else if u.Locked {
+		return UserLockedError
+	}
+	return nil
+}
+
+// FileUserProvider loads users from file, and reloads them when the file is modified.
+type FileUserProvider struct {
+	path    string
+	users   atomic.Pointer[map[string]*fileUser]
+	modTime time.Time
+	size    int64
+}
+
+func NewFileUserProvider(path string) (*FileUserProvider, error) {
+	p := &FileUserProvider{path: path}
+	if err := p.load(false); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// load reads users from file. On reload, an empty file is rejected because it may be truncated by an editor and is still being written.
+func (p *FileUserProvider) load(reload bool) error {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+	var users []*fileUser
+	switch strings.ToLower(filepath.Ext(p.path)) {
+	case ".yaml", ".yml", ".json":
+		users, err = parseUsersYAML(data)
+	default:
+		users, err = parseHtpasswd(data)
+	}
+	if err != nil {
+		return err
+	}
+	if reload && len(users) == 0 {
+		return errors.New("no users defined")
+	}
+
+	userMap := make(map[string]*fileUser, len(users))
+	for i, u := range users {
+		if u.Username == "" {
+			return fmt.Errorf("user %d: username is empty", i)
+		}
+		if _, ok := userMap[u.Username]; ok {
+			return fmt.Errorf("user %s: duplicate username", u.Username)
+		}
+		if !isSupportedPasswordHash(u.Password) {
+			return fmt.Errorf("user %s: password should be hashed with bcrypt or argon2", u.Username)
+		}
+		if strings.HasPrefix(u.Password, "$argon2") {
+			if _, err := parseArgon2Hash(u.Password); err != nil {
+				return fmt.Errorf("user %s: %w", u.Username, err)
+			}
+		}
+		userMap[u.Username] = u
+	}
+	p.users.Store(&userMap)
+	p.modTime, p.size = info.ModTime(), info.Size()
+	return nil
+}
+
+func parseUsersYAML(data []byte) ([]*fileUser, error) {
+	var file struct {
+		Users []*fileUser `json:"users"`
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, err
+	}
+	return file.Users, nil
+}
+
+func parseHtpasswd(data []byte) (users []*fileUser, err error) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		username, hash, found := strings.Cut(text, ":")
+		if !found {
+			return nil, fmt.Errorf("htpasswd line %d: missing colon", line)
+		}
+		users = append(users, &fileUser{Username: username, Password: hash})
+	}
+	return users, scanner.Err()
+}
+
+// Watch reloads users when the file is modified until ctx is done. The old users are kept if reload fails.
+func (p *FileUserProvider) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(p.path)
+			if err != nil {
+				LOG.Warnf(ctx, "stat users file error: %v", err)
+				continue
+			}
+			if info.ModTime().Equal(p.modTime) && info.Size() == p.size {
+				continue
+			}
+			if err = p.load(true); err != nil {
+				LOG.Warnf(ctx, "reload users file error: %v", err)
+				p.modTime, p.size = info.ModTime(), info.Size() // avoid retrying until next modification
+				continue
+			}
+			LOG.Infof(ctx, "reloaded %d users from %s", len(*p.users.Load()), p.path)
+		}
+	}
+}
+
+func (p *FileUserProvider) FindUser(_ context.Context, username string) (*User, error) {
+	u, ok := (*p.users.Load())[username]
+	if !ok {
+		return nil, UserNotFoundError
+	}
+	if err := u.check(); err != nil {
+		return nil, err
+	}
+	return u.toUser(), nil
+}
+
+func (p *FileUserProvider) ValidateUser(_ context.Context, username, password string) (*User, error) {
+	u, ok := (*p.users.Load())[username]
+	if !ok {
+		comparePasswordHash(dummyPasswordHash, password)
+		return nil, InvalidUsernameOrPasswordError
+	}
+	if match, err := comparePasswordHash(u.Password, password); err != nil {
+		return nil, err
+	} else if !match {
+		return nil, InvalidUsernameOrPasswordError
+	}
+	// check status after password, so that account status is not exposed to those who do not know the password
+	if err := u.check(); err != nil {
+		return nil, err
+	}
+	return u.toUser(), nil
+}
+
+func isSupportedPasswordHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$") ||
+		strings.HasPrefix(hash, "$argon2id$") || strings.HasPrefix(hash, "$argon2i$")
+}
+
+func comparePasswordHash(hash, password string) (bool, error) {
+	if strings.HasPrefix(hash, "$argon2") {
+		return compareArgon2Hash(hash, password)
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Bounds of argon2 parameters accepted from users file, so that a bad hash cannot exhaust memory or cpu on every login.
+const (
+	argon2MaxMemory     = 1 << 20 // in KiB, 1 GiB
+	argon2MaxIterations = 16
+)
+
+type argon2Hash struct {
+	variant    string
+	memory     uint32
+	iterations uint32
+	threads    uint8
+	salt, key  []byte
+}
+
+// parseArgon2Hash parses hash in PHC string format like '$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>', and checks its parameters.
+func parseArgon2Hash(hash string) (*argon2Hash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return nil, errors.New("invalid argon2 hash format")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+	h := &argon2Hash{variant: parts[1]}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.iterations, &h.threads); err != nil {
+		return nil, fmt.Errorf("invalid argon2 parameters %q", parts[3])
+	}
+	if h.threads == 0 || h.iterations == 0 || h.iterations > argon2MaxIterations || h.memory < 8*uint32(h.threads) || h.memory > argon2MaxMemory {
+		return nil, fmt.Errorf("argon2 parameters %q out of range, want p>0, 0<t<=%d and 8*p<=m<=%d", parts[3], argon2MaxIterations, argon2MaxMemory)
+	}
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, err
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, err
+	}
+	if len(h.key) == 0 {
+		return nil, errors.New("empty argon2 key")
+	}
+	return h, nil
+}
+
+// compareArgon2Hash compares password with hash in PHC string format like '$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>'.
+func compareArgon2Hash(hash, password string) (bool, error) {
+	h, err := parseArgon2Hash(hash)
+	if err != nil {
+		return false, err
+	}
+
+	var derived []byte
+	if h.variant == "argon2id" {
+		derived = argon2.IDKey([]byte(password), h.salt, h.iterations, h.memory, h.threads, uint32(len(h.key)))
+	} else {
+		derived = argon2.Key([]byte(password), h.salt, h.iterations, h.memory, h.threads, uint32(len(h.key)))
+	}
+	return subtle.ConstantTimeCompare(derived, h.key) == 1, nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/whoisnian/glb/logger"
+)
+
+const (
+	testBcryptHash = "$2a$04$lay6one9vur2Fw34fxIuc.MXCbUlvACozJv9IU6kthm1gDJaKd1eK"                                     // Mellon
+	testArgon2Hash = "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0c2FsdA$nhCBpex3o/0Nc5xJjG2zfF1+eZC9Oa/45AwNK/GS1qY" // Mellon
+)
+
+const testUsersYAML = `
+users:
+  - username: casuser
+    password: ` + testBcryptHash + `
+    groups: [admins]
+    attributes:
+      mail: casuser@example.org
+      memberOf: [cn=users, cn=admins]
+  - username: argon
+    password: ` + testArgon2Hash + `
+  - username: disabled
+    password: ` + testBcryptHash + `
+    disabled: true
+  - username: locked
+    password: ` + testBcryptHash + `
+    locked: true
+`
+
+func TestFileUserProvider(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "users.yaml")
+	os.WriteFile(path, []byte(testUsersYAML), 0o644)
+	p, err := NewFileUserProvider(path)
+	if err != nil {
+		t.Fatalf("NewFileUserProvider() error: %v", err)
+	}
+
+	tests := []struct {
+		username, password string
+		err                error
+	}{
+		{"casuser", "Mellon", nil},
+		{"casuser", "wrong", InvalidUsernameOrPasswordError},
+		{"argon", "Mellon", nil},
+		{"argon", "wrong", InvalidUsernameOrPasswordError},
+		{"disabled", "Mellon", UserDisabledError},
+		{"disabled", "wrong", InvalidUsernameOrPasswordError},
+		{"locked", "Mellon", UserLockedError},
+		{"nobody", "Mellon", InvalidUsernameOrPasswordError},
+	}
+	for _, test := range tests {
+		if _, err := p.ValidateUser(ctx, test.username, test.password); !errors.Is(err, test.err) {
+			t.Errorf("ValidateUser(%q, %q) error = %v, want %v", test.username, test.password, err, test.err)
+		}
+	}
+
+	user, err := p.FindUser(ctx, "casuser")
+	want := &User{
+		Username:   "casuser",
+		Attributes: map[string][]string{"mail": {"casuser@example.org"}, "memberOf": {"cn=users", "cn=admins"}},
+		Groups:     []string{"admins"},
+	}
+	if err != nil || !reflect.DeepEqual(user, want) {
+		t.Errorf("FindUser(casuser) = %+v, %v, want %+v", user, err, want)
+	}
+	if _, err = p.FindUser(ctx, "disabled"); !errors.Is(err, UserDisabledError) {
+		t.Errorf("FindUser(disabled) error = %v, want %v", err, UserDisabledError)
+	}
+}
+
+func TestFileUserProviderHtpasswd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	os.WriteFile(path, []byte("# comment\ncasuser:"+testBcryptHash+"\n"), 0o644)
+	p, err := NewFileUserProvider(path)
+	if err != nil {
+		t.Fatalf("NewFileUserProvider() error: %v", err)
+	}
+	if _, err = p.ValidateUser(context.Background(), "casuser", "Mellon"); err != nil {
+		t.Errorf("ValidateUser() error = %v", err)
+	}
+
+	os.WriteFile(path, []byte("casuser:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"), 0o644)
+	if _, err = NewFileUserProvider(path); err == nil {
+		t.Errorf("NewFileUserProvider(sha1 htpasswd) error = nil, want error")
+	}
+}
+
+func TestFileUserProviderArgon2Parameters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	for _, params := range []string{"m=1024,t=1,p=0", "m=4194304,t=1,p=1", "m=1024,t=1000,p=1", "m=1024,t=0,p=1", "m=4,t=1,p=1", "m=1024,t=1,p=256"} {
+		os.WriteFile(path, []byte("casuser:$argon2id$v=19$"+params+"$c2FsdHNhbHRzYWx0c2FsdA$nhCBpex3o/0Nc5xJjG2zfF1+eZC9Oa/45AwNK/GS1qY\n"), 0o644)
+		if _, err := NewFileUserProvider(path); err == nil {
+			t.Errorf("NewFileUserProvider(%s) error = nil, want error", params)
+		}
+	}
+}
+
+func TestFileUserProviderReload(t *testing.T) {
+	LOG = logger.New(logger.NewNanoHandler(io.Discard, logger.Options{}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "users.json")
+	os.WriteFile(path, []byte(`{"users":[{"username":"casuser","password":"`+testBcryptHash+`"}]}`), 0o644)
+	p, err := NewFileUserProvider(path)
+	if err != nil {
+		t.Fatalf("NewFileUserProvider() error: %v", err)
+	}
+	go p.Watch(ctx, 10*time.Millisecond)
+
+	os.WriteFile(path, []byte(`{"users":[{"username":"casuser","password":"`+testBcryptHash+`","disabled":true},{"username":"newuser","password":"`+testArgon2Hash+`"}]}`), 0o644)
+	deadline := time.Now().Add(2 * time.Second)
+	for _, err = p.FindUser(ctx, "newuser"); err != nil && time.Now().Before(deadline); _, err = p.FindUser(ctx, "newuser") {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("FindUser(newuser) after reload error: %v", err)
+	}
+	if _, err = p.FindUser(ctx, "casuser"); !errors.Is(err, UserDisabledError) {
+		t.Errorf("FindUser(casuser) after reload error = %v, want %v", err, UserDisabledError)
+	}
+
+	// invalid or empty file is ignored and the old users are kept
+	os.WriteFile(path, []byte(`{"users":[{"username":"broken"`), 0o644)
+	time.Sleep(50 * time.Millisecond)
+	if _, err = p.FindUser(ctx, "newuser"); err != nil {
+		t.Errorf("FindUser(newuser) after invalid reload error: %v", err)
+	}
+	os.WriteFile(path, nil, 0o644)
+	time.Sleep(50 * time.Millisecond)
+	if _, err = p.FindUser(ctx, "newuser"); err != nil {
+		t.Errorf("FindUser(newuser) after empty reload error: %v", err)
+	}
+
+	// empty file is allowed at startup
+	if _, err = NewFileUserProvider(path); err != nil {
+		t.Errorf("NewFileUserProvider(empty) error: %v", err)
+	}
+}
@@ -2,109 +2,457 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-ldap/ldap/v3"
 )
 
+// LdapOptions configures connections to the LDAP server, and the zero value uses no TLS, no pool and no cache.
+type LdapOptions struct {
+	StartTLS    bool          // upgrade ldap:// connections with StartTLS
+	TLSConfig   *tls.Config   // used by ldaps:// and StartTLS, with system roots if nil
+	DialTimeout time.Duration // timeout of dialing and TLS handshake
+	Timeout     time.Duration // timeout of each request, e.g. bind and search
+	PoolSize    int           // max number of open connections
+	CacheTTL    time.Duration // users found are cached for this duration, 0 to disable cache
+	Groups      LdapGroupOptions
+}
+
+// LdapGroupOptions configures how groups of user are resolved. Groups are searched with SearchFilter if it is not empty,
+// otherwise they are read from MemberOfAttribute of user entry, and no group is resolved if both are empty.
+type LdapGroupOptions struct {
+	MemberOfAttribute string // attribute with DNs of groups which the entry is member of, e.g. 'memberOf'
+	BaseDN            string // base DN to search groups
+	SearchFilter      string // filter to search groups of a member, and '%s' is replaced with DN of the member
+	NameAttribute     string // attribute of group name, the value of first RDN in group DN is used if it is missing
+	Nested            bool   // also resolve groups which the groups are member of
+}
+
+// maxGroupDepth limits levels of nested groups to resolve.
+const maxGroupDepth = 10
+
 type LdapUserProvider struct {
 	serverUrl    string
 	bindDN       string
 	bindPass     string
 	baseDN       string
 	searchFilter string
-	userCache    *sync.Map
+	attrMap      map[string]string // ldap attribute name => cas attribute name
+	opts         LdapOptions
+	pool         *ldapPool
+	userCache    *ldapUserCache
 }
 
-func NewLdapUserProvider(serverUrl, bindDN, bindPass, baseDN, searchFilter string) *LdapUserProvider {
-	return &LdapUserProvider{
+func NewLdapUserProvider(serverUrl, bindDN, bindPass, baseDN, searchFilter string, attrMap map[string]string, opts LdapOptions) *LdapUserProvider {
+	p := &LdapUserProvider{
 		serverUrl:    serverUrl,
 		bindDN:       bindDN,
 		bindPass:     bindPass,
 		baseDN:       baseDN,
 		searchFilter: searchFilter,
-		userCache:    new(sync.Map),
+		attrMap:      attrMap,
+		opts:         opts,
+		userCache:    newLdapUserCache(opts.CacheTTL),
 	}
+	p.pool = newLdapPool(opts.PoolSize, p.dialAndBind)
+	return p
 }
 
-func (p *LdapUserProvider) connectBindSearch(username string) (*ldap.Conn, []*ldap.Entry, error) {
-	conn, err := ldap.DialURL(p.serverUrl)
+// NewLdapTLSConfig returns tls config to verify LDAP server of serverUrl, with CA certificates in caFile if it is not empty.
+func NewLdapTLSConfig(serverUrl, caFile string) (*tls.Config, error) {
+	u, err := url.Parse(serverUrl)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
+	config := &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12} // ServerName is required by StartTLS
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+	}
+	return config, nil
+}
 
+// ParseAttributeMapping parses mapping like 'mail,displayName=name,employeeNumber=employeeId'.
+// Each item is 'ldapName' or 'ldapName=casName', and the cas attribute name is the same as ldap one if omitted.
+func ParseAttributeMapping(s string) (map[string]string, error) {
+	attrMap := make(map[string]string)
+	for item := range strings.SplitSeq(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		ldapName, casName, found := strings.Cut(item, "=")
+		ldapName, casName = strings.TrimSpace(ldapName), strings.TrimSpace(casName)
+		if !found {
+			casName = ldapName
+		}
+		if ldapName == "" || casName == "" {
+			return nil, fmt.Errorf("invalid attribute mapping %q", item)
+		}
+		attrMap[ldapName] = casName
+	}
+	return attrMap, nil
+}
+
+func (p *LdapUserProvider) entryToUser(username string, entry *ldap.Entry, groups []string) User {
+	user := User{Username: username, Attributes: make(map[string][]string), Groups: groups}
+	for ldapName, casName := range p.attrMap {
+		if values := entry.GetEqualFoldAttributeValues(ldapName); len(values) > 0 {
+			user.Attributes[casName] = append(user.Attributes[casName], values...)
+		}
+	}
+	return user
+}
+
+// dialAndBind opens a new connection for pool, which is bound as the admin DN. Dialing also stops at deadline of ctx.
+func (p *LdapUserProvider) dialAndBind(ctx context.Context) (*ldap.Conn, error) {
+	dialer := &net.Dialer{Timeout: p.opts.DialTimeout}
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Deadline = deadline
+	}
+	conn, err := ldap.DialURL(p.serverUrl, ldap.DialWithDialer(dialer), ldap.DialWithTLSConfig(p.opts.TLSConfig))
+	if err != nil {
+		return nil, err
+	}
+	if p.opts.Timeout > 0 {
+		conn.SetTimeout(p.opts.Timeout)
+	}
+	if p.opts.StartTLS {
+		if err = conn.StartTLS(p.opts.TLSConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("start tls: %w", err)
+		}
+	}
 	if err = conn.Bind(p.bindDN, p.bindPass); err != nil {
 		conn.Close()
-		return nil, nil, err
+		return nil, err
 	}
+	return conn, nil
+}
 
+// withConn runs fn with a pooled connection, and retries once with a new connection if the idle one was closed by server
+// before response, e.g. by its idle timeout. Request timeout is not retried. The fn returns broken as true if the connection
+// should not be reused.
+func (p *LdapUserProvider) withConn(ctx context.Context, fn func(conn *ldap.Conn) (broken bool, err error)) error {
+	if p.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.opts.Timeout)
+		defer cancel()
+	}
+	for {
+		conn, reused, err := p.pool.Get(ctx)
+		if err != nil {
+			return err
+		}
+		broken, err := fn(conn)
+		closed := conn.IsClosing() // a timed out connection is still open
+		p.pool.Put(conn, broken)
+		var ldapErr *ldap.Error
+		responded := errors.As(err, &ldapErr) && ldapErr.ResultCode != ldap.ErrorNetwork
+		if reused && closed && err != nil && !responded && ctx.Err() == nil {
+			continue // closed connections are discarded, so retries are bounded by idle connections in pool
+		}
+		return err
+	}
+}
+
+func (p *LdapUserProvider) search(conn *ldap.Conn, username string) ([]*ldap.Entry, error) {
 	searchDN := fmt.Sprintf(p.searchFilter, ldap.EscapeFilter(username))
+	attributes := []string{"*"} // operational attributes like memberOf are returned only if requested explicitly
+	for ldapName := range p.attrMap {
+		attributes = append(attributes, ldapName)
+	}
+	if p.opts.Groups.MemberOfAttribute != "" {
+		attributes = append(attributes, p.opts.Groups.MemberOfAttribute)
+	}
 	searchReq := ldap.NewSearchRequest(
 		p.baseDN,
-		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		searchDN, []string{"*"}, nil,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(p.opts.Timeout.Seconds()), false,
+		searchDN, attributes, nil,
 	)
 	searchRes, err := conn.Search(searchReq)
 	if err != nil {
-		conn.Close()
-		return nil, nil, err
+		return nil, err
+	}
+	return searchRes.Entries, nil
+}
+
+// lookup searches entries of username, and resolves groups if exactly one entry is found.
+func (p *LdapUserProvider) lookup(conn *ldap.Conn, username string) (entries []*ldap.Entry, groups []string, err error) {
+	if entries, err = p.search(conn, username); err != nil || len(entries) != 1 {
+		return entries, nil, err
+	}
+	groups, err = p.resolveGroups(conn, entries[0])
+	return entries, groups, err
+}
+
+// resolveGroups returns sorted names of groups which entry is member of, and also their parent groups if nested.
+func (p *LdapUserProvider) resolveGroups(conn *ldap.Conn, entry *ldap.Entry) ([]string, error) {
+	g := p.opts.Groups
+	if g.SearchFilter == "" && g.MemberOfAttribute == "" {
+		return nil, nil
+	}
+	var names []string
+	visited := make(map[string]bool)
+	members := []*ldap.Entry{entry}
+	for depth := 0; len(members) > 0 && depth < maxGroupDepth; depth++ {
+		var next []*ldap.Entry
+		for _, member := range members {
+			groups, err := p.directGroups(conn, member)
+			if err != nil {
+				return nil, err
+			}
+			for _, group := range groups {
+				if key := strings.ToLower(group.DN); visited[key] {
+					continue // avoid loops in nested groups
+				} else {
+					visited[key] = true
+				}
+				if name := p.groupName(group); name != "" {
+					names = append(names, name)
+				}
+				next = append(next, group)
+			}
+		}
+		if !g.Nested {
+			break
+		}
+		members = next
+	}
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
+
+// directGroups returns entries of groups which member belongs to directly. Groups from memberOf attribute
+// are read again only if nested, because their own memberOf attribute is required then.
+func (p *LdapUserProvider) directGroups(conn *ldap.Conn, member *ldap.Entry) ([]*ldap.Entry, error) {
+	g := p.opts.Groups
+	attributes := []string{g.NameAttribute}
+	if g.NameAttribute == "" {
+		attributes = []string{"1.1"} // no attributes
+	}
+	if g.SearchFilter != "" {
+		searchRes, err := conn.Search(ldap.NewSearchRequest(
+			g.BaseDN,
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, int(p.opts.Timeout.Seconds()), false,
+			fmt.Sprintf(g.SearchFilter, ldap.EscapeFilter(member.DN)), attributes, nil,
+		))
+		if err != nil {
+			return nil, err
+		}
+		return searchRes.Entries, nil
+	}
+
+	var groups []*ldap.Entry
+	for _, dn := range member.GetEqualFoldAttributeValues(g.MemberOfAttribute) {
+		if !g.Nested {
+			groups = append(groups, &ldap.Entry{DN: dn})
+			continue
+		}
+		searchRes, err := conn.Search(ldap.NewSearchRequest(
+			dn,
+			ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, int(p.opts.Timeout.Seconds()), false,
+			"(objectClass=*)", append(attributes, g.MemberOfAttribute), nil,
+		))
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		groups = append(groups, searchRes.Entries...)
 	}
-	return conn, searchRes.Entries, nil
+	return groups, nil
 }
 
-func (p *LdapUserProvider) FindUser(_ context.Context, username string) (*User, error) {
-	if val, ok := p.userCache.Load(username); ok {
-		user := val.(User)
+func (p *LdapUserProvider) groupName(group *ldap.Entry) string {
+	if p.opts.Groups.NameAttribute != "" {
+		if name := group.GetEqualFoldAttributeValue(p.opts.Groups.NameAttribute); name != "" {
+			return name
+		}
+	}
+	dn, err := ldap.ParseDN(group.DN)
+	if err != nil || len(dn.RDNs) == 0 || len(dn.RDNs[0].Attributes) == 0 {
+		return ""
+	}
+	return dn.RDNs[0].Attributes[0].Value
+}
+
+func (p *LdapUserProvider) FindUser(ctx context.Context, username string) (*User, error) {
+	if user, ok := p.userCache.Get(username); ok {
 		return &user, nil
 	}
-	conn, entries, err := p.connectBindSearch(username)
+	var entries []*ldap.Entry
+	var groups []string
+	err := p.withConn(ctx, func(conn *ldap.Conn) (bool, error) {
+		var err error
+		entries, groups, err = p.lookup(conn, username)
+		return err != nil, err
+	})
 	if err != nil {
 		return nil, err
 	}
-	conn.Close()
 
 	if len(entries) < 1 {
+		p.userCache.Invalidate(username)
 		return nil, UserNotFoundError
 	} else if len(entries) > 1 {
 		return nil, errors.New("too many entries in search result")
 	}
 
-	user := User{
-		Username: username,
-		Mail:     entries[0].GetAttributeValue("mail"),
-		Mobile:   entries[0].GetAttributeValue("mobile"),
-	}
-	p.userCache.Store(username, user)
+	user := p.entryToUser(username, entries[0], groups)
+	p.userCache.Set(username, user)
 	return &user, nil
 }
 
+// ValidateUser binds as the user with password, and the connection is bound as the admin DN again before released to pool.
+// The cached user is always refreshed, so attribute changes are seen after login.
 func (p *LdapUserProvider) ValidateUser(ctx context.Context, username, password string) (*User, error) {
-	conn, entries, err := p.connectBindSearch(username)
+	var entries []*ldap.Entry
+	var groups []string
+	var bindErr error
+	err := p.withConn(ctx, func(conn *ldap.Conn) (bool, error) {
+		var err error
+		if entries, groups, err = p.lookup(conn, username); err != nil {
+			return true, err
+		} else if len(entries) != 1 {
+			return false, nil
+		}
+		bindErr = conn.Bind(entries[0].DN, password)
+		if err = conn.Bind(p.bindDN, p.bindPass); err != nil {
+			return true, fmt.Errorf("rebind as admin: %w", err)
+		}
+		return false, nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
 
 	if len(entries) < 1 {
+		p.userCache.Invalidate(username)
 		return nil, InvalidUsernameOrPasswordError
 	} else if len(entries) > 1 {
 		return nil, errors.New("too many entries in search result")
 	}
-
-	if err = conn.Bind(entries[0].DN, password); err != nil {
-		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+	if bindErr != nil {
+		if ldap.IsErrorWithCode(bindErr, ldap.LDAPResultInvalidCredentials) {
 			return nil, InvalidUsernameOrPasswordError
 		}
-		return nil, err
+		return nil, bindErr
 	}
 
-	user := User{
-		Username: username,
-		Mail:     entries[0].GetAttributeValue("mail"),
-		Mobile:   entries[0].GetAttributeValue("mobile"),
-	}
-	p.userCache.Store(username, user)
+	user := p.entryToUser(username, entries[0], groups)
+	p.userCache.Set(username, user)
 	return &user, nil
 }
+
+// InvalidateCache removes username from cache, or all users if username is empty. It returns the number of removed users.
+func (p *LdapUserProvider) InvalidateCache(username string) int {
+	return p.userCache.Invalidate(username)
+}
+
+// HealthCheck reads root DSE of the LDAP server with a pooled connection.
+func (p *LdapUserProvider) HealthCheck(ctx context.Context) HealthCheck {
+	start := time.Now()
+	err := p.withConn(ctx, func(conn *ldap.Conn) (bool, error) {
+		_, err := conn.Search(ldap.NewSearchRequest("", ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false, "(objectClass=*)", []string{"1.1"}, nil))
+		return err != nil, err
+	})
+	open, idle := p.pool.Stats()
+	check := HealthCheck{
+		Status:  HealthStatusUp,
+		Latency: time.Since(start).String(),
+		Details: map[string]any{"server": p.serverUrl, "openConns": open, "idleConns": idle, "cachedUsers": p.userCache.Len()},
+	}
+	if err != nil {
+		check.Status, check.Error = HealthStatusDown, err.Error()
+	}
+	return check
+}
+
+// Close closes idle connections in pool.
+func (p *LdapUserProvider) Close() {
+	p.pool.Close()
+}
+
+// ldapUserCache keeps users until ttl, and nothing is cached if ttl is 0.
+type ldapUserCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]ldapUserCacheEntry
+}
+
+type ldapUserCacheEntry struct {
+	user    User
+	expires time.Time
+}
+
+func newLdapUserCache(ttl time.Duration) *ldapUserCache {
+	return &ldapUserCache{ttl: ttl, entries: make(map[string]ldapUserCacheEntry)}
+}
+
+func (c *ldapUserCache) Get(username string) (User, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[username]
+	if !ok {
+		return User{}, false
+	} else if time.Now().After(entry.expires) {
+		delete(c.entries, username)
+		return User{}, false
+	}
+	return entry.user, true
+}
+
+func (c *ldapUserCache) Set(username string, user User) {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for name, entry := range c.entries { // remove expired users to keep cache small
+		if now.After(entry.expires) {
+			delete(c.entries, name)
+		}
+	}
+	c.entries[username] = ldapUserCacheEntry{user: user, expires: now.Add(c.ttl)}
+}
+
+func (c *ldapUserCache) Invalidate(username string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if username == "" {
+		n := len(c.entries)
+		clear(c.entries)
+		return n
+	}
+	if _, ok := c.entries[username]; ok {
+		delete(c.entries, username)
+		return 1
+	}
+	return 0
+}
+
+func (c *ldapUserCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
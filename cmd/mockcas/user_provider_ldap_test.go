@@ -0,0 +1,635 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+func TestParseAttributeMapping(t *testing.T) {
+	got, err := ParseAttributeMapping(" mail, displayName=name ,employeeNumber=employeeId,")
+	want := map[string]string{"mail": "mail", "displayName": "name", "employeeNumber": "employeeId"}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseAttributeMapping() = %v, %v, want %v", got, err, want)
+	}
+	if _, err = ParseAttributeMapping("mail,=name"); err == nil {
+		t.Errorf("ParseAttributeMapping(invalid) error = nil, want error")
+	}
+}
+
+func TestLdapEntryToUser(t *testing.T) {
+	p := NewLdapUserProvider("", "", "", "", "", map[string]string{"mail": "email", "memberOf": "memberOf", "mobile": "mobile"}, LdapOptions{})
+	entry := ldap.NewEntry("uid=casuser,ou=people,"+testLdapBaseDN, map[string][]string{
+		"Mail":        {"casuser@example.org", "cas@example.org"},
+		"memberOf":    {"cn=admins,ou=groups," + testLdapBaseDN, "cn=staff,ou=groups," + testLdapBaseDN},
+		"displayName": {"CAS User"},
+	})
+	user := p.entryToUser("casuser", entry, []string{"admins"})
+	want := map[string][]string{
+		"email":    {"casuser@example.org", "cas@example.org"},
+		"memberOf": {"cn=admins,ou=groups," + testLdapBaseDN, "cn=staff,ou=groups," + testLdapBaseDN},
+	}
+	if user.Username != "casuser" || !reflect.DeepEqual(user.Attributes, want) || !reflect.DeepEqual(user.Groups, []string{"admins"}) {
+		t.Errorf("entryToUser() = %+v, want attributes %v", user, want)
+	}
+}
+
+const (
+	testLdapBaseDN   = "dc=example,dc=com"
+	testLdapBindDN   = "cn=admin,dc=example,dc=com"
+	testLdapBindPass = "admin-secret"
+
+	testLdapAdminsDN    = "cn=admins,ou=groups,dc=example,dc=com"
+	testLdapStaffDN     = "cn=staff,ou=groups,dc=example,dc=com"
+	testLdapEmployeesDN = "cn=employees,ou=groups,dc=example,dc=com"
+)
+
+type ldapStubEntry struct {
+	dn    string
+	attrs map[string][]string
+}
+
+// ldapStubServer is an in-process LDAP server, which supports simple bind, search with and/or/not/equality/present filters and StartTLS.
+type ldapStubServer struct {
+	ln        net.Listener
+	url       string
+	tlsConfig *tls.Config // StartTLS is supported if not nil
+
+	mu          sync.Mutex
+	entries     []*ldapStubEntry
+	passwords   map[string]string // dn => password
+	searchDelay time.Duration
+
+	conns    atomic.Int32
+	binds    atomic.Int32
+	searches atomic.Int32
+	dropNext atomic.Bool // close connection on next request without response, like idle timeout of server
+}
+
+// newLdapStubServer starts stub server with admin and users casuser / Mellon and alice / Wonderland.
+// The casuser is member of admins and staff, and alice is member of staff.
+// The ldaps:// url is used if listenTLS is not nil.
+func newLdapStubServer(t *testing.T, listenTLS *tls.Config) *ldapStubServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen error: %v", err)
+	}
+	s := &ldapStubServer{ln: ln, url: "ldap://" + ln.Addr().String()}
+	if listenTLS != nil {
+		s.ln, s.url = tls.NewListener(ln, listenTLS), "ldaps://"+ln.Addr().String()
+	}
+	s.passwords = map[string]string{
+		testLdapBindDN: testLdapBindPass,
+		"uid=casuser,ou=people," + testLdapBaseDN: "Mellon",
+		"uid=alice,ou=people," + testLdapBaseDN:   "Wonderland",
+	}
+	s.entries = []*ldapStubEntry{
+		{dn: "uid=casuser,ou=people," + testLdapBaseDN, attrs: map[string][]string{
+			"objectClass": {"inetOrgPerson"}, "uid": {"casuser"}, "mail": {"casuser@example.org"}, "displayName": {"CAS User"},
+			"memberOf": {testLdapAdminsDN, testLdapStaffDN},
+		}},
+		{dn: "uid=alice,ou=people," + testLdapBaseDN, attrs: map[string][]string{
+			"objectClass": {"inetOrgPerson"}, "uid": {"alice"}, "mail": {"alice@example.org"},
+			"memberOf": {testLdapStaffDN},
+		}},
+		// admins is nested in staff, and staff and employees are nested in each other
+		{dn: testLdapAdminsDN, attrs: map[string][]string{
+			"objectClass": {"groupOfUniqueNames"}, "cn": {"admins"}, "memberOf": {testLdapStaffDN},
+			"uniqueMember": {"uid=casuser,ou=people," + testLdapBaseDN},
+		}},
+		{dn: testLdapStaffDN, attrs: map[string][]string{
+			"objectClass": {"groupOfUniqueNames"}, "cn": {"staff"}, "memberOf": {testLdapEmployeesDN},
+			"uniqueMember": {"uid=casuser,ou=people," + testLdapBaseDN, "uid=alice,ou=people," + testLdapBaseDN, testLdapAdminsDN, testLdapEmployeesDN},
+		}},
+		{dn: testLdapEmployeesDN, attrs: map[string][]string{
+			"objectClass": {"groupOfUniqueNames"}, "cn": {"employees"}, "memberOf": {testLdapStaffDN},
+			"uniqueMember": {testLdapStaffDN},
+		}},
+	}
+	go func() {
+		for {
+			conn, err := s.ln.Accept()
+			if err != nil {
+				return
+			}
+			s.conns.Add(1)
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { s.ln.Close() })
+	return s
+}
+
+func (s *ldapStubServer) setAttr(dn, name string, values ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.entries {
+		if strings.EqualFold(entry.dn, dn) {
+			entry.attrs[name] = values
+		}
+	}
+}
+
+func ldapStubResult(id int64, tag ber.Tag, code int64, msg string) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, msg, ""))
+	packet.AppendChild(op)
+	return packet
+}
+
+func ldapStubSearchEntry(id int64, entry *ldapStubEntry, attributes []string) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, ""))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	for name, values := range entry.attrs {
+		if !slicesContainsFold(attributes, name) && !slicesContainsFold(attributes, "*") {
+			continue
+		}
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+		}
+		attr.AppendChild(set)
+		attrs.AppendChild(attr)
+	}
+	op.AppendChild(attrs)
+	packet.AppendChild(op)
+	return packet
+}
+
+func slicesContainsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *ldapStubEntry) values(name string) []string {
+	for key, values := range e.attrs {
+		if strings.EqualFold(key, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+func (e *ldapStubEntry) match(filter *ber.Packet) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !e.match(child) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if e.match(child) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !e.match(filter.Children[0])
+	case ldap.FilterEqualityMatch:
+		name, value := filter.Children[0].Value.(string), filter.Children[1].Value.(string)
+		if strings.EqualFold(name, "distinguishedName") || strings.EqualFold(name, "entryDN") {
+			return strings.EqualFold(e.dn, value)
+		}
+		return slicesContainsFold(e.values(name), value)
+	case ldap.FilterPresent:
+		name := filter.Data.String()
+		return strings.EqualFold(name, "objectClass") || len(e.values(name)) > 0
+	}
+	return false
+}
+
+func (s *ldapStubServer) search(id int64, op *ber.Packet) []*ber.Packet {
+	s.mu.Lock()
+	delay := s.searchDelay
+	s.mu.Unlock()
+	time.Sleep(delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	base, scope, filter := op.Children[0].Value.(string), op.Children[1].Value.(int64), op.Children[6]
+	var attributes []string
+	for _, attr := range op.Children[7].Children {
+		attributes = append(attributes, attr.Value.(string))
+	}
+	var result []*ber.Packet
+	if base == "" && scope == ldap.ScopeBaseObject { // root DSE
+		result = append(result, ldapStubSearchEntry(id, &ldapStubEntry{attrs: map[string][]string{"namingContexts": {testLdapBaseDN}}}, attributes))
+	}
+	for _, entry := range s.entries {
+		inScope := strings.EqualFold(entry.dn, base)
+		if scope != ldap.ScopeBaseObject {
+			inScope = inScope || strings.HasSuffix(strings.ToLower(entry.dn), ","+strings.ToLower(base))
+		}
+		if inScope && entry.match(filter) {
+			result = append(result, ldapStubSearchEntry(id, entry, attributes))
+		}
+	}
+	return append(result, ldapStubResult(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess, ""))
+}
+
+func (s *ldapStubServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		if s.dropNext.CompareAndSwap(true, false) {
+			return
+		}
+		id, op := packet.Children[0].Value.(int64), packet.Children[1]
+		var responses []*ber.Packet
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			s.binds.Add(1)
+			name, password := op.Children[1].Value.(string), op.Children[2].Data.String()
+			s.mu.Lock()
+			want, ok := s.passwords[name]
+			s.mu.Unlock()
+			code := int64(ldap.LDAPResultSuccess)
+			if !ok || password != want {
+				code = ldap.LDAPResultInvalidCredentials
+			}
+			responses = append(responses, ldapStubResult(id, ldap.ApplicationBindResponse, code, ""))
+		case ldap.ApplicationUnbindRequest:
+			return
+		case ldap.ApplicationSearchRequest:
+			s.searches.Add(1)
+			responses = s.search(id, op)
+		case ldap.ApplicationExtendedRequest:
+			if s.tlsConfig == nil || op.Children[0].Data.String() != "1.3.6.1.4.1.1466.20037" {
+				responses = append(responses, ldapStubResult(id, ldap.ApplicationExtendedResponse, ldap.LDAPResultProtocolError, "unsupported"))
+				break
+			}
+			if _, err = conn.Write(ldapStubResult(id, ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess, "").Bytes()); err != nil {
+				return
+			}
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err = tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			continue
+		default:
+			return
+		}
+		for _, resp := range responses {
+			if _, err = conn.Write(resp.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func newTestLdapUserProvider(t *testing.T, serverUrl string, opts LdapOptions) *LdapUserProvider {
+	t.Helper()
+	if opts.TLSConfig == nil {
+		opts.TLSConfig, _ = NewLdapTLSConfig(serverUrl, "")
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	p := NewLdapUserProvider(serverUrl, testLdapBindDN, testLdapBindPass, "ou=people,"+testLdapBaseDN, "(&(objectClass=inetOrgPerson)(uid=%s))", map[string]string{"mail": "mail", "displayName": "name"}, opts)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestLdapUserProvider(t *testing.T) {
+	stub := newLdapStubServer(t, nil)
+	p := newTestLdapUserProvider(t, stub.url, LdapOptions{PoolSize: 2})
+
+	user, err := p.FindUser(t.Context(), "casuser")
+	if err != nil {
+		t.Fatalf("FindUser error: %v", err)
+	}
+	if want := map[string][]string{"mail": {"casuser@example.org"}, "name": {"CAS User"}}; !reflect.DeepEqual(user.Attributes, want) {
+		t.Fatalf("FindUser attributes = %v, want %v", user.Attributes, want)
+	}
+	if _, err = p.FindUser(t.Context(), "nobody"); !errors.Is(err, UserNotFoundError) {
+		t.Fatalf("FindUser(nobody) error = %v, want UserNotFoundError", err)
+	}
+	if _, err = p.ValidateUser(t.Context(), "casuser", "wrong"); !errors.Is(err, InvalidUsernameOrPasswordError) {
+		t.Fatalf("ValidateUser(wrong password) error = %v, want InvalidUsernameOrPasswordError", err)
+	}
+	if _, err = p.ValidateUser(t.Context(), "alice", "Wonderland"); err != nil {
+		t.Fatalf("ValidateUser error: %v", err)
+	}
+	// the connection is bound as admin again after user bind, so it is still usable for search
+	if _, err = p.FindUser(t.Context(), "alice"); err != nil {
+		t.Fatalf("FindUser after ValidateUser error: %v", err)
+	}
+	if n := stub.conns.Load(); n != 1 {
+		t.Fatalf("got %d connections for sequential requests, want 1 reused", n)
+	}
+
+	p.InvalidateCache("")
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			if _, err := p.ValidateUser(t.Context(), "casuser", "Mellon"); err != nil {
+				t.Errorf("concurrent ValidateUser error: %v", err)
+			}
+		})
+	}
+	wg.Wait()
+	if open, _ := p.pool.Stats(); open > 2 || stub.conns.Load() > 2 {
+		t.Fatalf("got %d open and %d total connections, want at most pool size 2", open, stub.conns.Load())
+	}
+}
+
+func TestLdapUserProviderCache(t *testing.T) {
+	stub := newLdapStubServer(t, nil)
+	p := newTestLdapUserProvider(t, stub.url, LdapOptions{CacheTTL: 100 * time.Millisecond})
+	casuserDN := "uid=casuser,ou=people," + testLdapBaseDN
+	findMail := func() string {
+		t.Helper()
+		user, err := p.FindUser(t.Context(), "casuser")
+		if err != nil {
+			t.Fatalf("FindUser error: %v", err)
+		}
+		return user.Attributes["mail"][0]
+	}
+
+	findMail()
+	stub.setAttr(casuserDN, "mail", "changed@example.org")
+	if mail := findMail(); mail != "casuser@example.org" {
+		t.Fatalf("cached mail = %s, want the old one", mail)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if mail := findMail(); mail != "changed@example.org" {
+		t.Fatalf("mail after ttl = %s, want the changed one", mail)
+	}
+
+	stub.setAttr(casuserDN, "mail", "again@example.org")
+	if n := p.InvalidateCache("casuser"); n != 1 {
+		t.Fatalf("InvalidateCache = %d, want 1", n)
+	}
+	if mail := findMail(); mail != "again@example.org" {
+		t.Fatalf("mail after invalidation = %s, want the changed one", mail)
+	}
+
+	// login always refreshes the cached user
+	stub.setAttr(casuserDN, "mail", "login@example.org")
+	if _, err := p.ValidateUser(t.Context(), "casuser", "Mellon"); err != nil {
+		t.Fatalf("ValidateUser error: %v", err)
+	}
+	if mail := findMail(); mail != "login@example.org" {
+		t.Fatalf("mail after login = %s, want the changed one", mail)
+	}
+}
+
+func TestLdapUserProviderTLS(t *testing.T) {
+	cert, err := NewSelfSignedCertificate([]string{"127.0.0.1"}, time.Hour)
+	if err != nil {
+		t.Fatalf("NewSelfSignedCertificate error: %v", err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err = os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}), 0o644); err != nil {
+		t.Fatalf("write ca file error: %v", err)
+	}
+	serverTLS := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	t.Run("starttls", func(t *testing.T) {
+		stub := newLdapStubServer(t, nil)
+		stub.tlsConfig = serverTLS
+		tlsConfig, err := NewLdapTLSConfig(stub.url, caFile)
+		if err != nil {
+			t.Fatalf("NewLdapTLSConfig error: %v", err)
+		}
+		if _, err = newTestLdapUserProvider(t, stub.url, LdapOptions{StartTLS: true, TLSConfig: tlsConfig}).ValidateUser(t.Context(), "casuser", "Mellon"); err != nil {
+			t.Fatalf("ValidateUser with StartTLS error: %v", err)
+		}
+		if _, err = newTestLdapUserProvider(t, stub.url, LdapOptions{StartTLS: true}).FindUser(t.Context(), "casuser"); err == nil {
+			t.Fatal("FindUser with StartTLS should fail without the CA")
+		}
+	})
+
+	t.Run("ldaps", func(t *testing.T) {
+		stub := newLdapStubServer(t, serverTLS)
+		tlsConfig, err := NewLdapTLSConfig(stub.url, caFile)
+		if err != nil {
+			t.Fatalf("NewLdapTLSConfig error: %v", err)
+		}
+		if _, err = newTestLdapUserProvider(t, stub.url, LdapOptions{TLSConfig: tlsConfig}).FindUser(t.Context(), "casuser"); err != nil {
+			t.Fatalf("FindUser with ldaps error: %v", err)
+		}
+	})
+}
+
+func TestLdapUserProviderTimeout(t *testing.T) {
+	stub := newLdapStubServer(t, nil)
+	p := newTestLdapUserProvider(t, stub.url, LdapOptions{Timeout: 100 * time.Millisecond})
+	if _, err := p.FindUser(t.Context(), "casuser"); err != nil {
+		t.Fatalf("FindUser error: %v", err)
+	}
+	stub.mu.Lock()
+	stub.searchDelay = time.Second
+	stub.mu.Unlock()
+	stub.searches.Store(0)
+
+	start := time.Now()
+	if _, err := p.FindUser(t.Context(), "casuser"); err == nil {
+		t.Fatal("FindUser should fail with timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 800*time.Millisecond {
+		t.Fatalf("FindUser took %s, want timeout of 100ms", elapsed)
+	}
+	if searches := stub.searches.Load(); searches != 1 {
+		t.Fatalf("stub got %d searches, want 1 without retry after timeout", searches)
+	}
+}
+
+func TestLdapUserProviderRetry(t *testing.T) {
+	stub := newLdapStubServer(t, nil)
+	p := newTestLdapUserProvider(t, stub.url, LdapOptions{})
+
+	if _, err := p.FindUser(t.Context(), "casuser"); err != nil {
+		t.Fatalf("FindUser error: %v", err)
+	}
+	// idle connection closed by server is replaced transparently
+	stub.dropNext.Store(true)
+	if _, err := p.FindUser(t.Context(), "casuser"); err != nil {
+		t.Fatalf("FindUser after idle connection closed error: %v", err)
+	}
+	if conns := stub.conns.Load(); conns != 2 {
+		t.Fatalf("stub got %d connections, want 2", conns)
+	}
+}
+
+func TestLdapUserProviderGroups(t *testing.T) {
+	stub := newLdapStubServer(t, nil)
+	memberOf := LdapGroupOptions{MemberOfAttribute: "memberOf", NameAttribute: "cn"}
+	search := LdapGroupOptions{BaseDN: "ou=groups," + testLdapBaseDN, SearchFilter: "(&(objectClass=groupOfUniqueNames)(uniqueMember=%s))", NameAttribute: "cn"}
+	nested := func(g LdapGroupOptions) LdapGroupOptions { g.Nested = true; return g }
+
+	tests := []struct {
+		name     string
+		groups   LdapGroupOptions
+		username string
+		want     []string
+	}{
+		{"disabled", LdapGroupOptions{}, "casuser", nil},
+		{"memberOf", memberOf, "casuser", []string{"admins", "staff"}},
+		{"memberOf rdn", LdapGroupOptions{MemberOfAttribute: "memberOf"}, "alice", []string{"staff"}},
+		{"memberOf nested", nested(memberOf), "casuser", []string{"admins", "employees", "staff"}},
+		{"memberOf nested", nested(memberOf), "alice", []string{"employees", "staff"}},
+		{"search", search, "casuser", []string{"admins", "staff"}},
+		{"search nested", nested(search), "casuser", []string{"admins", "employees", "staff"}},
+		{"search nested", nested(search), "alice", []string{"employees", "staff"}},
+	}
+	for _, tt := range tests {
+		p := newTestLdapUserProvider(t, stub.url, LdapOptions{Groups: tt.groups})
+		user, err := p.FindUser(t.Context(), tt.username)
+		if err != nil {
+			t.Fatalf("%s: FindUser(%s) error: %v", tt.name, tt.username, err)
+		}
+		if !reflect.DeepEqual(user.Groups, tt.want) {
+			t.Errorf("%s: FindUser(%s) groups = %v, want %v", tt.name, tt.username, user.Groups, tt.want)
+		}
+		if user, err = p.ValidateUser(t.Context(), "casuser", "Mellon"); err != nil {
+			t.Fatalf("%s: ValidateUser error: %v", tt.name, err)
+		} else if tt.username == "casuser" && !reflect.DeepEqual(user.Groups, tt.want) {
+			t.Errorf("%s: ValidateUser groups = %v, want %v", tt.name, user.Groups, tt.want)
+		}
+	}
+}
+
+func TestLdapGroupAuthorization(t *testing.T) {
+	srv := setupTestServer(t)
+	stub := newLdapStubServer(t, nil)
+	UP = newTestLdapUserProvider(t, stub.url, LdapOptions{Groups: LdapGroupOptions{MemberOfAttribute: "memberOf", NameAttribute: "cn", Nested: true}})
+	var err error
+	if SR, err = ParseServiceRegistry([]byte(`
+services:
+  - name: admin
+    pattern: https://admin\.example\.com/.*
+    allowedGroups: [admins]
+  - name: mail
+    pattern: https://mail\.example\.com/.*
+    releasedAttributes: [mail]
+`)); err != nil {
+		t.Fatalf("ParseServiceRegistry error: %v", err)
+	}
+	oldCFG := CFG
+	t.Cleanup(func() { CFG = oldCFG })
+	CFG.GroupsAttribute = "groups"
+	CFG.LoginAllowedGroups = "employees, contractors"
+
+	loginStatus := func(client *http.Client, service, username, password string) (int, *url.URL) {
+		t.Helper()
+		loginUrl := srv.URL + "/cas/login?" + url.Values{"service": {service}}.Encode()
+		resp, err := client.Do(newLoginRequest(t, client, loginUrl, username, password))
+		if err != nil {
+			t.Fatalf("login error: %v", err)
+		}
+		resp.Body.Close()
+		location, _ := url.Parse(resp.Header.Get("Location"))
+		return resp.StatusCode, location
+	}
+
+	// login is restricted to groups, and service access is restricted by allowedGroups
+	CFG.LoginAllowedGroups = "contractors"
+	if status, _ := loginStatus(newTestBrowser(), "https://mail.example.com/", "casuser", "Mellon"); status != http.StatusForbidden {
+		t.Fatalf("login of user not in login-allowed-groups got %d, want 403", status)
+	}
+	CFG.LoginAllowedGroups = "employees, contractors"
+	if status, _ := loginStatus(newTestBrowser(), "https://admin.example.com/", "alice", "Wonderland"); status != http.StatusForbidden {
+		t.Fatalf("login of alice to admin service got %d, want 403", status)
+	}
+	browser := newTestBrowser()
+	status, location := loginStatus(browser, "https://admin.example.com/", "casuser", "Mellon")
+	if status != http.StatusFound {
+		t.Fatalf("login of casuser to admin service got %d, want 302", status)
+	}
+
+	// groups are released as multi-valued attribute only if the service releases it
+	resp := getServiceResponse(t, srv.URL+"/cas/p3/serviceValidate?"+url.Values{
+		"ticket": {location.Query().Get("ticket")}, "service": {"https://admin.example.com/"}, "format": {"JSON"},
+	}.Encode())
+	if s := resp.ServiceResponse.AuthenticationSuccess; s == nil || !reflect.DeepEqual(s.Attributes["groups"], []any{"admins", "employees", "staff"}) {
+		t.Fatalf("serviceValidate(admin) got %+v, want groups attribute", resp.ServiceResponse)
+	}
+	status, location = loginStatus(newTestBrowser(), "https://mail.example.com/", "alice", "Wonderland")
+	if status != http.StatusFound {
+		t.Fatalf("login of alice to mail service got %d, want 302", status)
+	}
+	resp = getServiceResponse(t, srv.URL+"/cas/p3/serviceValidate?"+url.Values{
+		"ticket": {location.Query().Get("ticket")}, "service": {"https://mail.example.com/"}, "format": {"JSON"},
+	}.Encode())
+	if s := resp.ServiceResponse.AuthenticationSuccess; s == nil || s.Attributes["groups"] != nil || s.Attributes["mail"] != "alice@example.org" {
+		t.Fatalf("serviceValidate(mail) got %+v, want only mail attribute", resp.ServiceResponse)
+	}
+
+	// single sign-on and tickets minted by admin are also restricted to login-allowed-groups
+	CFG.LoginAllowedGroups = "contractors"
+	ssoResp, err := browser.Get(srv.URL + "/cas/login?" + url.Values{"service": {"https://mail.example.com/"}}.Encode())
+	if err != nil {
+		t.Fatalf("login error: %v", err)
+	}
+	ssoResp.Body.Close()
+	if ssoResp.StatusCode != http.StatusForbidden {
+		t.Fatalf("single sign-on of user not in login-allowed-groups got %d, want 403", ssoResp.StatusCode)
+	}
+	CFG.AdminPassword = "secret"
+	if code := adminRequest(t, http.MethodPost, srv.URL+"/admin/api/tickets", url.Values{"username": {"casuser"}}, nil); code != http.StatusForbidden {
+		t.Fatalf("mint ticket for user not in login-allowed-groups got %d, want 403", code)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	srv := setupTestServer(t)
+	stub := newLdapStubServer(t, nil)
+	UP = newTestLdapUserProvider(t, stub.url, LdapOptions{Timeout: time.Second})
+
+	check := func(wantCode int, wantStatus string) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + "/healthz")
+		if err != nil {
+			t.Fatalf("healthz request error: %v", err)
+		}
+		defer resp.Body.Close()
+		var result HealthResponse
+		if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("decode healthz response error: %v", err)
+		}
+		if resp.StatusCode != wantCode || result.Checks["ldap"].Status != wantStatus {
+			t.Fatalf("healthz got %d %+v, want %d with ldap %s", resp.StatusCode, result, wantCode, wantStatus)
+		}
+	}
+	check(http.StatusOK, HealthStatusUp)
+	stub.ln.Close()
+	UP.(*LdapUserProvider).Close() // drop idle connections, so the next check dials again
+	check(http.StatusServiceUnavailable, HealthStatusDown)
+}
@@ -1,19 +1,26 @@
 package main
 
-import "context"
+import (
+	"context"
+	"maps"
+)
 
 type staticUser struct {
-	username string
-	password string
-	mail     string
-	mobile   string
+	username   string
+	password   string
+	attributes map[string][]string
 }
 
 var staticUsers = []staticUser{{
 	username: "casuser",
 	password: "Mellon",
-	mail:     "casuser@example.org",
-	mobile:   "12345678910",
+	attributes: map[string][]string{
+		"mail":        {"casuser@example.org"},
+		"mobile":      {"12345678910"},
+		"displayName": {"CAS User"},
+		"employeeId":  {"10001"},
+		"memberOf":    {"cn=users,ou=groups,dc=example,dc=com", "cn=developers,ou=groups,dc=example,dc=com"},
+	},
 }}
 
 type StaticUserProvider struct {
@@ -31,9 +38,8 @@ func NewStaticUserProvider() *StaticUserProvider {
 func (p *StaticUserProvider) FindUser(_ context.Context, username string) (*User, error) {
 	if u, exists := p.userMap[username]; exists {
 		return &User{
-			Username: u.username,
-			Mail:     u.mail,
-			Mobile:   u.mobile,
+			Username:   u.username,
+			Attributes: maps.Clone(u.attributes),
 		}, nil
 	}
 	return nil, UserNotFoundError
@@ -43,9 +49,8 @@ func (p *StaticUserProvider) ValidateUser(_ context.Context, username, password
 	if u, exists := p.userMap[username]; exists {
 		if u.password == password {
 			return &User{
-				Username: u.username,
-				Mail:     u.mail,
-				Mobile:   u.mobile,
+				Username:   u.username,
+				Attributes: maps.Clone(u.attributes),
 			}, nil
 		}
 	}
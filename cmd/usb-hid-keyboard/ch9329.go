@@ -0,0 +1,515 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CH9329 serial protocol
+// https://www.wch.cn/downloads/CH9329EVT_ZIP.html
+const (
+	CH9329_CMD_GET_INFO           byte = 0x01
+	CH9329_CMD_SEND_KB_GENERAL    byte = 0x02
+	CH9329_CMD_SEND_KB_MEDIA_DATA byte = 0x03
+	CH9329_CMD_SEND_MS_ABS_DATA   byte = 0x04
+	CH9329_CMD_SEND_MS_REL_DATA   byte = 0x05
+	CH9329_CMD_SEND_MY_HID_DATA   byte = 0x06
+	CH9329_CMD_GET_PARA_CFG       byte = 0x08
+	CH9329_CMD_SET_PARA_CFG       byte = 0x09
+	CH9329_CMD_GET_USB_STRING     byte = 0x0A
+	CH9329_CMD_SET_USB_STRING     byte = 0x0B
+	CH9329_CMD_SET_DEFAULT_CFG    byte = 0x0C
+	CH9329_CMD_RESET              byte = 0x0F
+)
+
+// Status codes in the response frames.
+const (
+	CH9329_STATUS_SUCCESS     byte = 0x00
+	CH9329_STATUS_TIMEOUT     byte = 0xE1
+	CH9329_STATUS_HEAD_ERR    byte = 0xE2
+	CH9329_STATUS_CMD_ERR     byte = 0xE3
+	CH9329_STATUS_SUM_ERR     byte = 0xE4
+	CH9329_STATUS_PARA_ERR    byte = 0xE5
+	CH9329_STATUS_OPERATE_ERR byte = 0xE6
+)
+
+var ch9329StatusText = map[byte]string{
+	CH9329_STATUS_TIMEOUT:     "serial receive timeout",
+	CH9329_STATUS_HEAD_ERR:    "invalid frame header",
+	CH9329_STATUS_CMD_ERR:     "invalid command",
+	CH9329_STATUS_SUM_ERR:     "checksum mismatch",
+	CH9329_STATUS_PARA_ERR:    "invalid parameter",
+	CH9329_STATUS_OPERATE_ERR: "operation failed",
+}
+
+var ErrCH9329Timeout = errors.New("ch9329 response timeout")
+var ErrCH9329NotConnected = errors.New("ch9329 is not connected to target usb host")
+
+// CH9329Error is the failure reported by chip in response frame.
+type CH9329Error struct {
+	Cmd    byte
+	Status byte
+}
+
+func (e *CH9329Error) Error() string {
+	if text, ok := ch9329StatusText[e.Status]; ok {
+		return fmt.Sprintf("ch9329 command 0x%02X failed: %s (0x%02X)", e.Cmd, text, e.Status)
+	}
+	return fmt.Sprintf("ch9329 command 0x%02X failed: unknown status 0x%02X", e.Cmd, e.Status)
+}
+
+// ch9329Frame wraps the data into a CH9329 command frame: HEAD(57 AB) ADDR(00) CMD LEN DATA... SUM
+func ch9329Frame(cmd byte, data ...byte) []byte {
+	frame := make([]byte, 0, len(data)+6)
+	frame = append(frame, 0x57, 0xab, 0x00, cmd, byte(len(data)))
+	frame = append(frame, data...)
+	var sum byte
+	for _, b := range frame {
+		sum += b
+	}
+	return append(frame, sum)
+}
+
+// CH9329Response is the frame sent back by chip. The CMD is the request command with 0x80 on success, or with 0xC0 on failure.
+//
+//	success: 57 AB 00 82 01 00 85
+//	failure: 57 AB 00 C2 01 E4 A9
+type CH9329Response struct {
+	Addr byte
+	Cmd  byte
+	Data []byte
+}
+
+func (r *CH9329Response) RequestCmd() byte {
+	return r.Cmd &^ 0xC0
+}
+
+// Err reports the failure status in the response, including the success response of send commands with non-zero status.
+func (r *CH9329Response) Err() error {
+	if r.Cmd&0xC0 == 0xC0 {
+		var status byte
+		if len(r.Data) > 0 {
+			status = r.Data[0]
+		}
+		return &CH9329Error{Cmd: r.RequestCmd(), Status: status}
+	}
+	switch r.RequestCmd() {
+	case CH9329_CMD_SEND_KB_GENERAL, CH9329_CMD_SEND_KB_MEDIA_DATA, CH9329_CMD_SEND_MS_ABS_DATA, CH9329_CMD_SEND_MS_REL_DATA,
+		CH9329_CMD_SEND_MY_HID_DATA, CH9329_CMD_SET_PARA_CFG, CH9329_CMD_SET_USB_STRING, CH9329_CMD_SET_DEFAULT_CFG, CH9329_CMD_RESET:
+		if len(r.Data) > 0 && r.Data[0] != CH9329_STATUS_SUCCESS {
+			return &CH9329Error{Cmd: r.RequestCmd(), Status: r.Data[0]}
+		}
+	}
+	return nil
+}
+
+// ParseCH9329Response finds the first response frame in buf, and returns the number of bytes consumed.
+// If buf contains an incomplete frame, it returns nil response and the number of garbage bytes before the frame header.
+func ParseCH9329Response(buf []byte) (resp *CH9329Response, n int, err error) {
+	for n < len(buf) && buf[n] != 0x57 {
+		n++
+	}
+	if len(buf)-n < 2 {
+		return nil, n, nil
+	} else if buf[n+1] != 0xab {
+		return nil, n + 1, fmt.Errorf("invalid frame header: % X", buf[n:n+2])
+	}
+	if len(buf)-n < 5 || len(buf)-n < 6+int(buf[n+4]) {
+		return nil, n, nil
+	}
+
+	frame := buf[n : n+6+int(buf[n+4])]
+	var sum byte
+	for _, b := range frame[:len(frame)-1] {
+		sum += b
+	}
+	if sum != frame[len(frame)-1] {
+		return nil, n + len(frame), fmt.Errorf("invalid checksum: % X", frame)
+	}
+	return &CH9329Response{
+		Addr: frame[2],
+		Cmd:  frame[3],
+		Data: append([]byte(nil), frame[5:len(frame)-1]...),
+	}, n + len(frame), nil
+}
+
+// ReadCH9329Responses reads from r until error, and calls handle for each parsed response frame or invalid frame error.
+func ReadCH9329Responses(r io.Reader, handle func(*CH9329Response, error)) error {
+	buf := make([]byte, 0, 1024)
+	tmp := make([]byte, 256)
+	for {
+		n, err := r.Read(tmp)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, tmp[:n]...)
+		for {
+			resp, n, err := ParseCH9329Response(buf)
+			buf = buf[n:]
+			if err == nil && resp == nil {
+				break
+			}
+			handle(resp, err)
+		}
+	}
+}
+
+// CH9329Client sends request commands and waits for the responses synchronously.
+type CH9329Client struct {
+	w         io.Writer
+	responses chan *CH9329Response
+	timeout   time.Duration
+}
+
+func NewCH9329Client(rw io.ReadWriter) *CH9329Client {
+	c := &CH9329Client{
+		w:         rw,
+		responses: make(chan *CH9329Response, 16),
+		timeout:   time.Second,
+	}
+	go ReadCH9329Responses(rw, func(resp *CH9329Response, err error) {
+		if err == nil {
+			c.responses <- resp
+		}
+	})
+	return c
+}
+
+func (c *CH9329Client) Request(cmd byte, data ...byte) ([]byte, error) {
+	frame := ch9329Frame(cmd, data...)
+	if n, err := c.w.Write(frame); err != nil {
+		return nil, err
+	} else if n != len(frame) {
+		return nil, fmt.Errorf("incomplete write: % X", frame[:n])
+	}
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case resp := <-c.responses:
+			if resp.RequestCmd() != cmd {
+				continue // response of previous requests
+			}
+			return resp.Data, resp.Err()
+		case <-timer.C:
+			return nil, ErrCH9329Timeout
+		}
+	}
+}
+
+// CH9329Info is the data of GET_INFO response: VERSION USB_STATUS LED_STATUS RESERVED[5]
+type CH9329Info struct {
+	Version      byte
+	USBConnected bool
+	NumLock      bool
+	CapsLock     bool
+	ScrollLock   bool
+}
+
+// VersionString formats chip version, where 0x30 means V1.0 and 0x31 means V1.1 according to CMD_GET_INFO of
+// the CH9329 serial communication protocol. Unexpected version below 0x20 is shown as raw byte.
+func (info CH9329Info) VersionString() string {
+	if info.Version < 0x20 {
+		return fmt.Sprintf("0x%02X", info.Version)
+	}
+	return fmt.Sprintf("V%d.%d", info.Version>>4-2, info.Version&0x0f)
+}
+
+func (info CH9329Info) String() string {
+	return fmt.Sprintf("version: %s\nusb connected: %t\nnum lock: %t\ncaps lock: %t\nscroll lock: %t",
+		info.VersionString(), info.USBConnected, info.NumLock, info.CapsLock, info.ScrollLock)
+}
+
+func (c *CH9329Client) GetInfo() (*CH9329Info, error) {
+	data, err := c.Request(CH9329_CMD_GET_INFO)
+	if err != nil {
+		return nil, err
+	}
+	return parseCH9329Info(data)
+}
+
+func parseCH9329Info(data []byte) (*CH9329Info, error) {
+	if len(data) < 3 {
+		return nil, fmt.Errorf("invalid info data: % X", data)
+	}
+	return &CH9329Info{
+		Version:      data[0],
+		USBConnected: data[1] == 0x01,
+		NumLock:      data[2]&0x01 != 0,
+		CapsLock:     data[2]&0x02 != 0,
+		ScrollLock:   data[2]&0x04 != 0,
+	}, nil
+}
+
+// CH9329Config is the 50 bytes parameter configuration stored in chip.
+// Multi-byte values are big endian, except the USB VID and PID which are little endian.
+// Changes will take effect after chip reset.
+type CH9329Config struct {
+	raw [50]byte
+
+	WorkMode       byte   // 0x00: keyboard and mouse, 0x01: keyboard only, 0x02: mouse only, 0x03: custom HID; 0x80~0x83: same but selected by MODE pins
+	SerialMode     byte   // 0x00: protocol transmission, 0x01: ASCII, 0x02: transparent; 0x80~0x82: same but selected by CFG pins
+	Address        byte   // serial address, 0x00~0xFE, 0xFF for broadcast
+	BaudRate       uint32 // serial baud rate
+	PacketInterval uint16 // serial packet interval in milliseconds
+	VID            uint16 // USB vendor ID
+	PID            uint16 // USB product ID
+	UploadInterval uint16 // keyboard upload interval in milliseconds for ASCII mode
+	ReleaseDelay   uint16 // keyboard release delay in milliseconds for ASCII mode
+	AutoEnter      byte   // send enter automatically at the end of packet in ASCII mode
+	USBStringFlags byte   // BIT7: enable custom string descriptors, BIT2: manufacturer, BIT1: product, BIT0: serial number
+	FastUpload     byte   // keyboard fast upload mode
+}
+
+func parseCH9329Config(data []byte) (*CH9329Config, error) {
+	if len(data) != 50 {
+		return nil, fmt.Errorf("invalid config length %d, want 50", len(data))
+	}
+	cfg := &CH9329Config{
+		WorkMode:       data[0],
+		SerialMode:     data[1],
+		Address:        data[2],
+		BaudRate:       binary.BigEndian.Uint32(data[3:7]),
+		PacketInterval: binary.BigEndian.Uint16(data[9:11]),
+		VID:            binary.LittleEndian.Uint16(data[11:13]),
+		PID:            binary.LittleEndian.Uint16(data[13:15]),
+		UploadInterval: binary.BigEndian.Uint16(data[15:17]),
+		ReleaseDelay:   binary.BigEndian.Uint16(data[17:19]),
+		AutoEnter:      data[19],
+		USBStringFlags: data[36],
+		FastUpload:     data[37],
+	}
+	copy(cfg.raw[:], data)
+	return cfg, nil
+}
+
+func (cfg *CH9329Config) Bytes() []byte {
+	data := cfg.raw
+	data[0] = cfg.WorkMode
+	data[1] = cfg.SerialMode
+	data[2] = cfg.Address
+	binary.BigEndian.PutUint32(data[3:7], cfg.BaudRate)
+	binary.BigEndian.PutUint16(data[9:11], cfg.PacketInterval)
+	binary.LittleEndian.PutUint16(data[11:13], cfg.VID)
+	binary.LittleEndian.PutUint16(data[13:15], cfg.PID)
+	binary.BigEndian.PutUint16(data[15:17], cfg.UploadInterval)
+	binary.BigEndian.PutUint16(data[17:19], cfg.ReleaseDelay)
+	data[19] = cfg.AutoEnter
+	data[36] = cfg.USBStringFlags
+	data[37] = cfg.FastUpload
+	return data[:]
+}
+
+// ch9329ConfigFields lists the names used to read and write the fields of CH9329Config in command line.
+var ch9329ConfigFields = []struct {
+	name string
+	ptr  func(cfg *CH9329Config) any
+}{
+	{"work-mode", func(cfg *CH9329Config) any { return &cfg.WorkMode }},
+	{"serial-mode", func(cfg *CH9329Config) any { return &cfg.SerialMode }},
+	{"addr", func(cfg *CH9329Config) any { return &cfg.Address }},
+	{"baud", func(cfg *CH9329Config) any { return &cfg.BaudRate }},
+	{"packet-interval", func(cfg *CH9329Config) any { return &cfg.PacketInterval }},
+	{"vid", func(cfg *CH9329Config) any { return &cfg.VID }},
+	{"pid", func(cfg *CH9329Config) any { return &cfg.PID }},
+	{"upload-interval", func(cfg *CH9329Config) any { return &cfg.UploadInterval }},
+	{"release-delay", func(cfg *CH9329Config) any { return &cfg.ReleaseDelay }},
+	{"auto-enter", func(cfg *CH9329Config) any { return &cfg.AutoEnter }},
+	{"usb-string-flags", func(cfg *CH9329Config) any { return &cfg.USBStringFlags }},
+	{"fast-upload", func(cfg *CH9329Config) any { return &cfg.FastUpload }},
+}
+
+func (cfg *CH9329Config) String() string {
+	lines := make([]string, len(ch9329ConfigFields))
+	for i, field := range ch9329ConfigFields {
+		switch v := field.ptr(cfg).(type) {
+		case *byte:
+			lines[i] = fmt.Sprintf("%s: 0x%02X", field.name, *v)
+		case *uint16:
+			if field.name == "vid" || field.name == "pid" {
+				lines[i] = fmt.Sprintf("%s: 0x%04X", field.name, *v)
+			} else {
+				lines[i] = fmt.Sprintf("%s: %d", field.name, *v)
+			}
+		case *uint32:
+			lines[i] = fmt.Sprintf("%s: %d", field.name, *v)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Set updates the field by name, the value can be decimal or hexadecimal with 0x prefix.
+func (cfg *CH9329Config) Set(name, value string) error {
+	for _, field := range ch9329ConfigFields {
+		if field.name != name {
+			continue
+		}
+		switch v := field.ptr(cfg).(type) {
+		case *byte:
+			n, err := strconv.ParseUint(value, 0, 8)
+			if err != nil {
+				return fmt.Errorf("invalid value %q for %s: %w", value, name, err)
+			}
+			*v = byte(n)
+		case *uint16:
+			n, err := strconv.ParseUint(value, 0, 16)
+			if err != nil {
+				return fmt.Errorf("invalid value %q for %s: %w", value, name, err)
+			}
+			*v = uint16(n)
+		case *uint32:
+			n, err := strconv.ParseUint(value, 0, 32)
+			if err != nil {
+				return fmt.Errorf("invalid value %q for %s: %w", value, name, err)
+			}
+			*v = uint32(n)
+		}
+		return nil
+	}
+	names := make([]string, len(ch9329ConfigFields))
+	for i, field := range ch9329ConfigFields {
+		names[i] = field.name
+	}
+	return fmt.Errorf("unknown config field %q, must be one of %s", name, strings.Join(names, ", "))
+}
+
+func (c *CH9329Client) GetConfig() (*CH9329Config, error) {
+	data, err := c.Request(CH9329_CMD_GET_PARA_CFG)
+	if err != nil {
+		return nil, err
+	}
+	return parseCH9329Config(data)
+}
+
+func (c *CH9329Client) SetConfig(cfg *CH9329Config) error {
+	_, err := c.Request(CH9329_CMD_SET_PARA_CFG, cfg.Bytes()...)
+	return err
+}
+
+// USB string descriptor types
+var ch9329USBStringTypes = []struct {
+	name string
+	typ  byte
+	flag byte
+}{
+	{"manufacturer", 0x00, 0x04},
+	{"product", 0x01, 0x02},
+	{"serial", 0x02, 0x01},
+}
+
+// GetUSBString reads the custom USB string descriptor: TYPE LEN STRING...
+func (c *CH9329Client) GetUSBString(typ byte) (string, error) {
+	data, err := c.Request(CH9329_CMD_GET_USB_STRING, typ)
+	if err != nil {
+		return "", err
+	} else if len(data) < 2 || len(data) < 2+int(data[1]) {
+		return "", fmt.Errorf("invalid usb string data: % X", data)
+	}
+	return string(data[2 : 2+int(data[1])]), nil
+}
+
+// SetUSBString writes the custom USB string descriptor, at most 23 bytes.
+func (c *CH9329Client) SetUSBString(typ byte, value string) error {
+	if len(value) > 23 {
+		return fmt.Errorf("usb string %q is too long, at most 23 bytes", value)
+	}
+	_, err := c.Request(CH9329_CMD_SET_USB_STRING, append([]byte{typ, byte(len(value))}, value...)...)
+	return err
+}
+
+// RunCH9329Command runs the chip management command with arguments:
+//
+//	info                         query version, usb enumeration state and keyboard LEDs
+//	get-config                   read parameter configuration
+//	set-config name=value...     update parameter configuration
+//	get-string                   read custom usb string descriptors
+//	set-string name=value...     update custom usb string descriptors, name is manufacturer, product or serial
+//	default-config               restore factory configuration
+//	reset                        software reset the chip to apply configuration
+func RunCH9329Command(c *CH9329Client, cmd string, args []string) (string, error) {
+	switch cmd {
+	case "info":
+		info, err := c.GetInfo()
+		if err != nil {
+			return "", err
+		}
+		return info.String(), nil
+	case "get-config":
+		cfg, err := c.GetConfig()
+		if err != nil {
+			return "", err
+		}
+		return cfg.String(), nil
+	case "set-config":
+		cfg, err := c.GetConfig()
+		if err != nil {
+			return "", err
+		}
+		for _, arg := range args {
+			name, value, _ := strings.Cut(arg, "=")
+			if err := cfg.Set(name, value); err != nil {
+				return "", err
+			}
+		}
+		if err := c.SetConfig(cfg); err != nil {
+			return "", err
+		}
+		return cfg.String() + "\n(reset the chip to apply configuration)", nil
+	case "get-string":
+		lines := make([]string, len(ch9329USBStringTypes))
+		for i, st := range ch9329USBStringTypes {
+			value, err := c.GetUSBString(st.typ)
+			if err != nil {
+				return "", err
+			}
+			lines[i] = fmt.Sprintf("%s: %q", st.name, value)
+		}
+		return strings.Join(lines, "\n"), nil
+	case "set-string":
+		var flags byte
+		for _, arg := range args {
+			name, value, _ := strings.Cut(arg, "=")
+			idx := -1
+			for i, st := range ch9329USBStringTypes {
+				if st.name == name {
+					idx = i
+				}
+			}
+			if idx < 0 {
+				return "", fmt.Errorf("unknown usb string %q, must be manufacturer, product or serial", name)
+			}
+			if err := c.SetUSBString(ch9329USBStringTypes[idx].typ, value); err != nil {
+				return "", err
+			}
+			flags |= ch9329USBStringTypes[idx].flag
+		}
+		// enable the custom string descriptors in configuration
+		cfg, err := c.GetConfig()
+		if err != nil {
+			return "", err
+		}
+		cfg.USBStringFlags |= 0x80 | flags
+		if err := c.SetConfig(cfg); err != nil {
+			return "", err
+		}
+		return "usb strings updated\n(reset the chip to apply configuration)", nil
+	case "default-config":
+		if _, err := c.Request(CH9329_CMD_SET_DEFAULT_CFG); err != nil {
+			return "", err
+		}
+		return "factory configuration restored\n(reset the chip to apply configuration)", nil
+	case "reset":
+		if _, err := c.Request(CH9329_CMD_RESET); err != nil {
+			return "", err
+		}
+		return "chip reset", nil
+	default:
+		return "", fmt.Errorf("unknown ch9329 command %q", cmd)
+	}
+}
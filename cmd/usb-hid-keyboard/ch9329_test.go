@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestParseCH9329Response(t *testing.T) {
+	var tests = []struct {
+		input   []byte
+		wantCmd byte
+		wantN   int
+		wantErr bool
+	}{
+		{[]byte{0x57, 0xAB, 0x00, 0x82, 0x01, 0x00, 0x85}, 0x82, 7, false},
+		{[]byte{0xFF, 0x57, 0xAB, 0x00, 0xC2, 0x01, 0xE4, 0xA9, 0x57}, 0xC2, 8, false},
+		{[]byte{0x57, 0xAB, 0x00, 0x82, 0x01}, 0, 0, false},
+		{[]byte{0x00, 0x00, 0x57}, 0, 2, false},
+		{[]byte{0x57, 0xAB, 0x00, 0x82, 0x01, 0x00, 0x86}, 0, 7, true},
+		{[]byte{0x57, 0x00}, 0, 1, true},
+	}
+	for _, test := range tests {
+		resp, n, err := ParseCH9329Response(test.input)
+		if (err != nil) != test.wantErr || n != test.wantN || (resp != nil && resp.Cmd != test.wantCmd) || (resp == nil && test.wantCmd != 0) {
+			t.Errorf("ParseCH9329Response(% X) = %v, %d, %v; want cmd 0x%02X, %d, err %v", test.input, resp, n, err, test.wantCmd, test.wantN, test.wantErr)
+		}
+	}
+}
+
+func TestCH9329ResponseErr(t *testing.T) {
+	var chErr *CH9329Error
+	resp := &CH9329Response{Cmd: 0xC2, Data: []byte{CH9329_STATUS_SUM_ERR}}
+	if err := resp.Err(); !errors.As(err, &chErr) || chErr.Cmd != CH9329_CMD_SEND_KB_GENERAL || chErr.Status != CH9329_STATUS_SUM_ERR {
+		t.Errorf("Err() = %v; want checksum error of command 0x02", err)
+	}
+	resp = &CH9329Response{Cmd: 0x82, Data: []byte{CH9329_STATUS_PARA_ERR}}
+	if err := resp.Err(); !errors.As(err, &chErr) || chErr.Status != CH9329_STATUS_PARA_ERR {
+		t.Errorf("Err() = %v; want parameter error", err)
+	}
+	resp = &CH9329Response{Cmd: 0x81, Data: []byte{0x30, 0x01, 0x02, 0, 0, 0, 0, 0}}
+	if err := resp.Err(); err != nil {
+		t.Errorf("Err() = %v; want nil", err)
+	}
+}
+
+func TestCH9329Config(t *testing.T) {
+	raw := make([]byte, 50)
+	copy(raw, []byte{0x80, 0x80, 0x00, 0x00, 0x00, 0x25, 0x80, 0x08, 0x00, 0x00, 0x03, 0x86, 0x1A, 0x29, 0xE1})
+	cfg, err := parseCH9329Config(raw)
+	if err != nil {
+		t.Fatalf("parseCH9329Config() error: %v", err)
+	}
+	if cfg.BaudRate != 9600 || cfg.VID != 0x1A86 || cfg.PID != 0xE129 || cfg.PacketInterval != 3 {
+		t.Errorf("parseCH9329Config() = %+v; want baud 9600, vid 0x1A86, pid 0xE129, interval 3", cfg)
+	}
+	if !bytes.Equal(cfg.Bytes(), raw) {
+		t.Errorf("Bytes() = % X; want % X", cfg.Bytes(), raw)
+	}
+
+	if err := cfg.Set("baud", "115200"); err != nil || cfg.BaudRate != 115200 {
+		t.Errorf("Set(baud) = %v, %d; want 115200", err, cfg.BaudRate)
+	}
+	if err := cfg.Set("vid", "0x1234"); err != nil || cfg.VID != 0x1234 {
+		t.Errorf("Set(vid) = %v, 0x%04X; want 0x1234", err, cfg.VID)
+	}
+	if err := cfg.Set("addr", "256"); err == nil {
+		t.Errorf("Set(addr, 256) = nil; want error")
+	}
+	if err := cfg.Set("unknown", "1"); err == nil {
+		t.Errorf("Set(unknown) = nil; want error")
+	}
+}
+
+func TestCH9329ClientGetInfo(t *testing.T) {
+	host, chip := net.Pipe()
+	defer host.Close()
+	defer chip.Close()
+	go func() {
+		buf := make([]byte, 6)
+		if _, err := chip.Read(buf); err != nil || !bytes.Equal(buf, ch9329Frame(CH9329_CMD_GET_INFO)) {
+			return
+		}
+		chip.Write(ch9329Frame(0x81, 0x30, 0x01, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00))
+	}()
+
+	info, err := NewCH9329Client(host).GetInfo()
+	if err != nil {
+		t.Fatalf("GetInfo() error: %v", err)
+	}
+	if !info.USBConnected || info.NumLock || !info.CapsLock {
+		t.Errorf("GetInfo() = %+v; want usb connected with caps lock", info)
+	}
+	for version, want := range map[byte]string{0x30: "V1.0", 0x31: "V1.1", 0x20: "V0.0", 0x12: "0x12", 0x00: "0x00"} {
+		if got := (CH9329Info{Version: version}).VersionString(); got != want {
+			t.Errorf("VersionString(0x%02X) = %s; want %s", version, got, want)
+		}
+	}
+}
+
+func TestEncodeMediaForCH9329(t *testing.T) {
+	var tests = []struct {
+		key     Key
+		pressed bool
+		want    []byte
+	}{
+		{K_POWER, true, []byte{0x57, 0xAB, 0x00, 0x03, 0x02, 0x01, 0x01, 0x09}},
+		{K_WAKE, false, []byte{0x57, 0xAB, 0x00, 0x03, 0x02, 0x01, 0x00, 0x08}},
+		{K_MUTE, true, []byte{0x57, 0xAB, 0x00, 0x03, 0x04, 0x02, 0x04, 0x00, 0x00, 0x0F}},
+		{K_A, true, nil},
+	}
+	for _, test := range tests {
+		if got := EncodeMediaForCH9329(test.key, test.pressed); !bytes.Equal(got, test.want) {
+			t.Errorf("EncodeMediaForCH9329(%s, %v) = % X; want % X", test.key, test.pressed, got, test.want)
+		}
+	}
+}
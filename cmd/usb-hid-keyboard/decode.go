@@ -1,224 +1,417 @@
 package main
 
-var comboKeycodesStart KeyCode = KeyCode{K_L_CTRL, K_K}
-var comboKeycodesExit KeyCode = KeyCode{K_L_CTRL, K_Q}
-
-// Some key combinations are captured by the operating system and cannot be sent to the raw mode terminal.
-// Therefore, we defined some combo keycodes to trigger the corresponding key combinations.
-func checkComboMode(ori KeyCode, comboMode bool) (res KeyCode, isCombo bool, isExit bool) {
-	if comboMode {
-		res = map[KeyCode]KeyCode{
-			{K_Q}:           {K_L_CTRL, K_Q},               // special: exit
-			{K_K}:           {K_L_CTRL, K_K},               // ctrl-k
-			{K_T}:           {K_L_CTRL, K_L_ALT, K_T},      // ctrl-alt-t
-			{K_F1}:          {K_L_CTRL, K_L_ALT, K_F1},     // ctrl-alt-f1
-			{K_F2}:          {K_L_CTRL, K_L_ALT, K_F2},     // ctrl-alt-f2
-			{K_F3}:          {K_L_CTRL, K_L_ALT, K_F3},     // ctrl-alt-f3
-			{K_F4}:          {K_L_CTRL, K_L_ALT, K_F4},     // ctrl-alt-f4
-			{K_F5}:          {K_L_CTRL, K_L_ALT, K_F5},     // ctrl-alt-f5
-			{K_F6}:          {K_L_CTRL, K_L_ALT, K_F6},     // ctrl-alt-f6
-			{K_F7}:          {K_L_CTRL, K_L_ALT, K_F7},     // ctrl-alt-f7
-			{K_F8}:          {K_L_CTRL, K_L_ALT, K_F8},     // ctrl-alt-f8
-			{K_F9}:          {K_L_CTRL, K_L_ALT, K_F9},     // ctrl-alt-f9
-			{K_F10}:         {K_L_CTRL, K_L_ALT, K_F10},    // ctrl-alt-f10
-			{K_F11}:         {K_L_CTRL, K_L_ALT, K_F11},    // ctrl-alt-f11
-			{K_F12}:         {K_L_CTRL, K_L_ALT, K_F12},    // ctrl-alt-f12
-			{K_DELETE}:      {K_L_CTRL, K_L_ALT, K_DELETE}, // ctrl-alt-delete
-			{K_L_CTRL, K_K}: {K_L_CTRL, K_K},               // ctrl-k
-		}[ori]
-		return res, false, res == comboKeycodesExit
-	} else {
-		return ori, ori == comboKeycodesStart, false
+import (
+	"bytes"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/sys/unix"
+)
+
+// The keycodes of single byte input from the raw mode terminal, which are also the ASCII characters on the US keyboard layout.
+var asciiKeyCodes = map[byte]KeyCode{
+	0x00: {K_L_CTRL, K_SPACE},
+	0x01: {K_L_CTRL, K_A},
+	0x02: {K_L_CTRL, K_B},
+	0x03: {K_L_CTRL, K_C},
+	0x04: {K_L_CTRL, K_D},
+	0x05: {K_L_CTRL, K_E},
+	0x06: {K_L_CTRL, K_F},
+	0x07: {K_L_CTRL, K_G},
+	0x08: {K_L_CTRL, K_H},
+	0x09: {K_TAB}, // {K_L_CTRL, K_I}
+	0x0a: {K_L_CTRL, K_J},
+	0x0b: {K_L_CTRL, K_K},
+	0x0c: {K_L_CTRL, K_L},
+	0x0d: {K_ENTER}, // {K_L_CTRL, K_M}
+	0x0e: {K_L_CTRL, K_N},
+	0x0f: {K_L_CTRL, K_O},
+	0x10: {K_L_CTRL, K_P},
+	0x11: {K_L_CTRL, K_Q},
+	0x12: {K_L_CTRL, K_R},
+	0x13: {K_L_CTRL, K_S},
+	0x14: {K_L_CTRL, K_T},
+	0x15: {K_L_CTRL, K_U},
+	0x16: {K_L_CTRL, K_V},
+	0x17: {K_L_CTRL, K_W},
+	0x18: {K_L_CTRL, K_X},
+	0x19: {K_L_CTRL, K_Y},
+	0x1a: {K_L_CTRL, K_Z},
+	0x1b: {K_ESC}, // {K_L_CTRL, K_3}
+	0x1c: {K_L_CTRL, K_4},
+	0x1d: {K_L_CTRL, K_5},
+	0x1e: {K_L_CTRL, K_6},
+	0x1f: {K_L_CTRL, K_7},
+	0x20: {K_SPACE},
+	0x21: {K_L_SHIFT, K_1},          // !
+	0x22: {K_L_SHIFT, K_APOSTROPHE}, // "
+	0x23: {K_L_SHIFT, K_3},          // #
+	0x24: {K_L_SHIFT, K_4},          // $
+	0x25: {K_L_SHIFT, K_5},          // %
+	0x26: {K_L_SHIFT, K_7},          // &
+	0x27: {K_APOSTROPHE},            // '
+	0x28: {K_L_SHIFT, K_9},          // (
+	0x29: {K_L_SHIFT, K_0},          // )
+	0x2a: {K_L_SHIFT, K_8},          // *
+	0x2b: {K_L_SHIFT, K_EQUAL},      // +
+	0x2c: {K_COMMA},                 // ,
+	0x2d: {K_MINUS},                 // -
+	0x2e: {K_DOT},                   // .
+	0x2f: {K_SLASH},                 // /
+	0x30: {K_0},
+	0x31: {K_1},
+	0x32: {K_2},
+	0x33: {K_3},
+	0x34: {K_4},
+	0x35: {K_5},
+	0x36: {K_6},
+	0x37: {K_7},
+	0x38: {K_8},
+	0x39: {K_9},
+	0x3a: {K_L_SHIFT, K_SEMICOLON}, // :
+	0x3b: {K_SEMICOLON},            // ;
+	0x3c: {K_L_SHIFT, K_COMMA},     // <
+	0x3d: {K_EQUAL},                // =
+	0x3e: {K_L_SHIFT, K_DOT},       // >
+	0x3f: {K_L_SHIFT, K_SLASH},     // ?
+	0x40: {K_L_SHIFT, K_2},         // @
+	0x41: {K_L_SHIFT, K_A},
+	0x42: {K_L_SHIFT, K_B},
+	0x43: {K_L_SHIFT, K_C},
+	0x44: {K_L_SHIFT, K_D},
+	0x45: {K_L_SHIFT, K_E},
+	0x46: {K_L_SHIFT, K_F},
+	0x47: {K_L_SHIFT, K_G},
+	0x48: {K_L_SHIFT, K_H},
+	0x49: {K_L_SHIFT, K_I},
+	0x4a: {K_L_SHIFT, K_J},
+	0x4b: {K_L_SHIFT, K_K},
+	0x4c: {K_L_SHIFT, K_L},
+	0x4d: {K_L_SHIFT, K_M},
+	0x4e: {K_L_SHIFT, K_N},
+	0x4f: {K_L_SHIFT, K_O},
+	0x50: {K_L_SHIFT, K_P},
+	0x51: {K_L_SHIFT, K_Q},
+	0x52: {K_L_SHIFT, K_R},
+	0x53: {K_L_SHIFT, K_S},
+	0x54: {K_L_SHIFT, K_T},
+	0x55: {K_L_SHIFT, K_U},
+	0x56: {K_L_SHIFT, K_V},
+	0x57: {K_L_SHIFT, K_W},
+	0x58: {K_L_SHIFT, K_X},
+	0x59: {K_L_SHIFT, K_Y},
+	0x5a: {K_L_SHIFT, K_Z},
+	0x5b: {K_LEFTBRACE},        // [
+	0x5c: {K_BACKSLASH},        // \
+	0x5d: {K_RIGHTBRACE},       // ]
+	0x5e: {K_L_SHIFT, K_6},     // ^
+	0x5f: {K_L_SHIFT, K_MINUS}, // _
+	0x60: {K_GRAVE},            // `
+	0x61: {K_A},
+	0x62: {K_B},
+	0x63: {K_C},
+	0x64: {K_D},
+	0x65: {K_E},
+	0x66: {K_F},
+	0x67: {K_G},
+	0x68: {K_H},
+	0x69: {K_I},
+	0x6a: {K_J},
+	0x6b: {K_K},
+	0x6c: {K_L},
+	0x6d: {K_M},
+	0x6e: {K_N},
+	0x6f: {K_O},
+	0x70: {K_P},
+	0x71: {K_Q},
+	0x72: {K_R},
+	0x73: {K_S},
+	0x74: {K_T},
+	0x75: {K_U},
+	0x76: {K_V},
+	0x77: {K_W},
+	0x78: {K_X},
+	0x79: {K_Y},
+	0x7a: {K_Z},
+	0x7b: {K_L_SHIFT, K_LEFTBRACE},  // {
+	0x7c: {K_L_SHIFT, K_BACKSLASH},  // |
+	0x7d: {K_L_SHIFT, K_RIGHTBRACE}, // }
+	0x7e: {K_L_SHIFT, K_GRAVE},      // ~
+	0x7f: {K_BACKSPACE},
+}
+
+// InputEvent is a single key or mouse event decoded from the raw mode terminal input.
+type InputEvent struct {
+	Code    KeyCode // decoded key combination, EmptyKeyCode for unknown sequence
+	Release bool    // key release, only reported with kitty keyboard protocol
+	Mouse   []byte  // SGR mouse tracking sequence, decoded by MouseTracker
+	Raw     []byte  // original bytes of the event
+}
+
+// VTParser splits the VT escape sequences from the raw mode terminal input, and converts them into the corresponding keycodes.
+// Incomplete CSI and SS3 sequences at the end of input are kept until the next Feed().
+// Supported sequences:
+//
+//	C0 controls and ASCII characters       0x00 ~ 0x7F
+//	Alt + key                              ESC x
+//	SS3 keys                               ESC O P, ESC O 1;5 P
+//	CSI cursor and function keys           ESC [ A, ESC [ 1;5 C, ESC [ 15 ~, ESC [ 3;2 ~, ESC [[ A, ESC [ Z
+//	kitty keyboard protocol                ESC [ 97;5:3 u
+//	SGR mouse tracking                     ESC [ < 0;10;20 M
+type VTParser struct {
+	pending []byte
+
+	KittyFlags int // flags reported by kitty keyboard protocol query, -1 if no response
+}
+
+func NewVTParser() *VTParser {
+	return &VTParser{KittyFlags: -1}
+}
+
+func (p *VTParser) Feed(data []byte) (events []InputEvent) {
+	buf := append(p.pending, data...)
+	p.pending = nil
+	for len(buf) > 0 {
+		n, ev, ok := p.parseOne(buf)
+		if n == 0 { // incomplete sequence
+			p.pending = append([]byte(nil), buf...)
+			break
+		}
+		if ok {
+			ev.Raw = buf[:n:n]
+			events = append(events, ev)
+		}
+		buf = buf[n:]
+	}
+	return events
+}
+
+func (p *VTParser) parseOne(buf []byte) (n int, ev InputEvent, ok bool) {
+	switch {
+	case buf[0] >= 0x80: // skip non-ASCII characters, which have no keycodes
+		_, size := utf8.DecodeRune(buf)
+		return size, ev, true
+	case buf[0] != 0x1b:
+		return 1, InputEvent{Code: asciiKeyCodes[buf[0]]}, true
+	case len(buf) == 1:
+		return 1, InputEvent{Code: KeyCode{K_ESC}}, true
+	case buf[1] == '[':
+		return p.parseCSI(buf)
+	case buf[1] == 'O':
+		return parseSS3(buf)
+	default: // Alt + key
+		code := asciiKeyCodes[buf[1]]
+		if buf[1] >= 0x80 || code == EmptyKeyCode {
+			return 1, InputEvent{Code: KeyCode{K_ESC}}, true
+		}
+		return 2, InputEvent{Code: withModifiers(code, modAlt)}, true
 	}
 }
 
-// The raw mode terminal will receive the VT100 escape sequence from the user input. Then we convert it into the corresponding keycode.
-func DecodeFromCli(buf []byte, comboMode bool) (res KeyCode, isCombo bool, isExit bool) {
-	if len(buf) == 1 {
-		res = map[byte]KeyCode{
-			0x00: {K_L_CTRL, K_SPACE},
-			0x01: {K_L_CTRL, K_A},
-			0x02: {K_L_CTRL, K_B},
-			0x03: {K_L_CTRL, K_C},
-			0x04: {K_L_CTRL, K_D},
-			0x05: {K_L_CTRL, K_E},
-			0x06: {K_L_CTRL, K_F},
-			0x07: {K_L_CTRL, K_G},
-			0x08: {K_L_CTRL, K_H},
-			0x09: {K_TAB}, // {K_L_CTRL, K_I}
-			0x0a: {K_L_CTRL, K_J},
-			0x0b: {K_L_CTRL, K_K},
-			0x0c: {K_L_CTRL, K_L},
-			0x0d: {K_ENTER}, // {K_L_CTRL, K_M}
-			0x0e: {K_L_CTRL, K_N},
-			0x0f: {K_L_CTRL, K_O},
-			0x10: {K_L_CTRL, K_P},
-			0x11: {K_L_CTRL, K_Q},
-			0x12: {K_L_CTRL, K_R},
-			0x13: {K_L_CTRL, K_S},
-			0x14: {K_L_CTRL, K_T},
-			0x15: {K_L_CTRL, K_U},
-			0x16: {K_L_CTRL, K_V},
-			0x17: {K_L_CTRL, K_W},
-			0x18: {K_L_CTRL, K_X},
-			0x19: {K_L_CTRL, K_Y},
-			0x1a: {K_L_CTRL, K_Z},
-			0x1b: {K_ESC}, // {K_L_CTRL, K_3}
-			0x1c: {K_L_CTRL, K_4},
-			0x1d: {K_L_CTRL, K_5},
-			0x1e: {K_L_CTRL, K_6},
-			0x1f: {K_L_CTRL, K_7},
-			0x20: {K_SPACE},
-			0x21: {K_L_SHIFT, K_1},          // !
-			0x22: {K_L_SHIFT, K_APOSTROPHE}, // "
-			0x23: {K_L_SHIFT, K_3},          // #
-			0x24: {K_L_SHIFT, K_4},          // $
-			0x25: {K_L_SHIFT, K_5},          // %
-			0x26: {K_L_SHIFT, K_7},          // &
-			0x27: {K_APOSTROPHE},            // '
-			0x28: {K_L_SHIFT, K_9},          // (
-			0x29: {K_L_SHIFT, K_0},          // )
-			0x2a: {K_L_SHIFT, K_8},          // *
-			0x2b: {K_L_SHIFT, K_EQUAL},      // +
-			0x2c: {K_COMMA},                 // ,
-			0x2d: {K_MINUS},                 // -
-			0x2e: {K_DOT},                   // .
-			0x2f: {K_SLASH},                 // /
-			0x30: {K_0},
-			0x31: {K_1},
-			0x32: {K_2},
-			0x33: {K_3},
-			0x34: {K_4},
-			0x35: {K_5},
-			0x36: {K_6},
-			0x37: {K_7},
-			0x38: {K_8},
-			0x39: {K_9},
-			0x3a: {K_L_SHIFT, K_SEMICOLON}, // :
-			0x3b: {K_SEMICOLON},            // ;
-			0x3c: {K_L_SHIFT, K_COMMA},     // <
-			0x3d: {K_EQUAL},                // =
-			0x3e: {K_L_SHIFT, K_DOT},       // >
-			0x3f: {K_L_SHIFT, K_SLASH},     // ?
-			0x40: {K_L_SHIFT, K_2},         // @
-			0x41: {K_L_SHIFT, K_A},
-			0x42: {K_L_SHIFT, K_B},
-			0x43: {K_L_SHIFT, K_C},
-			0x44: {K_L_SHIFT, K_D},
-			0x45: {K_L_SHIFT, K_E},
-			0x46: {K_L_SHIFT, K_F},
-			0x47: {K_L_SHIFT, K_G},
-			0x48: {K_L_SHIFT, K_H},
-			0x49: {K_L_SHIFT, K_I},
-			0x4a: {K_L_SHIFT, K_J},
-			0x4b: {K_L_SHIFT, K_K},
-			0x4c: {K_L_SHIFT, K_L},
-			0x4d: {K_L_SHIFT, K_M},
-			0x4e: {K_L_SHIFT, K_N},
-			0x4f: {K_L_SHIFT, K_O},
-			0x50: {K_L_SHIFT, K_P},
-			0x51: {K_L_SHIFT, K_Q},
-			0x52: {K_L_SHIFT, K_R},
-			0x53: {K_L_SHIFT, K_S},
-			0x54: {K_L_SHIFT, K_T},
-			0x55: {K_L_SHIFT, K_U},
-			0x56: {K_L_SHIFT, K_V},
-			0x57: {K_L_SHIFT, K_W},
-			0x58: {K_L_SHIFT, K_X},
-			0x59: {K_L_SHIFT, K_Y},
-			0x5a: {K_L_SHIFT, K_Z},
-			0x5b: {K_LEFTBRACE},        // [
-			0x5c: {K_BACKSLASH},        // \
-			0x5d: {K_RIGHTBRACE},       // ]
-			0x5e: {K_L_SHIFT, K_6},     // ^
-			0x5f: {K_L_SHIFT, K_MINUS}, // _
-			0x60: {K_GRAVE},            // `
-			0x61: {K_A},
-			0x62: {K_B},
-			0x63: {K_C},
-			0x64: {K_D},
-			0x65: {K_E},
-			0x66: {K_F},
-			0x67: {K_G},
-			0x68: {K_H},
-			0x69: {K_I},
-			0x6a: {K_J},
-			0x6b: {K_K},
-			0x6c: {K_L},
-			0x6d: {K_M},
-			0x6e: {K_N},
-			0x6f: {K_O},
-			0x70: {K_P},
-			0x71: {K_Q},
-			0x72: {K_R},
-			0x73: {K_S},
-			0x74: {K_T},
-			0x75: {K_U},
-			0x76: {K_V},
-			0x77: {K_W},
-			0x78: {K_X},
-			0x79: {K_Y},
-			0x7a: {K_Z},
-			0x7b: {K_L_SHIFT, K_LEFTBRACE},  // {
-			0x7c: {K_L_SHIFT, K_BACKSLASH},  // |
-			0x7d: {K_L_SHIFT, K_RIGHTBRACE}, // }
-			0x7e: {K_L_SHIFT, K_GRAVE},      // ~
-			0x7f: {K_BACKSPACE},
-		}[buf[0]]
-	} else if len(buf) == 3 {
-		if buf[0] == 0x1b && buf[1] == 0x5b {
-			res = map[byte]KeyCode{
-				0x41: {K_UP},
-				0x42: {K_DOWN},
-				0x43: {K_RIGHT},
-				0x44: {K_LEFT},
-				0x46: {K_END},
-				0x48: {K_HOME},
-			}[buf[2]]
-		} else if buf[0] == 0x1b && buf[1] == 0x4f {
-			res = map[byte]KeyCode{
-				0x50: {K_F1},
-				0x51: {K_F2},
-				0x52: {K_F3},
-				0x53: {K_F4},
-			}[buf[2]]
+const (
+	modShift = 1 << iota
+	modAlt
+	modCtrl
+	modSuper
+)
+
+// withModifiers prepends the modifier keys to the keycode, extra modifiers are dropped if the keycode is full.
+func withModifiers(code KeyCode, mods int) KeyCode {
+	var res KeyCode
+	i := 0
+	for _, m := range []struct {
+		bit int
+		key Key
+	}{{modCtrl, K_L_CTRL}, {modShift, K_L_SHIFT}, {modAlt, K_L_ALT}, {modSuper, K_L_GUI}} {
+		if mods&m.bit != 0 && !slices.Contains(code[:], m.key) && i < len(res)-1 {
+			res[i] = m.key
+			i++
 		}
-	} else if len(buf) == 4 {
-		if buf[0] == 0x1b && buf[1] == 0x5b && buf[2] == 0x5b {
-			res = map[byte]KeyCode{
-				0x41: {K_F1},
-				0x42: {K_F2},
-				0x43: {K_F3},
-				0x44: {K_F4},
-				0x45: {K_F5},
-			}[buf[3]]
-		} else if buf[0] == 0x1b && buf[1] == 0x5b && buf[3] == 0x7e {
-			res = map[byte]KeyCode{
-				0x31: {K_HOME},
-				0x32: {K_INSERT},
-				0x33: {K_DELETE},
-				0x34: {K_END},
-				0x35: {K_PAGEUP},
-				0x36: {K_PAGEDOWN},
-			}[buf[2]]
+	}
+	for _, k := range code {
+		if k != 0 && i < len(res) {
+			res[i] = k
+			i++
 		}
-	} else if len(buf) == 5 {
-		if buf[0] == 0x1b && buf[1] == 0x5b && buf[2] == 0x31 && buf[4] == 0x7e {
-			res = map[byte]KeyCode{
-				0x35: {K_F5},
-				0x37: {K_F6},
-				0x38: {K_F7},
-				0x39: {K_F8},
-			}[buf[3]]
-		} else if buf[0] == 0x1b && buf[1] == 0x5b && buf[2] == 0x32 && buf[4] == 0x7e {
-			res = map[byte]KeyCode{
-				0x30: {K_F9},
-				0x31: {K_F10},
-				0x33: {K_F11},
-				0x34: {K_F12},
-			}[buf[3]]
+	}
+	return res
+}
+
+var csiTildeKeys = map[int]Key{
+	1: K_HOME, 2: K_INSERT, 3: K_DELETE, 4: K_END, 5: K_PAGEUP, 6: K_PAGEDOWN, 7: K_HOME, 8: K_END,
+	11: K_F1, 12: K_F2, 13: K_F3, 14: K_F4, 15: K_F5, 17: K_F6, 18: K_F7, 19: K_F8, 20: K_F9, 21: K_F10, 23: K_F11, 24: K_F12,
+}
+
+var csiLetterKeys = map[byte]Key{
+	'A': K_UP, 'B': K_DOWN, 'C': K_RIGHT, 'D': K_LEFT, 'E': K_5, 'F': K_END, 'H': K_HOME,
+	'P': K_F1, 'Q': K_F2, 'R': K_F3, 'S': K_F4,
+}
+
+// The CSI sequence is: ESC [ PARAMS(0x30~0x3F)... INTERMEDIATES(0x20~0x2F)... FINAL(0x40~0x7E)
+func (p *VTParser) parseCSI(buf []byte) (n int, ev InputEvent, ok bool) {
+	if len(buf) >= 4 && buf[2] == '[' && 'A' <= buf[3] && buf[3] <= 'E' { // linux console F1~F5
+		return 4, InputEvent{Code: KeyCode{K_F1 + Key(buf[3]-'A')}}, true
+	}
+	end := 2
+	for end < len(buf) && 0x20 <= buf[end] && buf[end] <= 0x3f {
+		end++
+	}
+	if end >= len(buf) {
+		return 0, ev, false
+	} else if buf[end] < 0x40 || buf[end] > 0x7e {
+		return end, InputEvent{}, true // malformed, drop the bytes before
+	}
+	params, final := string(buf[2:end]), buf[end]
+	n = end + 1
+
+	if strings.HasPrefix(params, "<") && (final == 'M' || final == 'm') {
+		return n, InputEvent{Mouse: buf[:n:n]}, true
+	} else if strings.HasPrefix(params, "?") {
+		if final == 'u' { // response of kitty keyboard protocol query: ESC [ ? flags u
+			p.KittyFlags, _ = strconv.Atoi(params[1:])
 		}
+		return n, ev, false // other terminal responses, e.g. device attributes
 	}
 
-	return checkComboMode(res, comboMode)
+	// parameters are separated by ';', and sub-parameters are separated by ':'
+	fields := strings.Split(params, ";")
+	num, _ := strconv.Atoi(strings.Split(fields[0], ":")[0])
+	mods, release := 0, false
+	if len(fields) > 1 {
+		sub := strings.Split(fields[1], ":")
+		if m, err := strconv.Atoi(sub[0]); err == nil && m > 0 {
+			mods = m - 1
+		}
+		release = len(sub) > 1 && sub[1] == "3"
+	}
+
+	var code KeyCode
+	switch final {
+	case '~':
+		if k, ok := csiTildeKeys[num]; ok {
+			code = KeyCode{k}
+		}
+	case 'u':
+		code = kittyKeyCode(num)
+	case 'Z':
+		code, mods = KeyCode{K_TAB}, mods|modShift
+	default:
+		if k, ok := csiLetterKeys[final]; ok {
+			code = KeyCode{k}
+		}
+	}
+	if code != EmptyKeyCode {
+		code = withModifiers(code, mods)
+	}
+	return n, InputEvent{Code: code, Release: release}, true
+}
+
+// The SS3 sequence is: ESC O [PARAMS] FINAL
+func parseSS3(buf []byte) (n int, ev InputEvent, ok bool) {
+	end := 2
+	for end < len(buf) && ('0' <= buf[end] && buf[end] <= '9' || buf[end] == ';') {
+		end++
+	}
+	if end >= len(buf) {
+		return 0, ev, false
+	}
+	params := string(buf[2:end])
+	mods, _ := strconv.Atoi(params[strings.LastIndexByte(params, ';')+1:])
+	if k, ok := csiLetterKeys[buf[end]]; ok {
+		return end + 1, InputEvent{Code: withModifiers(KeyCode{k}, max(0, mods-1))}, true
+	}
+	return end + 1, InputEvent{}, true
+}
+
+// isModifierOnly reports whether the keycode only contains modifier keys, e.g. a single shift key press reported by kitty keyboard protocol.
+func isModifierOnly(code KeyCode) bool {
+	for _, k := range code {
+		if k != 0 && (k < K_L_CTRL || k > K_R_GUI) {
+			return false
+		}
+	}
+	return code != EmptyKeyCode
+}
+
+// Functional keys in kitty keyboard protocol are encoded as unicode private use area.
+// https://sw.kovidgoyal.net/kitty/keyboard-protocol/#functional-key-definitions
+var kittyFunctionalKeys = map[int]Key{
+	57358: K_CAPSLOCK, 57359: K_SCROLLLOCK, 57361: K_PRINTSCREEN, 57362: K_PAUSE,
+	57399: K_0, 57400: K_1, 57401: K_2, 57402: K_3, 57403: K_4, 57404: K_5, 57405: K_6, 57406: K_7, 57407: K_8, 57408: K_9,
+	57409: K_DOT, 57410: K_SLASH, 57412: K_MINUS, 57414: K_ENTER, 57415: K_EQUAL, 57416: K_COMMA,
+	57417: K_LEFT, 57418: K_RIGHT, 57419: K_UP, 57420: K_DOWN, 57421: K_PAGEUP, 57422: K_PAGEDOWN,
+	57423: K_HOME, 57424: K_END, 57425: K_INSERT, 57426: K_DELETE,
+	57441: K_L_SHIFT, 57442: K_L_CTRL, 57443: K_L_ALT, 57444: K_L_GUI,
+	57447: K_R_SHIFT, 57448: K_R_CTRL, 57449: K_R_ALT, 57450: K_R_GUI,
+}
+
+func kittyKeyCode(num int) KeyCode {
+	switch num {
+	case 9:
+		return KeyCode{K_TAB}
+	case 13:
+		return KeyCode{K_ENTER}
+	case 27:
+		return KeyCode{K_ESC}
+	case 127:
+		return KeyCode{K_BACKSPACE}
+	case 57411: // KP_MULTIPLY
+		return KeyCode{K_L_SHIFT, K_8}
+	case 57413: // KP_ADD
+		return KeyCode{K_L_SHIFT, K_EQUAL}
+	}
+	if k, ok := kittyFunctionalKeys[num]; ok {
+		return KeyCode{k}
+	}
+	if 0x20 <= num && num < 0x7f { // unshifted key is reported, so shifted characters in ascii table are not used
+		code := asciiKeyCodes[byte(num)]
+		if code[0] == K_L_SHIFT {
+			return KeyCode{}
+		}
+		return code
+	}
+	return KeyCode{}
+}
+
+// Sequences to query, enable and disable the kitty keyboard protocol.
+// Flags: 1 (disambiguate escape codes) | 2 (report event types) | 8 (report all keys as escape codes)
+// https://sw.kovidgoyal.net/kitty/keyboard-protocol/#progressive-enhancement
+const (
+	kittyKeyboardQuery   = "\x1b[?u\x1b[c"
+	kittyKeyboardEnable  = "\x1b[>11u"
+	kittyKeyboardDisable = "\x1b[<u"
+)
+
+// detectKittyKeyboard queries the terminal for kitty keyboard protocol support.
+// The primary device attributes request is appended, which every terminal responds, so we don't need to wait for timeout.
+func detectKittyKeyboard(fd int, parser *VTParser) bool {
+	if _, err := os.Stdout.WriteString(kittyKeyboardQuery); err != nil {
+		return false
+	}
+	var buf [256]byte
+	deadline := time.Now().Add(time.Millisecond * 500)
+	for {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return false
+		}
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		if n, err := unix.Poll(fds, int(timeout.Milliseconds())); err != nil || n == 0 {
+			return false
+		}
+		n, err := unix.Read(fd, buf[:])
+		if err != nil {
+			return false
+		}
+		parser.Feed(buf[:n])
+		if parser.KittyFlags >= 0 {
+			return true
+		} else if bytes.Contains(buf[:n], []byte("\x1b[?")) && buf[n-1] == 'c' {
+			return false
+		}
+	}
 }
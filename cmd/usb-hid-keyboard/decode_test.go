@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVTParserFeed(t *testing.T) {
+	var tests = []struct {
+		input   string
+		want    []KeyCode
+		release []bool
+	}{
+		{"ab", []KeyCode{{K_A}, {K_B}}, nil},
+		{"\x1b[1;5C", []KeyCode{{K_L_CTRL, K_RIGHT}}, nil},
+		{"\x1b[15;2~\x1b[24~", []KeyCode{{K_L_SHIFT, K_F5}, {K_F12}}, nil},
+		{"\x1bOP\x1bO1;3Q", []KeyCode{{K_F1}, {K_L_ALT, K_F2}}, nil},
+		{"\x1bx\x1b", []KeyCode{{K_L_ALT, K_X}, {K_ESC}}, nil},
+		{"\x1b[[E\x1b[Z\x1b[5~", []KeyCode{{K_F5}, {K_L_SHIFT, K_TAB}, {K_PAGEUP}}, nil},
+		{"\x1b[97;5u\x1b[97;5:3u", []KeyCode{{K_L_CTRL, K_A}, {K_L_CTRL, K_A}}, []bool{false, true}},
+		{"\x1b[57441;2u\x1b[13u", []KeyCode{{K_L_SHIFT}, {K_ENTER}}, []bool{false, false}},
+	}
+	for _, test := range tests {
+		var got []KeyCode
+		var release []bool
+		for _, ev := range NewVTParser().Feed([]byte(test.input)) {
+			got = append(got, ev.Code)
+			release = append(release, ev.Release)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Feed(%q) = %v; want %v", test.input, got, test.want)
+		}
+		if test.release != nil && !reflect.DeepEqual(release, test.release) {
+			t.Errorf("Feed(%q) release = %v; want %v", test.input, release, test.release)
+		}
+	}
+}
+
+func TestVTParserPending(t *testing.T) {
+	p := NewVTParser()
+	if events := p.Feed([]byte("a\x1b[1;")); len(events) != 1 || events[0].Code != (KeyCode{K_A}) {
+		t.Fatalf("Feed() = %v; want single key A", events)
+	}
+	if events := p.Feed([]byte("2A")); len(events) != 1 || events[0].Code != (KeyCode{K_L_SHIFT, K_UP}) {
+		t.Errorf("Feed() = %v; want SHIFT + UP", events)
+	}
+	if events := p.Feed([]byte("\x1b[<0;10;5M\x1b[?11u\x1b[?62;22c")); len(events) != 1 || string(events[0].Mouse) != "\x1b[<0;10;5M" {
+		t.Errorf("Feed() = %v; want single mouse event", events)
+	}
+	if p.KittyFlags != 11 {
+		t.Errorf("KittyFlags = %d; want 11", p.KittyFlags)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Device is the opened bridge device, pushed data are sent with interval in background after GoWaitAndSend().
+type Device interface {
+	io.ReadWriteCloser
+	SetInterval(interval time.Duration)
+	Push(data []byte)
+	GoWaitAndSend() (stop func())
+}
+
+// LEDState is the keyboard LEDs set by target host.
+type LEDState struct {
+	NumLock    bool
+	CapsLock   bool
+	ScrollLock bool
+}
+
+func (s LEDState) String() string {
+	return fmt.Sprintf("num lock: %t, caps lock: %t, scroll lock: %t", s.NumLock, s.CapsLock, s.ScrollLock)
+}
+
+// Encoder converts HID reports into the data frames of bridge device, and parses the responses from it.
+type Encoder interface {
+	// Open opens the bridge device from CFG.
+	Open() (Device, error)
+	// Keyboard encodes the keyboard report.
+	Keyboard(r Report) []byte
+	// Media encodes the press or release of media key, nil if not supported.
+	Media(k Key, pressed bool) []byte
+	// Mouse encodes the mouse report, nil if not supported.
+	Mouse(r MouseReport) []byte
+	// Handshake returns the data sent after device is opened to query its state, nil if not required.
+	Handshake() []byte
+	// ReadResponses reads from r until error, and calls handle for each LED state or failure reported by device.
+	ReadResponses(r io.Reader, handle func(leds *LEDState, err error)) error
+}
+
+var encoders = map[string]Encoder{}
+
+// RegisterEncoder makes the encoder available by name, it panics if the name is registered twice.
+func RegisterEncoder(name string, enc Encoder) {
+	if _, ok := encoders[name]; ok {
+		panic("encoder already registered: " + name)
+	}
+	encoders[name] = enc
+}
+
+func EncoderNames() []string {
+	names := make([]string, 0, len(encoders))
+	for name := range encoders {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func FindEncoder(name string) (Encoder, error) {
+	if enc, ok := encoders[name]; ok {
+		return enc, nil
+	}
+	return nil, fmt.Errorf("unknown encoder %q, must be one of %s", name, strings.Join(EncoderNames(), ", "))
+}
+
+// watchResponses sends the handshake and logs the responses from device, so dropped keystrokes will not be invisible.
+func watchResponses(ctx context.Context, enc Encoder, dev Device) {
+	if data := enc.Handshake(); data != nil {
+		if _, err := dev.Write(data); err != nil {
+			LOG.Warnf(ctx, "failed to send handshake to %s: %v", CFG.Device, err)
+		}
+	}
+	go func() {
+		var last *LEDState
+		err := enc.ReadResponses(dev, func(leds *LEDState, err error) {
+			if err != nil {
+				LOG.Warnf(ctx, "%s response: %v", CFG.Encoder, err)
+			} else if leds != nil && (last == nil || *last != *leds) {
+				LOG.Infof(ctx, "keyboard leds changed: %s", leds)
+				last = leds
+			}
+		})
+		LOG.Debugf(ctx, "stop reading %s responses: %v", CFG.Encoder, err)
+	}()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io"
+
+	"github.com/whoisnian/misc/pkg/serial"
+)
+
+func init() {
+	RegisterEncoder("ch9329", ch9329Encoder{})
+}
+
+// ch9329Encoder supports keyboard, media and mouse reports, and checks the response of each frame.
+type ch9329Encoder struct{}
+
+func (ch9329Encoder) Open() (Device, error) {
+	return serial.Open(CFG.Device, CFG.Baud, 8, serial.ParityNone, serial.StopBits1)
+}
+
+func (ch9329Encoder) Keyboard(r Report) []byte         { return EncodeForCH9329(r) }
+func (ch9329Encoder) Media(k Key, pressed bool) []byte { return EncodeMediaForCH9329(k, pressed) }
+func (ch9329Encoder) Mouse(r MouseReport) []byte       { return EncodeMouseForCH9329(r) }
+
+// Handshake queries the chip info, whose response contains the USB connection state and keyboard LEDs.
+func (ch9329Encoder) Handshake() []byte {
+	return ch9329Frame(CH9329_CMD_GET_INFO)
+}
+
+func (ch9329Encoder) ReadResponses(r io.Reader, handle func(*LEDState, error)) error {
+	return ReadCH9329Responses(r, func(resp *CH9329Response, err error) {
+		if err == nil {
+			err = resp.Err()
+		}
+		if err != nil {
+			handle(nil, err)
+		} else if resp.RequestCmd() == CH9329_CMD_GET_INFO {
+			info, err := parseCH9329Info(resp.Data)
+			if err == nil && !info.USBConnected {
+				err = ErrCH9329NotConnected
+			}
+			if err != nil {
+				handle(nil, err)
+			} else {
+				handle(&LEDState{NumLock: info.NumLock, CapsLock: info.CapsLock, ScrollLock: info.ScrollLock}, nil)
+			}
+		}
+	})
+}
+
+// Keyboard: 57 AB 00 02 08 MODIFIER 00 K1 K2 K3 K4 K5 K6 SUM
+func EncodeForCH9329(r Report) []byte {
+	cmd := [14]byte{0x57, 0xab, 0x00, 0x02, 0x08, r.Modifier, 0x00}
+	for i, k := range r.Keys {
+		cmd[7+i] = byte(k)
+	}
+	for _, b := range cmd[:13] {
+		cmd[13] += b
+	}
+	return cmd[:]
+}
+
+// The bit of media key in ch9329 media report, ACPI keys and multimedia keys are in different reports.
+var ch9329MediaBits = map[Key]struct {
+	acpi bool
+	bit  uint
+}{
+	K_POWER:        {true, 0},
+	K_SLEEP:        {true, 1},
+	K_WAKE:         {true, 2},
+	K_VOLUMEUP:     {false, 0},
+	K_VOLUMEDOWN:   {false, 1},
+	K_MUTE:         {false, 2},
+	K_PLAYPAUSE:    {false, 3},
+	K_NEXTSONG:     {false, 4},
+	K_PREVIOUSSONG: {false, 5},
+	K_STOPCD:       {false, 6},
+}
+
+// ACPI:       57 AB 00 03 02 01 BITS SUM
+// Multimedia: 57 AB 00 03 04 02 BITS BITS BITS SUM
+// The report with all bits cleared is sent if not pressed, which releases the media key.
+func EncodeMediaForCH9329(k Key, pressed bool) []byte {
+	mb, ok := ch9329MediaBits[k]
+	if !ok {
+		return nil
+	}
+	var bits uint32
+	if pressed {
+		bits = 1 << mb.bit
+	}
+	if mb.acpi {
+		return ch9329Frame(CH9329_CMD_SEND_KB_MEDIA_DATA, 0x01, byte(bits))
+	}
+	return ch9329Frame(CH9329_CMD_SEND_KB_MEDIA_DATA, 0x02, byte(bits), byte(bits>>8), byte(bits>>16))
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterEncoder("hidg", hidgEncoder{})
+}
+
+// hidgEncoder writes boot keyboard reports to the linux USB gadget HID function, e.g. /dev/hidg0 created by configfs.
+// The host sets keyboard LEDs with 1 byte output report, which can be read from the same device.
+// https://docs.kernel.org/usb/gadget_hid.html
+type hidgEncoder struct{}
+
+func (hidgEncoder) Open() (Device, error) {
+	file, err := os.OpenFile(CFG.Device, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &hidgDevice{File: file, buf: make(chan []byte, 256)}, nil
+}
+
+// Keyboard: MODIFIER 00 K1 K2 K3 K4 K5 K6
+func (hidgEncoder) Keyboard(r Report) []byte {
+	report := [8]byte{r.Modifier}
+	for i, k := range r.Keys {
+		report[2+i] = byte(k)
+	}
+	return report[:]
+}
+
+func (hidgEncoder) Media(Key, bool) []byte   { return nil }
+func (hidgEncoder) Mouse(MouseReport) []byte { return nil }
+func (hidgEncoder) Handshake() []byte        { return nil }
+
+// Output report: BIT0 NumLock, BIT1 CapsLock, BIT2 ScrollLock, BIT3 Compose, BIT4 Kana
+func (hidgEncoder) ReadResponses(r io.Reader, handle func(*LEDState, error)) error {
+	buf := make([]byte, 8)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return err
+		} else if n > 0 {
+			handle(&LEDState{NumLock: buf[0]&0x01 != 0, CapsLock: buf[0]&0x02 != 0, ScrollLock: buf[0]&0x04 != 0}, nil)
+		}
+	}
+}
+
+// hidgDevice sends the pushed reports in order like serial.Port.
+type hidgDevice struct {
+	*os.File
+	wg       sync.WaitGroup
+	buf      chan []byte
+	interval time.Duration
+}
+
+func (d *hidgDevice) SetInterval(interval time.Duration) {
+	d.interval = interval
+}
+
+func (d *hidgDevice) Push(data []byte) {
+	d.buf <- data
+}
+
+func (d *hidgDevice) GoWaitAndSend() (stop func()) {
+	d.wg.Go(func() {
+		for data := range d.buf {
+			if _, err := d.Write(data); err != nil {
+				LOG.Warnf(context.Background(), "failed to write %s: %v", d.Name(), err) // ESHUTDOWN if the gadget is not connected to host
+			}
+			time.Sleep(d.interval)
+		}
+	})
+
+	return func() {
+		close(d.buf)
+		d.wg.Wait()
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io"
+
+	"github.com/whoisnian/misc/pkg/serial"
+)
+
+func init() {
+	RegisterEncoder("kcom3", kcom3Encoder{})
+}
+
+// kcom3Encoder supports keyboard reports only, and the device does not respond.
+type kcom3Encoder struct{}
+
+func (kcom3Encoder) Open() (Device, error) {
+	return serial.Open(CFG.Device, CFG.Baud, 8, serial.ParityNone, serial.StopBits1)
+}
+
+// Keyboard: 57 AB 01 MODIFIER 00 K1 K2 K3 K4 K5 K6
+func (kcom3Encoder) Keyboard(r Report) []byte {
+	cmd := [11]byte{0x57, 0xab, 0x01, r.Modifier, 0x00}
+	for i, k := range r.Keys {
+		cmd[5+i] = byte(k)
+	}
+	return cmd[:]
+}
+
+func (kcom3Encoder) Media(Key, bool) []byte   { return nil }
+func (kcom3Encoder) Mouse(MouseReport) []byte { return nil }
+func (kcom3Encoder) Handshake() []byte        { return nil }
+
+func (kcom3Encoder) ReadResponses(io.Reader, func(*LEDState, error)) error {
+	return nil
+}
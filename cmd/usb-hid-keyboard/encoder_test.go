@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncoders(t *testing.T) {
+	r := KeyCode{K_L_SHIFT, K_A}.Report()
+	var tests = []struct {
+		name string
+		want []byte
+	}{
+		{"ch9329", []byte{0x57, 0xAB, 0x00, 0x02, 0x08, 0x02, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x12}},
+		{"kcom3", []byte{0x57, 0xAB, 0x01, 0x02, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00}},
+		{"hidg", []byte{0x02, 0x00, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00}},
+	}
+	for _, test := range tests {
+		enc, err := FindEncoder(test.name)
+		if err != nil {
+			t.Fatalf("FindEncoder(%s) error: %v", test.name, err)
+		}
+		if got := enc.Keyboard(r); !bytes.Equal(got, test.want) {
+			t.Errorf("%s.Keyboard(%s) = % X; want % X", test.name, r, got, test.want)
+		}
+	}
+	if _, err := FindEncoder("unknown"); err == nil {
+		t.Errorf("FindEncoder(unknown) = nil; want error")
+	}
+}
+
+func TestEncoderReadResponses(t *testing.T) {
+	var tests = []struct {
+		name  string
+		input []byte
+		want  LEDState
+	}{
+		{"ch9329", ch9329Frame(0x81, 0x30, 0x01, 0x02, 0x00, 0x00, 0x00, 0x00, 0x00), LEDState{CapsLock: true}},
+		{"hidg", []byte{0x05}, LEDState{NumLock: true, ScrollLock: true}},
+	}
+	for _, test := range tests {
+		enc, _ := FindEncoder(test.name)
+		var got []LEDState
+		enc.ReadResponses(bytes.NewReader(test.input), func(leds *LEDState, err error) {
+			if err != nil {
+				t.Errorf("%s.ReadResponses() error: %v", test.name, err)
+			} else {
+				got = append(got, *leds)
+			}
+		})
+		if len(got) != 1 || got[0] != test.want {
+			t.Errorf("%s.ReadResponses() = %+v; want %+v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Linux input event from evdev device, see `struct input_event` in linux/input.h.
+type inputEvent struct {
+	Time  unix.Timeval
+	Type  uint16
+	Code  uint16
+	Value int32
+}
+
+const (
+	EV_KEY = 0x01
+
+	EV_KEY_RELEASE = 0
+	EV_KEY_PRESS   = 1
+	EV_KEY_REPEAT  = 2
+
+	EVIOCGRAB = 0x40044590 // _IOW('E', 0x90, int)
+)
+
+// linuxKeyCodes maps the linux input event codes to HID keys, keypad keys are mapped to the main keys.
+// https://github.com/torvalds/linux/blob/master/include/uapi/linux/input-event-codes.h
+var linuxKeyCodes = map[uint16]Key{
+	1: K_ESC, 2: K_1, 3: K_2, 4: K_3, 5: K_4, 6: K_5, 7: K_6, 8: K_7, 9: K_8, 10: K_9, 11: K_0,
+	12: K_MINUS, 13: K_EQUAL, 14: K_BACKSPACE, 15: K_TAB,
+	16: K_Q, 17: K_W, 18: K_E, 19: K_R, 20: K_T, 21: K_Y, 22: K_U, 23: K_I, 24: K_O, 25: K_P,
+	26: K_LEFTBRACE, 27: K_RIGHTBRACE, 28: K_ENTER, 29: K_L_CTRL,
+	30: K_A, 31: K_S, 32: K_D, 33: K_F, 34: K_G, 35: K_H, 36: K_J, 37: K_K, 38: K_L,
+	39: K_SEMICOLON, 40: K_APOSTROPHE, 41: K_GRAVE, 42: K_L_SHIFT, 43: K_BACKSLASH,
+	44: K_Z, 45: K_X, 46: K_C, 47: K_V, 48: K_B, 49: K_N, 50: K_M,
+	51: K_COMMA, 52: K_DOT, 53: K_SLASH, 54: K_R_SHIFT, 56: K_L_ALT, 57: K_SPACE, 58: K_CAPSLOCK,
+	59: K_F1, 60: K_F2, 61: K_F3, 62: K_F4, 63: K_F5, 64: K_F6, 65: K_F7, 66: K_F8, 67: K_F9, 68: K_F10,
+	70: K_SCROLLLOCK,
+	71: K_7, 72: K_8, 73: K_9, 74: K_MINUS, 75: K_4, 76: K_5, 77: K_6, 79: K_1, 80: K_2, 81: K_3, 82: K_0, 83: K_DOT,
+	86: K_NONUS_BACKSLASH, 87: K_F11, 88: K_F12,
+	96: K_ENTER, 97: K_R_CTRL, 98: K_SLASH, 99: K_PRINTSCREEN, 100: K_R_ALT,
+	102: K_HOME, 103: K_UP, 104: K_PAGEUP, 105: K_LEFT, 106: K_RIGHT, 107: K_END, 108: K_DOWN, 109: K_PAGEDOWN,
+	110: K_INSERT, 111: K_DELETE, 119: K_PAUSE, 125: K_L_GUI, 126: K_R_GUI,
+	113: K_MUTE, 114: K_VOLUMEDOWN, 115: K_VOLUMEUP, 116: K_POWER, 142: K_SLEEP, 143: K_WAKE,
+	163: K_NEXTSONG, 164: K_PLAYPAUSE, 165: K_PREVIOUSSONG, 166: K_STOPCD,
+}
+
+// EvdevKeyboard reads key events from linux evdev device, e.g. /dev/input/event0.
+type EvdevKeyboard struct {
+	file *os.File
+}
+
+// OpenEvdevKeyboard opens the evdev device, and grabs it exclusively if required, so that key events are not received by local system.
+func OpenEvdevKeyboard(path string, grab bool) (*EvdevKeyboard, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if grab {
+		if err := unix.IoctlSetInt(int(file.Fd()), EVIOCGRAB, 1); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("grab %s: %w", path, err)
+		}
+	}
+	return &EvdevKeyboard{file: file}, nil
+}
+
+func (kb *EvdevKeyboard) Close() error {
+	return kb.file.Close() // the grab is released automatically on close
+}
+
+// ReadKey blocks until next key press or release event, repeat events are skipped since the target will auto-repeat held keys itself.
+// Unknown keys are returned as zero Key.
+func (kb *EvdevKeyboard) ReadKey() (k Key, release bool, err error) {
+	return readEvdevKey(kb.file)
+}
+
+func readEvdevKey(r io.Reader) (k Key, release bool, err error) {
+	var ev inputEvent
+	for {
+		if err := binary.Read(r, binary.NativeEndian, &ev); err != nil {
+			return 0, false, err
+		}
+		if ev.Type == EV_KEY && (ev.Value == EV_KEY_PRESS || ev.Value == EV_KEY_RELEASE) {
+			return linuxKeyCodes[ev.Code], ev.Value == EV_KEY_RELEASE, nil
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestReadEvdevKey(t *testing.T) {
+	var buf bytes.Buffer
+	for _, ev := range []inputEvent{
+		{Type: 0x04, Code: 0x04, Value: 0x1e}, // EV_MSC MSC_SCAN
+		{Type: EV_KEY, Code: 30, Value: EV_KEY_PRESS},
+		{Type: 0x00}, // EV_SYN
+		{Type: EV_KEY, Code: 30, Value: EV_KEY_REPEAT},
+		{Type: EV_KEY, Code: 30, Value: EV_KEY_RELEASE},
+	} {
+		binary.Write(&buf, binary.NativeEndian, ev)
+	}
+
+	if k, release, err := readEvdevKey(&buf); err != nil || k != K_A || release {
+		t.Errorf("readEvdevKey() = %s, %v, %v; want A press", k, release, err)
+	}
+	if k, release, err := readEvdevKey(&buf); err != nil || k != K_A || !release {
+		t.Errorf("readEvdevKey() = %s, %v, %v; want A release", k, release, err)
+	}
+	if _, _, err := readEvdevKey(&buf); err == nil {
+		t.Errorf("readEvdevKey() error = nil; want EOF")
+	}
+}
@@ -54,6 +54,7 @@ func _() {
 	_ = x[K_LEFTBRACE-47]
 	_ = x[K_RIGHTBRACE-48]
 	_ = x[K_BACKSLASH-49]
+	_ = x[K_NONUS_HASH-50]
 	_ = x[K_SEMICOLON-51]
 	_ = x[K_APOSTROPHE-52]
 	_ = x[K_GRAVE-53]
@@ -86,6 +87,7 @@ func _() {
 	_ = x[K_LEFT-80]
 	_ = x[K_DOWN-81]
 	_ = x[K_UP-82]
+	_ = x[K_NONUS_BACKSLASH-100]
 	_ = x[K_L_CTRL-224]
 	_ = x[K_L_SHIFT-225]
 	_ = x[K_L_ALT-226]
@@ -94,31 +96,43 @@ func _() {
 	_ = x[K_R_SHIFT-229]
 	_ = x[K_R_ALT-230]
 	_ = x[K_R_GUI-231]
+	_ = x[K_POWER-232]
+	_ = x[K_SLEEP-233]
+	_ = x[K_WAKE-234]
+	_ = x[K_VOLUMEUP-235]
+	_ = x[K_VOLUMEDOWN-236]
+	_ = x[K_MUTE-237]
+	_ = x[K_PLAYPAUSE-238]
+	_ = x[K_NEXTSONG-239]
+	_ = x[K_PREVIOUSSONG-240]
+	_ = x[K_STOPCD-241]
+	_ = x[K_ERR_ROLLOVER-1]
 }
 
 const (
-	_Key_name_0 = "ABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890ENTERESCBACKSPACETABSPACEMINUSEQUALLEFTBRACERIGHTBRACEBACKSLASH"
-	_Key_name_1 = "SEMICOLONAPOSTROPHEGRAVECOMMADOTSLASHCAPSLOCKF1F2F3F4F5F6F7F8F9F10F11F12PRINTSCREENSCROLLLOCKPAUSEINSERTHOMEPAGEUPDELETEENDPAGEDOWNRIGHTLEFTDOWNUP"
-	_Key_name_2 = "L_CTRLL_SHIFTL_ALTL_GUIR_CTRLR_SHIFTR_ALTR_GUI"
+	_Key_name_0 = "ERR_ROLLOVER"
+	_Key_name_1 = "ABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890ENTERESCBACKSPACETABSPACEMINUSEQUALLEFTBRACERIGHTBRACEBACKSLASHNONUS_HASHSEMICOLONAPOSTROPHEGRAVECOMMADOTSLASHCAPSLOCKF1F2F3F4F5F6F7F8F9F10F11F12PRINTSCREENSCROLLLOCKPAUSEINSERTHOMEPAGEUPDELETEENDPAGEDOWNRIGHTLEFTDOWNUP"
+	_Key_name_2 = "NONUS_BACKSLASH"
+	_Key_name_3 = "L_CTRLL_SHIFTL_ALTL_GUIR_CTRLR_SHIFTR_ALTR_GUIPOWERSLEEPWAKEVOLUMEUPVOLUMEDOWNMUTEPLAYPAUSENEXTSONGPREVIOUSSONGSTOPCD"
 )
 
 var (
-	_Key_index_0 = [...]uint8{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34, 35, 36, 41, 44, 53, 56, 61, 66, 71, 80, 90, 99}
-	_Key_index_1 = [...]uint8{0, 9, 19, 24, 29, 32, 37, 45, 47, 49, 51, 53, 55, 57, 59, 61, 63, 66, 69, 72, 83, 93, 98, 104, 108, 114, 120, 123, 131, 136, 140, 144, 146}
-	_Key_index_2 = [...]uint8{0, 6, 13, 18, 23, 29, 36, 41, 46}
+	_Key_index_1 = [...]uint8{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34, 35, 36, 41, 44, 53, 56, 61, 66, 71, 80, 90, 99, 109, 118, 128, 133, 138, 141, 146, 154, 156, 158, 160, 162, 164, 166, 168, 170, 172, 175, 178, 181, 192, 202, 207, 213, 217, 223, 229, 232, 240, 245, 249, 253, 255}
+	_Key_index_3 = [...]uint8{0, 6, 13, 18, 23, 29, 36, 41, 46, 51, 56, 60, 68, 78, 82, 91, 99, 111, 117}
 )
 
 func (i Key) String() string {
 	switch {
-	case 4 <= i && i <= 49:
+	case i == 1:
+		return _Key_name_0
+	case 4 <= i && i <= 82:
 		i -= 4
-		return _Key_name_0[_Key_index_0[i]:_Key_index_0[i+1]]
-	case 51 <= i && i <= 82:
-		i -= 51
 		return _Key_name_1[_Key_index_1[i]:_Key_index_1[i+1]]
-	case 224 <= i && i <= 231:
+	case i == 100:
+		return _Key_name_2
+	case 224 <= i && i <= 241:
 		i -= 224
-		return _Key_name_2[_Key_index_2[i]:_Key_index_2[i+1]]
+		return _Key_name_3[_Key_index_3[i]:_Key_index_3[i+1]]
 	default:
 		return "Key(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
@@ -1,6 +1,11 @@
 //go:generate stringer -type=Key -trimprefix=K_ -output=key_name.go
 package main
 
+import (
+	"fmt"
+	"strings"
+)
+
 type Key byte
 
 // HID Key Definition
@@ -54,7 +59,7 @@ const (
 	K_LEFTBRACE  // [
 	K_RIGHTBRACE // ]
 	K_BACKSLASH  // \
-	_
+	K_NONUS_HASH // # and ~ on ISO keyboards
 	K_SEMICOLON  // ;
 	K_APOSTROPHE // '
 	K_GRAVE      // `
@@ -90,7 +95,9 @@ const (
 	K_DOWN
 	K_UP
 
-	K_L_CTRL Key = iota + 0x91
+	K_NONUS_BACKSLASH Key = iota + 0x15 // \ and | next to left shift on ISO keyboards
+
+	K_L_CTRL Key = iota + 0x90
 	K_L_SHIFT
 	K_L_ALT
 	K_L_GUI
@@ -100,6 +107,35 @@ const (
 	K_R_GUI
 )
 
+// Pseudo keys for system control (ACPI) and consumer control, which are not in the keyboard usage page.
+// They take the reserved values after modifiers, and are sent with separate media reports instead of keyboard report.
+const (
+	K_POWER Key = iota + 0xE8
+	K_SLEEP
+	K_WAKE
+	K_VOLUMEUP
+	K_VOLUMEDOWN
+	K_MUTE
+	K_PLAYPAUSE
+	K_NEXTSONG
+	K_PREVIOUSSONG
+	K_STOPCD
+)
+
+func isMediaKey(k Key) bool {
+	return K_POWER <= k && k <= K_STOPCD
+}
+
+// MediaKey returns the first media key in keycode, or zero Key if there is none.
+func (ks KeyCode) MediaKey() Key {
+	for _, k := range ks {
+		if isMediaKey(k) {
+			return k
+		}
+	}
+	return 0
+}
+
 type KeyCode [4]Key
 
 var EmptyKeyCode KeyCode = KeyCode{}
@@ -117,3 +153,56 @@ func (ks KeyCode) String() string {
 		return ks[0].String() + " + " + ks[1].String() + " + " + ks[2].String() + " + " + ks[3].String()
 	}
 }
+
+var keyAliases = map[string]Key{
+	"CTRL":      K_L_CTRL,
+	"CONTROL":   K_L_CTRL,
+	"SHIFT":     K_L_SHIFT,
+	"ALT":       K_L_ALT,
+	"ALTGR":     K_R_ALT,
+	"GUI":       K_L_GUI,
+	"SUPER":     K_L_GUI,
+	"WIN":       K_L_GUI,
+	"META":      K_L_GUI,
+	"RETURN":    K_ENTER,
+	"ESCAPE":    K_ESC,
+	"DEL":       K_DELETE,
+	"INS":       K_INSERT,
+	"PGUP":      K_PAGEUP,
+	"PGDN":      K_PAGEDOWN,
+	"BS":        K_BACKSPACE,
+	"PRTSC":     K_PRINTSCREEN,
+	"BACKQUOTE": K_GRAVE,
+	"VOLUP":     K_VOLUMEUP,
+	"VOLDOWN":   K_VOLUMEDOWN,
+}
+
+// ParseKey finds the key by its name from Key.String() or a common alias, case-insensitive.
+func ParseKey(name string) (Key, bool) {
+	name = strings.ToUpper(name)
+	if k, ok := keyAliases[name]; ok {
+		return k, true
+	}
+	for k := range 256 {
+		if Key(k).String() == name {
+			return Key(k), true
+		}
+	}
+	return 0, false
+}
+
+// ParseKeyCode parses key combination like "ctrl+alt+f2" into keycode.
+func ParseKeyCode(s string) (res KeyCode, err error) {
+	names := strings.Split(s, "+")
+	if len(names) > len(res) {
+		return res, fmt.Errorf("too many keys in %q, at most %d", s, len(res))
+	}
+	for i, name := range names {
+		k, ok := ParseKey(strings.TrimSpace(name))
+		if !ok {
+			return res, fmt.Errorf("unknown key %q", name)
+		}
+		res[i] = k
+	}
+	return res, nil
+}
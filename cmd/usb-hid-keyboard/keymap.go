@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Some key combinations are captured by the operating system and cannot be sent to the raw mode terminal.
+// Therefore, we defined some combo keycodes to trigger the corresponding key combinations.
+// After the prefix is pressed, the next keycode triggers the bound steps, or exits the program.
+const defaultKeymapYAML = `
+prefix: ctrl+k
+exit: q
+bindings:
+  - {key: k, press: ctrl+k}
+  - {key: ctrl+k, press: ctrl+k}
+  - {key: t, press: ctrl+alt+t}
+  - {key: f1, press: ctrl+alt+f1}
+  - {key: f2, press: ctrl+alt+f2}
+  - {key: f3, press: ctrl+alt+f3}
+  - {key: f4, press: ctrl+alt+f4}
+  - {key: f5, press: ctrl+alt+f5}
+  - {key: f6, press: ctrl+alt+f6}
+  - {key: f7, press: ctrl+alt+f7}
+  - {key: f8, press: ctrl+alt+f8}
+  - {key: f9, press: ctrl+alt+f9}
+  - {key: f10, press: ctrl+alt+f10}
+  - {key: f11, press: ctrl+alt+f11}
+  - {key: f12, press: ctrl+alt+f12}
+  - {key: delete, press: ctrl+alt+delete}
+  - {key: shift+p, press: power}
+  - {key: shift+s, press: sleep}
+  - {key: w, press: wake}
+  - {key: up, press: volumeup}
+  - {key: down, press: volumedown}
+  - {key: m, press: mute}
+`
+
+// Keymap is the combo mode mapping loaded from YAML or JSON file, key names are from Key.String() and case-insensitive.
+// Each binding either presses a single key combination, or runs a macro script for multi-step sequence:
+//
+//	prefix: ctrl+k
+//	exit: q
+//	bindings:
+//	  - key: f2
+//	    press: ctrl+alt+f2
+//	  - key: l
+//	    script: |
+//	      press gui+r
+//	      sleep 500ms
+//	      type "cmd\n"
+type Keymap struct {
+	Prefix   KeyCode
+	Exit     KeyCode
+	Bindings map[KeyCode]Script
+}
+
+type keymapFile struct {
+	Prefix   string `json:"prefix"`
+	Exit     string `json:"exit"`
+	Bindings []struct {
+		Key    string `json:"key"`
+		Press  string `json:"press"`
+		Script string `json:"script"`
+	} `json:"bindings"`
+}
+
+var comboKeymap = mustParseKeymap(defaultKeymapYAML)
+
+func mustParseKeymap(data string) *Keymap {
+	keymap, err := ParseKeymap([]byte(data), layoutUS)
+	if err != nil {
+		panic(err)
+	}
+	return keymap
+}
+
+// ParseKeymap parses and validates the keymap, the layout is used by type steps in scripts.
+func ParseKeymap(data []byte, layout Layout) (*Keymap, error) {
+	var file keymapFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, err
+	}
+	if file.Prefix == "" {
+		return nil, fmt.Errorf("prefix: missing key combination")
+	}
+	prefix, err := ParseKeyCode(file.Prefix)
+	if err != nil {
+		return nil, fmt.Errorf("prefix: %w", err)
+	}
+	keymap := &Keymap{Prefix: prefix, Bindings: make(map[KeyCode]Script)}
+	if file.Exit != "" {
+		if keymap.Exit, err = ParseKeyCode(file.Exit); err != nil {
+			return nil, fmt.Errorf("exit: %w", err)
+		}
+	}
+
+	for i, b := range file.Bindings {
+		errorf := func(format string, a ...any) error {
+			return fmt.Errorf("bindings[%d] (key %q): %s", i, b.Key, fmt.Sprintf(format, a...))
+		}
+		if b.Key == "" {
+			return nil, errorf("missing key")
+		}
+		key, err := ParseKeyCode(b.Key)
+		if err != nil {
+			return nil, errorf("%v", err)
+		} else if _, ok := keymap.Bindings[key]; ok || key == keymap.Exit {
+			return nil, errorf("duplicate key %s", key)
+		}
+
+		var script Script
+		switch {
+		case b.Press != "" && b.Script != "":
+			return nil, errorf("only one of press and script can be set")
+		case b.Press != "":
+			code, err := ParseKeyCode(b.Press)
+			if err != nil {
+				return nil, errorf("press: %v", err)
+			}
+			script = Script{{Op: OpPress, Line: 1, Codes: []KeyCode{code}}}
+		case strings.TrimSpace(b.Script) != "":
+			if script, err = ParseScript(b.Script, layout); err != nil {
+				return nil, errorf("script: %v", err)
+			}
+		default:
+			return nil, errorf("missing press or script")
+		}
+		keymap.Bindings[key] = script
+	}
+	return keymap, nil
+}
+
+// Check returns the steps to send for the keycode.
+// Out of combo mode, the keycode is pressed as is unless it is the prefix, which enters combo mode.
+// In combo mode, the bound steps are returned and combo mode is left, unknown keycodes are ignored.
+func (m *Keymap) Check(ori KeyCode, comboMode bool) (res Script, isCombo bool, isExit bool) {
+	if !comboMode {
+		if ori == m.Prefix {
+			return nil, true, false
+		}
+		return Script{{Op: OpPress, Codes: []KeyCode{ori}}}, false, false
+	}
+	if m.Exit != EmptyKeyCode && ori == m.Exit {
+		return nil, false, true
+	}
+	return m.Bindings[ori], false, false
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseKeymap(t *testing.T) {
+	src := `{"prefix": "ctrl+b", "exit": "x", "bindings": [
+		{"key": "f2", "press": "ctrl+alt+f2"},
+		{"key": "l", "script": "press gui+r\nsleep 500ms\ntype \"cmd\\n\""}
+	]}`
+	keymap, err := ParseKeymap([]byte(src), layoutUS)
+	if err != nil {
+		t.Fatalf("ParseKeymap() error: %v", err)
+	}
+	if keymap.Prefix != (KeyCode{K_L_CTRL, K_B}) || keymap.Exit != (KeyCode{K_X}) || len(keymap.Bindings) != 2 {
+		t.Errorf("ParseKeymap() = %+v; want prefix CTRL+B, exit X and 2 bindings", keymap)
+	}
+
+	if _, isCombo, _ := keymap.Check(KeyCode{K_L_CTRL, K_B}, false); !isCombo {
+		t.Errorf("Check(prefix) isCombo = false; want true")
+	}
+	if _, _, isExit := keymap.Check(KeyCode{K_X}, true); !isExit {
+		t.Errorf("Check(exit) isExit = false; want true")
+	}
+	if script, isCombo, _ := keymap.Check(KeyCode{K_L}, true); isCombo || len(script) != 3 || script[2].Op != OpType {
+		t.Errorf("Check(L) = %+v, %v; want script with 3 steps", script, isCombo)
+	}
+	if script, _, _ := keymap.Check(KeyCode{K_A}, false); len(script) != 1 || script[0].Codes[0] != (KeyCode{K_A}) {
+		t.Errorf("Check(A) = %+v; want press A", script)
+	}
+}
+
+func TestDefaultKeymapSystemKeys(t *testing.T) {
+	if script, _, _ := comboKeymap.Check(KeyCode{K_P}, true); script != nil {
+		t.Errorf("Check(P) = %+v; want no binding", script)
+	}
+	if script, _, _ := comboKeymap.Check(KeyCode{K_L_SHIFT, K_P}, true); len(script) != 1 || script[0].Codes[0] != (KeyCode{K_POWER}) {
+		t.Errorf("Check(SHIFT+P) = %+v; want power", script)
+	}
+}
+
+func TestParseKeymapError(t *testing.T) {
+	var tests = []struct {
+		input string
+		want  string
+	}{
+		{"exit: q", "prefix: missing key combination"},
+		{"prefix: ctrl+foo", "prefix: unknown key"},
+		{"prefix: ctrl+k\nunknown: 1", "error unmarshaling JSON"},
+		{"prefix: ctrl+k\nbindings: [{key: t}]", `bindings[0] (key "t"): missing press or script`},
+		{"prefix: ctrl+k\nbindings: [{key: t, press: a, script: press a}]", `bindings[0] (key "t"): only one of press and script`},
+		{"prefix: ctrl+k\nexit: q\nbindings: [{key: q, press: a}]", `bindings[0] (key "q"): duplicate key Q`},
+		{"prefix: ctrl+k\nbindings: [{key: t, script: sleep 2}]", `bindings[0] (key "t"): script: line 1: invalid duration`},
+	}
+	for _, test := range tests {
+		if _, err := ParseKeymap([]byte(test.input), layoutUS); err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("ParseKeymap(%q) error = %v; want %q", test.input, err, test.want)
+		}
+	}
+}
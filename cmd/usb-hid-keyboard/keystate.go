@@ -0,0 +1,148 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// Report is the keyboard input report in boot protocol, with modifier bits and up to 6 pressed keys.
+// https://www.usb.org/sites/default/files/hid1_11.pdf#page=69
+type Report struct {
+	Modifier byte
+	Keys     [6]Key
+}
+
+var EmptyReport Report = Report{}
+
+// K_ERR_ROLLOVER fills all key slots of report if too many keys are pressed.
+const K_ERR_ROLLOVER Key = 0x01
+
+func (r Report) String() string {
+	var names []string
+	for k := K_L_CTRL; k <= K_R_GUI; k++ {
+		if r.Modifier&(1<<(k-K_L_CTRL)) != 0 {
+			names = append(names, k.String())
+		}
+	}
+	for _, k := range r.Keys {
+		if k == K_ERR_ROLLOVER {
+			names = append(names, k.String())
+			break
+		} else if k != 0 {
+			names = append(names, k.String())
+		}
+	}
+	if len(names) == 0 {
+		return "NONE"
+	}
+	return strings.Join(names, " + ")
+}
+
+func isModifier(k Key) bool {
+	return K_L_CTRL <= k && k <= K_R_GUI
+}
+
+// Report converts the keycode into keyboard report, media keys are skipped.
+func (ks KeyCode) Report() (r Report) {
+	pos := 0
+	for _, k := range ks {
+		switch {
+		case isModifier(k):
+			r.Modifier |= 1 << (k - K_L_CTRL)
+		case k != 0 && !isMediaKey(k):
+			r.Keys[pos] = k
+			pos++
+		}
+	}
+	return r
+}
+
+// KeyState keeps the currently pressed modifiers and keys, and generates incremental reports for press and release events.
+// Keys are reported in the order they were pressed, and ErrorRollOver is reported if more than 6 keys are pressed.
+type KeyState struct {
+	modifier byte
+	keys     []Key
+}
+
+// Press adds the key into pressed set, and returns the new report if state is changed.
+func (s *KeyState) Press(k Key) (Report, bool) {
+	if isModifier(k) {
+		if s.modifier&(1<<(k-K_L_CTRL)) != 0 {
+			return s.Report(), false
+		}
+		s.modifier |= 1 << (k - K_L_CTRL)
+	} else if k == 0 || isMediaKey(k) || slices.Contains(s.keys, k) {
+		return s.Report(), false
+	} else {
+		s.keys = append(s.keys, k)
+	}
+	return s.Report(), true
+}
+
+// Release removes the key from pressed set, and returns the new report if state is changed.
+func (s *KeyState) Release(k Key) (Report, bool) {
+	if isModifier(k) {
+		if s.modifier&(1<<(k-K_L_CTRL)) == 0 {
+			return s.Report(), false
+		}
+		s.modifier &^= 1 << (k - K_L_CTRL)
+	} else if i := slices.Index(s.keys, k); k == 0 || i < 0 {
+		return s.Report(), false
+	} else {
+		s.keys = slices.Delete(s.keys, i, i+1)
+	}
+	return s.Report(), true
+}
+
+// ReleaseAll clears the pressed set, and returns whether any key was pressed before.
+func (s *KeyState) ReleaseAll() bool {
+	pressed := s.modifier != 0 || len(s.keys) > 0
+	s.modifier, s.keys = 0, s.keys[:0]
+	return pressed
+}
+
+// Update applies a press or release event of key combination, which is reported by terminal with modifiers.
+// For press event all keys are pressed, and for release event only the non-modifier keys are released unless there are none.
+func (s *KeyState) Update(code KeyCode, release bool) (r Report, changed bool) {
+	onlyModifiers := isModifierOnly(code)
+	for _, k := range code {
+		var ok bool
+		if !release {
+			r, ok = s.Press(k)
+		} else if onlyModifiers || !isModifier(k) {
+			r, ok = s.Release(k)
+		}
+		changed = changed || ok
+	}
+	return s.Report(), changed
+}
+
+// Chord returns the key combination of held modifiers and the given keys, which is used to match combo keycodes.
+func (s *KeyState) Chord(code KeyCode) (res KeyCode) {
+	i := 0
+	for k := K_L_CTRL; k <= K_R_GUI; k++ {
+		if s.modifier&(1<<(k-K_L_CTRL)) != 0 && !slices.Contains(code[:], k) && i < len(res)-1 {
+			res[i] = k
+			i++
+		}
+	}
+	for _, k := range code {
+		if k != 0 && i < len(res) {
+			res[i] = k
+			i++
+		}
+	}
+	return res
+}
+
+func (s *KeyState) Report() (r Report) {
+	r.Modifier = s.modifier
+	if len(s.keys) > len(r.Keys) {
+		for i := range r.Keys {
+			r.Keys[i] = K_ERR_ROLLOVER
+		}
+		return r
+	}
+	copy(r.Keys[:], s.keys)
+	return r
+}
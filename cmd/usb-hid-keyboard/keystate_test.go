@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestKeyState(t *testing.T) {
+	var s KeyState
+	steps := []struct {
+		press   bool
+		key     Key
+		want    string
+		changed bool
+	}{
+		{true, K_L_CTRL, "L_CTRL", true},
+		{true, K_A, "L_CTRL + A", true},
+		{true, K_A, "L_CTRL + A", false},
+		{true, K_B, "L_CTRL + A + B", true},
+		{false, K_L_CTRL, "A + B", true},
+		{false, K_A, "B", true},
+		{false, K_C, "B", false},
+	}
+	for i, step := range steps {
+		var r Report
+		var changed bool
+		if step.press {
+			r, changed = s.Press(step.key)
+		} else {
+			r, changed = s.Release(step.key)
+		}
+		if r.String() != step.want || changed != step.changed {
+			t.Errorf("step %d: got %s, %v; want %s, %v", i, r, changed, step.want, step.changed)
+		}
+	}
+
+	for _, k := range []Key{K_C, K_D, K_E, K_F, K_G, K_H} {
+		s.Press(k)
+	}
+	if r := s.Report(); r.Keys[0] != K_ERR_ROLLOVER || r.Keys[5] != K_ERR_ROLLOVER {
+		t.Errorf("Report() = %v; want error rollover", r.Keys)
+	}
+	if !s.ReleaseAll() || s.Report() != EmptyReport {
+		t.Errorf("ReleaseAll() did not clear the state")
+	}
+}
+
+func TestKeyStateUpdate(t *testing.T) {
+	var s KeyState
+	if r, changed := s.Update(KeyCode{K_L_CTRL, K_A}, false); !changed || r.String() != "L_CTRL + A" {
+		t.Errorf("Update(CTRL + A, press) = %s, %v; want L_CTRL + A", r, changed)
+	}
+	if r, changed := s.Update(KeyCode{K_L_CTRL, K_A}, true); !changed || r.String() != "L_CTRL" {
+		t.Errorf("Update(CTRL + A, release) = %s, %v; want L_CTRL", r, changed)
+	}
+	if chord := s.Chord(KeyCode{K_K}); chord != (KeyCode{K_L_CTRL, K_K}) {
+		t.Errorf("Chord(K) = %s; want L_CTRL + K", chord)
+	}
+	if r, changed := s.Update(KeyCode{K_L_CTRL}, true); !changed || r != EmptyReport {
+		t.Errorf("Update(CTRL, release) = %s, %v; want NONE", r, changed)
+	}
+}
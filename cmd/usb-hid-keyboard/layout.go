@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Layout maps the characters to the keycodes that type them on the target with the corresponding keyboard layout.
+// Most characters need a single keycode, but dead keys need to be followed by a space to type the accent itself.
+type Layout map[rune][]KeyCode
+
+var layouts = map[string]Layout{
+	"us": layoutUS,
+	"uk": layoutUK,
+	"de": layoutDE,
+	"fr": layoutFR,
+}
+
+func LayoutNames() []string {
+	names := make([]string, 0, len(layouts))
+	for name := range layouts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func FindLayout(name string) (Layout, error) {
+	if l, ok := layouts[strings.ToLower(name)]; ok {
+		return l, nil
+	}
+	return nil, fmt.Errorf("unknown layout %q, must be one of %s", name, strings.Join(LayoutNames(), ", "))
+}
+
+// UntypableChar records the position of a character that the layout is unable to type.
+type UntypableChar struct {
+	Char rune
+	Line int
+	Col  int
+}
+
+func (c UntypableChar) String() string {
+	return fmt.Sprintf("%d:%d %q", c.Line, c.Col, c.Char)
+}
+
+// Encode converts the text into keycode sequence. Carriage returns are ignored, so CRLF is typed as a single ENTER.
+// Characters that cannot be typed are skipped and reported with their positions.
+func (l Layout) Encode(text string) (codes []KeyCode, untypable []UntypableChar) {
+	line, col := 1, 0
+	for _, ch := range text {
+		col++
+		if ch == '\r' {
+			continue
+		} else if seq, ok := l[ch]; ok {
+			codes = append(codes, seq...)
+		} else {
+			untypable = append(untypable, UntypableChar{Char: ch, Line: line, Col: col})
+		}
+		if ch == '\n' {
+			line, col = line+1, 0
+		}
+	}
+	return codes, untypable
+}
+
+func (l Layout) with(overrides Layout) Layout {
+	res := make(Layout, len(l)+len(overrides))
+	for ch, seq := range l {
+		res[ch] = seq
+	}
+	for ch, seq := range overrides {
+		if seq == nil {
+			delete(res, ch)
+		} else {
+			res[ch] = seq
+		}
+	}
+	return res
+}
+
+func key(ks ...Key) []KeyCode {
+	var code KeyCode
+	copy(code[:], ks)
+	return []KeyCode{code}
+}
+
+func shift(k Key) []KeyCode { return key(K_L_SHIFT, k) }
+func altgr(k Key) []KeyCode { return key(K_R_ALT, k) }
+func dead(seq []KeyCode) []KeyCode {
+	return append(seq, KeyCode{K_SPACE})
+}
+
+var layoutUS = func() Layout {
+	res := Layout{
+		'\t': key(K_TAB),
+		'\n': key(K_ENTER),
+	}
+	for ch := byte(0x20); ch < 0x7f; ch++ {
+		res[rune(ch)] = []KeyCode{asciiKeyCodes[ch]}
+	}
+	return res
+}()
+
+var layoutUK = layoutUS.with(Layout{
+	'"':  shift(K_2),
+	'@':  shift(K_APOSTROPHE),
+	'£':  shift(K_3),
+	'#':  key(K_NONUS_HASH),
+	'~':  shift(K_NONUS_HASH),
+	'\\': key(K_NONUS_BACKSLASH),
+	'|':  shift(K_NONUS_BACKSLASH),
+	'¬':  shift(K_GRAVE),
+	'€':  altgr(K_4),
+})
+
+var layoutDE = layoutUS.with(Layout{
+	'y': key(K_Z), 'Y': shift(K_Z),
+	'z': key(K_Y), 'Z': shift(K_Y),
+	'"': shift(K_2), '§': shift(K_3), '&': shift(K_6), '/': shift(K_7),
+	'(': shift(K_8), ')': shift(K_9), '=': shift(K_0),
+	'ß': key(K_MINUS), '?': shift(K_MINUS), '\\': altgr(K_MINUS),
+	'´': dead(key(K_EQUAL)), '`': dead(shift(K_EQUAL)),
+	'ü': key(K_LEFTBRACE), 'Ü': shift(K_LEFTBRACE),
+	'+': key(K_RIGHTBRACE), '*': shift(K_RIGHTBRACE), '~': altgr(K_RIGHTBRACE),
+	'ö': key(K_SEMICOLON), 'Ö': shift(K_SEMICOLON),
+	'ä': key(K_APOSTROPHE), 'Ä': shift(K_APOSTROPHE),
+	'#': key(K_NONUS_HASH), '\'': shift(K_NONUS_HASH),
+	'^': dead(key(K_GRAVE)), '°': shift(K_GRAVE),
+	';': shift(K_COMMA), ':': shift(K_DOT),
+	'-': key(K_SLASH), '_': shift(K_SLASH),
+	'<': key(K_NONUS_BACKSLASH), '>': shift(K_NONUS_BACKSLASH), '|': altgr(K_NONUS_BACKSLASH),
+	'@': altgr(K_Q), '€': altgr(K_E), 'µ': altgr(K_M), '²': altgr(K_2), '³': altgr(K_3),
+	'{': altgr(K_7), '[': altgr(K_8), ']': altgr(K_9), '}': altgr(K_0),
+})
+
+var layoutFR = layoutUS.with(Layout{
+	'a': key(K_Q), 'A': shift(K_Q),
+	'q': key(K_A), 'Q': shift(K_A),
+	'z': key(K_W), 'Z': shift(K_W),
+	'w': key(K_Z), 'W': shift(K_Z),
+	'm': key(K_SEMICOLON), 'M': shift(K_SEMICOLON),
+	'&': key(K_1), 'é': key(K_2), '"': key(K_3), '\'': key(K_4), '(': key(K_5),
+	'-': key(K_6), 'è': key(K_7), '_': key(K_8), 'ç': key(K_9), 'à': key(K_0),
+	'1': shift(K_1), '2': shift(K_2), '3': shift(K_3), '4': shift(K_4), '5': shift(K_5),
+	'6': shift(K_6), '7': shift(K_7), '8': shift(K_8), '9': shift(K_9), '0': shift(K_0),
+	')': key(K_MINUS), '°': shift(K_MINUS), '=': key(K_EQUAL), '+': shift(K_EQUAL),
+	'~': dead(altgr(K_2)), '#': altgr(K_3), '{': altgr(K_4), '[': altgr(K_5), '|': altgr(K_6),
+	'`': dead(altgr(K_7)), '\\': altgr(K_8), '^': altgr(K_9), '@': altgr(K_0), ']': altgr(K_MINUS), '}': altgr(K_EQUAL),
+	'$': key(K_RIGHTBRACE), '£': shift(K_RIGHTBRACE), '€': altgr(K_E),
+	'ù': key(K_APOSTROPHE), '%': shift(K_APOSTROPHE),
+	'*': key(K_NONUS_HASH), 'µ': shift(K_NONUS_HASH), '²': key(K_GRAVE),
+	',': key(K_M), '?': shift(K_M),
+	';': key(K_COMMA), '.': shift(K_COMMA),
+	':': key(K_DOT), '/': shift(K_DOT),
+	'!': key(K_SLASH), '§': shift(K_SLASH),
+	'<': key(K_NONUS_BACKSLASH), '>': shift(K_NONUS_BACKSLASH),
+})
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestLayoutEncode(t *testing.T) {
+	var tests = []struct {
+		layout    string
+		input     string
+		want      []KeyCode
+		untypable []UntypableChar
+	}{
+		{"us", "aZ!\r\n", []KeyCode{{K_A}, {K_L_SHIFT, K_Z}, {K_L_SHIFT, K_1}, {K_ENTER}}, nil},
+		{"uk", "\"#", []KeyCode{{K_L_SHIFT, K_2}, {K_NONUS_HASH}}, nil},
+		{"de", "zy@^", []KeyCode{{K_Y}, {K_Z}, {K_R_ALT, K_Q}, {K_GRAVE}, {K_SPACE}}, nil},
+		{"fr", "aq1", []KeyCode{{K_Q}, {K_A}, {K_L_SHIFT, K_1}}, nil},
+		{"us", "a\nbé", []KeyCode{{K_A}, {K_ENTER}, {K_B}}, []UntypableChar{{'é', 2, 2}}},
+	}
+	for _, test := range tests {
+		layout, err := FindLayout(test.layout)
+		if err != nil {
+			t.Fatalf("FindLayout(%q) error: %v", test.layout, err)
+		}
+		got, untypable := layout.Encode(test.input)
+		if !slices.Equal(got, test.want) || !slices.Equal(untypable, test.untypable) {
+			t.Errorf("Layout(%s).Encode(%q) = %v, %v; want %v, %v", test.layout, test.input, got, untypable, test.want, test.untypable)
+		}
+	}
+}
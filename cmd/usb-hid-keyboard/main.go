@@ -10,22 +10,38 @@ import (
 	"github.com/whoisnian/glb/ansi"
 	"github.com/whoisnian/glb/config"
 	"github.com/whoisnian/glb/logger"
-	"github.com/whoisnian/misc/pkg/serial"
 	"golang.org/x/sys/unix"
 	"golang.org/x/term"
 )
 
 var CFG struct {
-	Debug   bool   `flag:"d,false,Enable debug output"`
-	Test    bool   `flag:"t,false,Run in test mode without sending keycodes"`
-	Device  string `flag:"dev,/dev/ttyUSB0,Serial device to use"`
-	Encoder string `flag:"enc,ch9329,Encoder for keycodes, ch9329 or kcom3"`
+	Debug    bool          `flag:"d,false,Enable debug output"`
+	Test     bool          `flag:"t,false,Run in test mode without sending keycodes"`
+	Device   string        `flag:"dev,/dev/ttyUSB0,Serial device to use, or gadget device like /dev/hidg0 for hidg encoder"`
+	Baud     int           `flag:"baud,9600,Baud rate of serial device"`
+	Encoder  string        `flag:"enc,ch9329,Encoder for keycodes, ch9329, kcom3 or hidg"`
+	Interval time.Duration `flag:"i,50ms,Interval between two keycodes sent to serial device"`
+
+	Cmd string `flag:"cmd,,Run ch9329 command with arguments: info, get-config, set-config, get-string, set-string, default-config or reset"`
+
+	Type   string `flag:"type,,Type text from file (or - for stdin) instead of reading keys from terminal"`
+	Script string `flag:"script,,Run keyboard macro script from file (or - for stdin), print HID reports only in test mode"`
+	Layout string `flag:"layout,us,Keyboard layout of the target for typing text, us, uk, de or fr"`
+
+	Mouse     bool `flag:"m,false,Forward terminal mouse events and arrow keys as mouse reports (ch9329 only)"`
+	MouseStep int  `flag:"mouse-step,10,Relative mouse movement in pixels for each arrow key"`
+	Kitty     bool `flag:"kitty,true,Enable kitty keyboard protocol if supported by the terminal"`
+
+	Httpd  string `flag:"httpd,,Run KVM http server on [user:pass@]addr, forward key events from browser over websocket"`
+	Keymap string `flag:"keymap,,Load combo mode keymap from YAML or JSON file instead of the built-in one"`
+	Evdev  string `flag:"evdev,,Pass through local keyboard from evdev device, e.g. /dev/input/event0"`
+	Grab   bool   `flag:"grab,false,Grab evdev device exclusively, so that local system does not receive its key events"`
 }
 
 var LOG *logger.Logger
 
-func setupConfigAndLogger(_ context.Context) {
-	_, err := config.FromCommandLine(&CFG)
+func setupConfigAndLogger(_ context.Context) (args []string) {
+	args, err := config.FromCommandLine(&CFG)
 	if err != nil {
 		panic(err)
 	}
@@ -38,110 +54,481 @@ func setupConfigAndLogger(_ context.Context) {
 		Colorful:  ansi.IsSupported(os.Stderr.Fd()),
 		AddSource: CFG.Debug,
 	}))
+	return args
 }
 
 func main() {
 	ctx := context.Background()
-	setupConfigAndLogger(ctx)
+	args := setupConfigAndLogger(ctx)
 	LOG.Debugf(ctx, "use config: %+v", CFG)
+	if CFG.Keymap != "" {
+		loadKeymap(ctx)
+	}
 
-	if CFG.Test {
+	if CFG.Cmd != "" {
+		runCommandMode(ctx, args)
+	} else if CFG.Script != "" {
+		runScriptMode(ctx)
+	} else if CFG.Evdev != "" {
+		runEvdevMode(ctx)
+	} else if CFG.Httpd != "" {
+		runHTTPMode(ctx)
+	} else if CFG.Type != "" {
+		runTypeMode(ctx)
+	} else if CFG.Test {
 		runTestMode(ctx)
 	} else {
 		runCliMode(ctx)
 	}
 }
 
-func runTestMode(ctx context.Context) {
-	fd := int(os.Stdin.Fd())
+// setupRawTerminal puts the terminal into raw mode, and enables mouse tracking and kitty keyboard protocol if required.
+func setupRawTerminal(ctx context.Context, fd int) (parser *VTParser, restore func()) {
 	oldState, err := term.MakeRaw(fd)
 	if err != nil {
 		LOG.Fatalf(ctx, "failed to set terminal to raw mode: %v", err)
 	}
-	defer term.Restore(fd, oldState)
+	parser = NewVTParser()
+	kitty := CFG.Kitty && detectKittyKeyboard(fd, parser)
+	if kitty {
+		fmt.Print(kittyKeyboardEnable)
+	}
+	if CFG.Mouse {
+		fmt.Print(mouseTrackingEnable)
+	}
+	return parser, func() {
+		if CFG.Mouse {
+			fmt.Print(mouseTrackingDisable)
+		}
+		if kitty {
+			fmt.Print(kittyKeyboardDisable)
+		}
+		term.Restore(fd, oldState)
+	}
+}
+
+func loadKeymap(ctx context.Context) {
+	layout, err := FindLayout(CFG.Layout)
+	if err != nil {
+		LOG.Fatalf(ctx, "%v", err)
+	}
+	data, err := os.ReadFile(CFG.Keymap)
+	if err != nil {
+		LOG.Fatalf(ctx, "failed to read keymap from %s: %v", CFG.Keymap, err)
+	}
+	if comboKeymap, err = ParseKeymap(data, layout); err != nil {
+		LOG.Fatalf(ctx, "invalid keymap %s: %v", CFG.Keymap, err)
+	}
+	LOG.Infof(ctx, "loaded keymap %s with prefix %s and %d bindings", CFG.Keymap, comboKeymap.Prefix, len(comboKeymap.Bindings))
+}
 
-	var buf [8]byte
-	var code KeyCode
+func runTestMode(ctx context.Context) {
+	fd := int(os.Stdin.Fd())
+	parser, restore := setupRawTerminal(ctx, fd)
+	defer restore()
+	if parser.KittyFlags >= 0 {
+		fmt.Printf("kitty keyboard protocol enabled\r\n")
+	}
+
+	var buf [1024]byte
 	isCombo := false
-	isExit := false
+	tracker := NewMouseTracker(CFG.MouseStep)
 	for {
 		n, err := unix.Read(fd, buf[:])
+		if err != nil {
+			fmt.Printf("err: %s%v%s\r\n", ansi.RedFG, err, ansi.Reset)
+			return
+		} else if n == 0 {
+			return
+		}
 		fmt.Printf("ori: %s%x%s\r\n", ansi.BlueFG, buf[:n], ansi.Reset)
-		code, isCombo, isExit = DecodeFromCli(buf[:n], isCombo)
-		if isCombo {
-			fmt.Printf("res: %sComboMode%s\r\n", ansi.GreenFG, ansi.Reset)
-		} else {
-			fmt.Printf("res: %s%s%s\r\n", ansi.GreenFG, code, ansi.Reset)
-			if isExit {
-				break
+		for _, ev := range parser.Feed(buf[:n]) {
+			if ev.Mouse != nil {
+				cols, rows, _ := term.GetSize(fd)
+				if report, ok := tracker.DecodeSGR(ev.Mouse, cols, rows); ok {
+					fmt.Printf("res: %s%s%s\r\n", ansi.GreenFG, report, ansi.Reset)
+				}
+				continue
+			} else if ev.Release || isModifierOnly(ev.Code) {
+				action := "press"
+				if ev.Release {
+					action = "release"
+				}
+				fmt.Printf("res: %s%s (%s)%s\r\n", ansi.GreenFG, ev.Code, action, ansi.Reset)
+				continue
+			} else if CFG.Mouse {
+				if report, ok := tracker.Move(ev.Code); ok {
+					fmt.Printf("res: %s%s%s\r\n", ansi.GreenFG, report, ansi.Reset)
+					continue
+				}
 			}
-		}
 
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			fmt.Printf("err: %s%v%s\r\n", ansi.RedFG, err, ansi.Reset)
-			break
+			var script Script
+			var isExit bool
+			if script, isCombo, isExit = comboKeymap.Check(ev.Code, isCombo); isCombo {
+				fmt.Printf("res: %sComboMode%s\r\n", ansi.GreenFG, ansi.Reset)
+			} else if isExit {
+				fmt.Printf("res: %sExit%s\r\n", ansi.GreenFG, ansi.Reset)
+				return
+			} else if len(script) == 0 {
+				fmt.Printf("res: %s%s%s\r\n", ansi.GreenFG, EmptyKeyCode, ansi.Reset)
+			} else {
+				script.Run(func(code KeyCode) {
+					if code != EmptyKeyCode {
+						fmt.Printf("res: %s%s%s\r\n", ansi.GreenFG, code, ansi.Reset)
+					}
+				}, func(d time.Duration) {
+					fmt.Printf("res: %ssleep %s%s\r\n", ansi.YellowFG, d, ansi.Reset)
+				})
+			}
 		}
 	}
 }
 
-func runCliMode(ctx context.Context) {
-	var encodeFunc EncodeFunc
-	switch CFG.Encoder {
-	case "ch9329":
-		encodeFunc = EncodeForCH9329
-	case "kcom3":
-		encodeFunc = EncodeForKCOM3
-	default:
-		LOG.Fatalf(ctx, "unknown encoder %q, must be ch9329 or kcom3", CFG.Encoder)
+func selectEncoder(ctx context.Context) Encoder {
+	enc, err := FindEncoder(CFG.Encoder)
+	if err != nil {
+		LOG.Fatalf(ctx, "%v", err)
+	}
+	return enc
+}
+
+func openDevice(ctx context.Context, enc Encoder) Device {
+	dev, err := enc.Open()
+	if err != nil {
+		LOG.Fatalf(ctx, "failed to open device %s: %v", CFG.Device, err)
+	}
+	dev.SetInterval(CFG.Interval)
+	return dev
+}
+
+func runCommandMode(ctx context.Context, args []string) {
+	if CFG.Encoder != "ch9329" {
+		LOG.Fatalf(ctx, "command mode is only supported by ch9329 encoder")
 	}
+	dev := openDevice(ctx, selectEncoder(ctx))
+	defer dev.Close()
 
-	ttyPort, err := serial.Open(CFG.Device, 9600, 8, serial.ParityNone, serial.StopBits1)
+	output, err := RunCH9329Command(NewCH9329Client(dev), CFG.Cmd, args)
 	if err != nil {
-		LOG.Fatalf(ctx, "failed to open serial port %s: %v", CFG.Device, err)
+		LOG.Fatalf(ctx, "ch9329 command %s failed: %v", CFG.Cmd, err)
 	}
-	defer ttyPort.Close()
+	fmt.Println(output)
+}
 
-	ttyPort.SetInterval(time.Millisecond * 50)
-	stop := ttyPort.GoWaitAndSend()
+func readFileOrStdin(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
 
-	fd := int(os.Stdin.Fd())
-	oldState, err := term.MakeRaw(fd)
+func runScriptMode(ctx context.Context) {
+	layout, err := FindLayout(CFG.Layout)
 	if err != nil {
-		LOG.Fatalf(ctx, "failed to set terminal to raw mode: %v", err)
+		LOG.Fatalf(ctx, "%v", err)
+	}
+	data, err := readFileOrStdin(CFG.Script)
+	if err != nil {
+		LOG.Fatalf(ctx, "failed to read script from %s: %v", CFG.Script, err)
+	}
+	script, err := ParseScript(string(data), layout)
+	if err != nil {
+		LOG.Fatalf(ctx, "failed to parse script %s: %v", CFG.Script, err)
 	}
-	defer term.Restore(fd, oldState)
 
-	var buf [8]byte
-	var code KeyCode
-	isCombo := false
-	isExit := false
+	enc := selectEncoder(ctx)
+	if CFG.Test {
+		sender := newKeySender(ctx, enc, func(b []byte) { fmt.Printf("%s% X%s ", ansi.BlueFG, b, ansi.Reset) })
+		script.Run(func(code KeyCode) {
+			sender.Send(code)
+			fmt.Println(code)
+		}, func(d time.Duration) {
+			fmt.Printf("%ssleep %s%s\n", ansi.YellowFG, d, ansi.Reset)
+		})
+		return
+	}
+
+	dev := openDevice(ctx, enc)
+	defer dev.Close()
+	watchResponses(ctx, enc, dev)
+
+	// send synchronously to keep the timing of sleep and hold steps
+	sender := newKeySender(ctx, enc, func(b []byte) {
+		if _, err := dev.Write(b); err != nil {
+			LOG.Fatalf(ctx, "failed to write device %s: %v", CFG.Device, err)
+		}
+		time.Sleep(CFG.Interval)
+	})
+	script.Run(sender.Send, time.Sleep)
+	LOG.Infof(ctx, "script %s finished", CFG.Script)
+}
+
+func runTypeMode(ctx context.Context) {
+	layout, err := FindLayout(CFG.Layout)
+	if err != nil {
+		LOG.Fatalf(ctx, "%v", err)
+	}
+	data, err := readFileOrStdin(CFG.Type)
+	if err != nil {
+		LOG.Fatalf(ctx, "failed to read text from %s: %v", CFG.Type, err)
+	}
+
+	codes, untypable := layout.Encode(string(data))
+	for _, c := range untypable {
+		LOG.Warnf(ctx, "unable to type %s with layout %s, skipped", c, CFG.Layout)
+	}
+	if CFG.Test {
+		for _, code := range codes {
+			fmt.Printf("res: %s%s%s\n", ansi.GreenFG, code, ansi.Reset)
+		}
+		return
+	}
+
+	enc := selectEncoder(ctx)
+	dev := openDevice(ctx, enc)
+	defer dev.Close()
+	watchResponses(ctx, enc, dev)
+
+	stop := dev.GoWaitAndSend()
+	sender := newKeySender(ctx, enc, dev.Push)
+	for _, code := range codes {
+		sender.Send(code)
+		sender.SendReport(EmptyReport)
+	}
+	stop()
+	LOG.Infof(ctx, "typed %d keycodes, skipped %d characters", len(codes), len(untypable))
+}
+
+func runHTTPMode(ctx context.Context) {
+	enc := selectEncoder(ctx)
+	send := func(r Report) {
+		fmt.Printf("res: %s%s%s\n", ansi.GreenFG, r, ansi.Reset)
+	}
+	if !CFG.Test {
+		dev := openDevice(ctx, enc)
+		defer dev.Close()
+		watchResponses(ctx, enc, dev)
+		stop := dev.GoWaitAndSend()
+		defer stop()
+		sender := newKeySender(ctx, enc, dev.Push)
+		send = func(r Report) {
+			LOG.Debugf(ctx, "send report: %s", r)
+			sender.SendReport(r)
+		}
+	}
+
+	if err := runHTTPServer(ctx, CFG.Httpd, send); err != nil {
+		LOG.Fatalf(ctx, "http server failed: %v", err)
+	}
+}
+
+// keySender encodes keycodes and writes them to target, media keys are sent with separate media reports.
+type keySender struct {
+	ctx   context.Context
+	enc   Encoder
+	write func([]byte)
+	media Key // media key held on target
+}
+
+func newKeySender(ctx context.Context, enc Encoder, write func([]byte)) *keySender {
+	return &keySender{ctx: ctx, enc: enc, write: write}
+}
+
+// SendReport sends the keyboard report, and releases the held media key first.
+func (s *keySender) SendReport(r Report) {
+	if s.media != 0 {
+		s.write(s.enc.Media(s.media, false))
+		s.media = 0
+	}
+	s.write(s.enc.Keyboard(r))
+}
+
+// Send sends the keycode as keyboard report, or as media report if it contains a media key.
+func (s *keySender) Send(code KeyCode) {
+	k := code.MediaKey()
+	if k == 0 {
+		s.SendReport(code.Report())
+		return
+	}
+	data := s.enc.Media(k, true)
+	if data == nil {
+		LOG.Warnf(s.ctx, "media key %s is not supported by %s encoder, skipped", k, CFG.Encoder)
+		return
+	}
+	if s.media != 0 && s.media != k {
+		s.write(s.enc.Media(s.media, false))
+	}
+	s.media = k
+	s.write(data)
+}
+
+// keyForwarder sends the key events to target, and handles the combo mode.
+// Sources with real press and release events go through the key state, so keys can be held and auto-repeated by target.
+// Other sources and combo keycodes are sent as tap pairs of press and release.
+type keyForwarder struct {
+	state   KeyState
+	isCombo bool
+	sender  *keySender
+}
+
+// Event handles the press or release event of key combination, and returns true if exit is triggered.
+func (f *keyForwarder) Event(code KeyCode, release bool) (isExit bool) {
+	if code.MediaKey() != 0 { // media keys can not be held, just tap on press
+		if !release {
+			f.sender.Send(code)
+			f.sender.SendReport(f.state.Report())
+		}
+		return false
+	} else if release || isModifierOnly(code) {
+		if !f.isCombo {
+			if r, changed := f.state.Update(code, release); changed {
+				f.sender.SendReport(r)
+			}
+		}
+		return false
+	}
+	if chord := f.state.Chord(code); f.isCombo || chord == comboKeymap.Prefix {
+		return f.Tap(chord)
+	}
+	if r, changed := f.state.Update(code, false); changed {
+		f.sender.SendReport(r)
+	}
+	return false
+}
+
+// Tap sends the key combination as press and release pair, or the steps bound in combo mode, and returns true if exit is triggered.
+func (f *keyForwarder) Tap(code KeyCode) (isExit bool) {
+	var script Script
+	if script, f.isCombo, isExit = comboKeymap.Check(code, f.isCombo); f.isCombo {
+		fmt.Printf("res: %sComboMode%s\r\n", ansi.GreenFG, ansi.Reset)
+		if f.state.ReleaseAll() { // keys held before combo mode will not be released by target otherwise
+			f.sender.SendReport(EmptyReport)
+		}
+		return false
+	}
+	if isExit {
+		fmt.Printf("res: %sExit%s\r\n", ansi.GreenFG, ansi.Reset)
+		return true
+	} else if len(script) == 0 {
+		fmt.Printf("res: %s%s%s\r\n", ansi.GreenFG, EmptyKeyCode, ansi.Reset)
+		return false
+	}
+	script.Run(func(code KeyCode) {
+		if code == EmptyKeyCode {
+			f.sender.SendReport(f.state.Report()) // keep the keys still held by source
+			return
+		}
+		fmt.Printf("res: %s%s%s\r\n", ansi.GreenFG, code, ansi.Reset)
+		f.sender.Send(code)
+	}, time.Sleep)
+	return false
+}
+
+func runEvdevMode(ctx context.Context) {
+	enc := selectEncoder(ctx)
+	forwarder := &keyForwarder{sender: newKeySender(ctx, enc, func(b []byte) {
+		fmt.Printf("%s% X%s\r\n", ansi.BlueFG, b, ansi.Reset)
+	})}
+	if !CFG.Test {
+		dev := openDevice(ctx, enc)
+		defer dev.Close()
+		watchResponses(ctx, enc, dev)
+		stop := dev.GoWaitAndSend()
+		defer stop()
+		forwarder.sender = newKeySender(ctx, enc, dev.Push)
+	}
+	defer func() {
+		if forwarder.state.ReleaseAll() {
+			forwarder.sender.SendReport(EmptyReport)
+		}
+	}()
+
+	kb, err := OpenEvdevKeyboard(CFG.Evdev, CFG.Grab)
+	if err != nil {
+		LOG.Fatalf(ctx, "failed to open evdev device %s: %v", CFG.Evdev, err)
+	}
+	defer kb.Close()
+	LOG.Infof(ctx, "reading key events from %s, press Ctrl+K and Q to exit", CFG.Evdev)
+
+	for {
+		k, release, err := kb.ReadKey()
+		if err != nil {
+			LOG.Errorf(ctx, "failed to read evdev device %s: %v", CFG.Evdev, err)
+			return
+		} else if k == 0 {
+			continue
+		}
+		if forwarder.Event(KeyCode{k}, release) {
+			return
+		}
+	}
+}
+
+func runCliMode(ctx context.Context) {
+	enc := selectEncoder(ctx)
+	if CFG.Mouse && enc.Mouse(MouseReport{}) == nil {
+		LOG.Fatalf(ctx, "mouse mode is not supported by %s encoder", CFG.Encoder)
+	}
+
+	dev := openDevice(ctx, enc)
+	defer dev.Close()
+	watchResponses(ctx, enc, dev)
+
+	stop := dev.GoWaitAndSend()
+	defer stop()
+
+	fd := int(os.Stdin.Fd())
+	parser, restore := setupRawTerminal(ctx, fd)
+	defer restore()
+
+	// key releases are only reported with kitty keyboard protocol, otherwise each key is sent as a tap pair
+	kitty := parser.KittyFlags >= 0
+	sender := newKeySender(ctx, enc, dev.Push)
+	forwarder := &keyForwarder{sender: sender}
+	defer func() {
+		if forwarder.state.ReleaseAll() {
+			sender.SendReport(EmptyReport)
+		}
+	}()
+
+	var buf [1024]byte
+	tracker := NewMouseTracker(CFG.MouseStep)
 	for {
 		n, err := unix.Read(fd, buf[:])
+		if err != nil {
+			fmt.Printf("err: %s%v%s\r\n", ansi.RedFG, err, ansi.Reset)
+			return
+		} else if n == 0 {
+			return
+		}
 		if CFG.Debug {
 			fmt.Printf("ori: %s%x%s\r\n", ansi.BlueFG, buf[:n], ansi.Reset)
 		}
-		code, isCombo, isExit = DecodeFromCli(buf[:n], isCombo)
-		if isCombo {
-			fmt.Printf("res: %sComboMode%s\r\n", ansi.GreenFG, ansi.Reset)
-		} else {
-			fmt.Printf("res: %s%s%s\r\n", ansi.GreenFG, code, ansi.Reset)
-			if isExit {
-				break
-			}
-			if res := encodeFunc(code); len(res) > 0 {
-				ttyPort.Push(res)
-				ttyPort.Push(encodeFunc(EmptyKeyCode))
+		for _, ev := range parser.Feed(buf[:n]) {
+			if ev.Mouse != nil {
+				cols, rows, _ := term.GetSize(fd)
+				if report, ok := tracker.DecodeSGR(ev.Mouse, cols, rows); ok {
+					if CFG.Debug {
+						fmt.Printf("res: %s%s%s\r\n", ansi.GreenFG, report, ansi.Reset)
+					}
+					dev.Push(enc.Mouse(report))
+				}
+				continue
+			} else if ev.Code == EmptyKeyCode {
+				continue
+			} else if CFG.Mouse && !ev.Release {
+				if report, ok := tracker.Move(ev.Code); ok {
+					dev.Push(enc.Mouse(report))
+					continue
+				}
 			}
-		}
 
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			fmt.Printf("err: %s%v%s\r\n", ansi.RedFG, err, ansi.Reset)
-			break
+			if kitty && forwarder.Event(ev.Code, ev.Release) {
+				return
+			} else if !kitty && !ev.Release && !isModifierOnly(ev.Code) && forwarder.Tap(ev.Code) {
+				return
+			}
 		}
 	}
-	stop()
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type MouseButton byte
+
+const (
+	MB_LEFT MouseButton = 1 << iota
+	MB_RIGHT
+	MB_MIDDLE
+)
+
+// MouseReport describes a single mouse movement.
+// For absolute reports, X and Y range from 0 to MouseAbsMax and point to the position on screen.
+// For relative reports, X and Y range from -127 to 127 and are the offset from current position.
+// Wheel ranges from -127 to 127, positive for scrolling up and negative for scrolling down.
+type MouseReport struct {
+	Absolute bool
+	Buttons  MouseButton
+	X, Y     int
+	Wheel    int
+}
+
+const MouseAbsMax = 4095
+
+func (r MouseReport) String() string {
+	var buttons []string
+	if r.Buttons&MB_LEFT != 0 {
+		buttons = append(buttons, "LEFT")
+	}
+	if r.Buttons&MB_RIGHT != 0 {
+		buttons = append(buttons, "RIGHT")
+	}
+	if r.Buttons&MB_MIDDLE != 0 {
+		buttons = append(buttons, "MIDDLE")
+	}
+	mode := "REL"
+	if r.Absolute {
+		mode = "ABS"
+	}
+	return fmt.Sprintf("MOUSE %s (%d,%d) wheel=%d buttons=[%s]", mode, r.X, r.Y, r.Wheel, strings.Join(buttons, " + "))
+}
+
+func clampInt8(v int) byte {
+	return byte(int8(max(-127, min(127, v))))
+}
+
+// Absolute mouse: 57 AB 00 04 07 02 BUTTONS X_L X_H Y_L Y_H WHEEL SUM
+// Relative mouse: 57 AB 00 05 05 01 BUTTONS DX DY WHEEL SUM
+func EncodeMouseForCH9329(r MouseReport) []byte {
+	if r.Absolute {
+		x := uint16(max(0, min(MouseAbsMax, r.X)))
+		y := uint16(max(0, min(MouseAbsMax, r.Y)))
+		return ch9329Frame(CH9329_CMD_SEND_MS_ABS_DATA, 0x02, byte(r.Buttons), byte(x), byte(x>>8), byte(y), byte(y>>8), clampInt8(r.Wheel))
+	}
+	return ch9329Frame(CH9329_CMD_SEND_MS_REL_DATA, 0x01, byte(r.Buttons), clampInt8(r.X), clampInt8(r.Y), clampInt8(r.Wheel))
+}
+
+// Sequences to enable or disable the xterm mouse tracking with SGR extended coordinates.
+// https://invisible-island.net/xterm/ctlseqs/ctlseqs.html#h2-Mouse-Tracking
+const (
+	mouseTrackingEnable  = "\x1b[?1003h\x1b[?1006h"
+	mouseTrackingDisable = "\x1b[?1006l\x1b[?1003l"
+)
+
+// MouseTracker converts terminal mouse events into mouse reports.
+// It remembers the pressed buttons, so that motion and wheel reports keep the buttons held.
+type MouseTracker struct {
+	buttons MouseButton
+	step    int
+}
+
+func NewMouseTracker(step int) *MouseTracker {
+	return &MouseTracker{step: step}
+}
+
+// Move converts arrow keys into relative movements with the configured step.
+func (t *MouseTracker) Move(code KeyCode) (res MouseReport, ok bool) {
+	switch code {
+	case KeyCode{K_UP}:
+		return MouseReport{Buttons: t.buttons, Y: -t.step}, true
+	case KeyCode{K_DOWN}:
+		return MouseReport{Buttons: t.buttons, Y: t.step}, true
+	case KeyCode{K_RIGHT}:
+		return MouseReport{Buttons: t.buttons, X: t.step}, true
+	case KeyCode{K_LEFT}:
+		return MouseReport{Buttons: t.buttons, X: -t.step}, true
+	}
+	return res, false
+}
+
+// The terminal sends SGR mouse events as `ESC [ < Cb ; Cx ; Cy M` on press or motion, and `ESC [ < Cb ; Cx ; Cy m` on release.
+// The position (Cx,Cy) is 1-based and will be scaled into the absolute range by the terminal size (cols,rows).
+func (t *MouseTracker) DecodeSGR(buf []byte, cols, rows int) (res MouseReport, ok bool) {
+	if len(buf) < 9 || buf[0] != 0x1b || buf[1] != 0x5b || buf[2] != '<' {
+		return res, false
+	}
+	final := buf[len(buf)-1]
+	if final != 'M' && final != 'm' {
+		return res, false
+	}
+	params := strings.Split(string(buf[3:len(buf)-1]), ";")
+	if len(params) != 3 {
+		return res, false
+	}
+	cb, err1 := strconv.Atoi(params[0])
+	cx, err2 := strconv.Atoi(params[1])
+	cy, err3 := strconv.Atoi(params[2])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return res, false
+	}
+
+	res = MouseReport{Absolute: true, X: scaleToAbs(cx, cols), Y: scaleToAbs(cy, rows)}
+	if cb&64 != 0 { // wheel
+		if cb&1 == 0 {
+			res.Wheel = 1
+		} else {
+			res.Wheel = -1
+		}
+	} else if cb&32 == 0 { // press or release
+		var button MouseButton
+		switch cb & 3 {
+		case 0:
+			button = MB_LEFT
+		case 1:
+			button = MB_MIDDLE
+		case 2:
+			button = MB_RIGHT
+		}
+		if final == 'M' {
+			t.buttons |= button
+		} else {
+			t.buttons &^= button
+		}
+	}
+	res.Buttons = t.buttons
+	return res, true
+}
+
+func scaleToAbs(pos, size int) int {
+	if size <= 1 {
+		return 0
+	}
+	return max(0, min(MouseAbsMax, (pos-1)*MouseAbsMax/(size-1)))
+}
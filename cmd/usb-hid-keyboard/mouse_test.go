@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeMouseForCH9329(t *testing.T) {
+	var tests = []struct {
+		input MouseReport
+		want  []byte
+	}{
+		{MouseReport{Absolute: true, Buttons: MB_LEFT, X: 4095, Y: 2048, Wheel: 1}, []byte{0x57, 0xAB, 0x00, 0x04, 0x07, 0x02, 0x01, 0xFF, 0x0F, 0x00, 0x08, 0x01, 0x27}},
+		{MouseReport{Absolute: true, X: -5, Y: 5000, Wheel: -200}, []byte{0x57, 0xAB, 0x00, 0x04, 0x07, 0x02, 0x00, 0x00, 0x00, 0xFF, 0x0F, 0x81, 0x9E}},
+		{MouseReport{Buttons: MB_RIGHT, X: 10, Y: -10, Wheel: -1}, []byte{0x57, 0xAB, 0x00, 0x05, 0x05, 0x01, 0x02, 0x0A, 0xF6, 0xFF, 0x0E}},
+		{MouseReport{Buttons: MB_LEFT | MB_MIDDLE, X: 300, Y: -300, Wheel: 127}, []byte{0x57, 0xAB, 0x00, 0x05, 0x05, 0x01, 0x05, 0x7F, 0x81, 0x7F, 0x91}},
+	}
+	for _, test := range tests {
+		if got := EncodeMouseForCH9329(test.input); !bytes.Equal(got, test.want) {
+			t.Errorf("EncodeMouseForCH9329(%s) = % X; want % X", test.input, got, test.want)
+		}
+	}
+}
+
+func TestMouseTrackerDecodeSGR(t *testing.T) {
+	tracker := NewMouseTracker(10)
+	var tests = []struct {
+		input string
+		want  MouseReport
+		ok    bool
+	}{
+		{"\x1b[<0;1;1M", MouseReport{Absolute: true, Buttons: MB_LEFT}, true},                                // left press at top left
+		{"\x1b[<32;80;24M", MouseReport{Absolute: true, Buttons: MB_LEFT, X: 4095, Y: 4095}, true},           // drag to bottom right
+		{"\x1b[<2;41;12M", MouseReport{Absolute: true, Buttons: MB_LEFT | MB_RIGHT, X: 2073, Y: 1958}, true}, // right press while left held
+		{"\x1b[<0;41;12m", MouseReport{Absolute: true, Buttons: MB_RIGHT, X: 2073, Y: 1958}, true},           // left release
+		{"\x1b[<2;41;12m", MouseReport{Absolute: true, X: 2073, Y: 1958}, true},                              // right release
+		{"\x1b[<64;90;30M", MouseReport{Absolute: true, X: 4095, Y: 4095, Wheel: 1}, true},                   // wheel up outside of terminal
+		{"\x1b[<65;0;1M", MouseReport{Absolute: true, Wheel: -1}, true},                                      // wheel down
+		{"\x1b[<1;1;1M", MouseReport{Absolute: true, Buttons: MB_MIDDLE}, true},                              // middle press
+		{"\x1b[<0;1M", MouseReport{}, false},
+		{"\x1b[<a;1;1M", MouseReport{}, false},
+		{"\x1b[<0;10;10X", MouseReport{}, false},
+		{"\x1b[A", MouseReport{}, false},
+	}
+	for _, test := range tests {
+		got, ok := tracker.DecodeSGR([]byte(test.input), 80, 24)
+		if ok != test.ok || got != test.want {
+			t.Errorf("DecodeSGR(%q) = %s, %v; want %s, %v", test.input, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestScaleToAbs(t *testing.T) {
+	var tests = []struct {
+		pos, size int
+		want      int
+	}{
+		{1, 80, 0},
+		{80, 80, MouseAbsMax},
+		{0, 80, 0},
+		{100, 80, MouseAbsMax},
+		{5, 1, 0},
+		{5, 0, 0},
+	}
+	for _, test := range tests {
+		if got := scaleToAbs(test.pos, test.size); got != test.want {
+			t.Errorf("scaleToAbs(%d, %d) = %d; want %d", test.pos, test.size, got, test.want)
+		}
+	}
+}
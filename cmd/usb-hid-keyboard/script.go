@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Script is a sequence of steps to be sent to the target, parsed from the macro script like:
+//
+//	# switch to tty2 and login as root
+//	press ctrl+alt+f2
+//	sleep 2s
+//	type "root\n"
+//	hold shift 500ms
+//	repeat 3 {
+//	  press tab
+//	}
+//
+// Words are separated by whitespaces, and comments start with '#' until the end of line.
+// Strings are double quoted with Go escape sequences.
+type Script []Step
+
+type StepOp int
+
+const (
+	OpPress StepOp = iota
+	OpType
+	OpHold
+	OpSleep
+	OpRepeat
+)
+
+type Step struct {
+	Op    StepOp
+	Line  int
+	Codes []KeyCode     // for press, type and hold
+	Dur   time.Duration // for hold and sleep
+	Count int           // for repeat
+	Body  Script        // for repeat
+}
+
+type scriptToken struct {
+	text   string
+	quoted bool
+	line   int
+}
+
+func tokenizeScript(src string) (tokens []scriptToken, err error) {
+	line := 1
+	for i := 0; i < len(src); {
+		switch c := src[i]; {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r' || c == ';':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '{' || c == '}':
+			tokens = append(tokens, scriptToken{text: string(c), line: line})
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(src) && src[j] != '"' && src[j] != '\n' {
+				if src[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(src) || src[j] != '"' {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			text, err := strconv.Unquote(src[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid string %s: %w", line, src[i:j+1], err)
+			}
+			tokens = append(tokens, scriptToken{text: text, quoted: true, line: line})
+			i = j + 1
+		default:
+			j := i
+			for j < len(src) && !strings.ContainsRune(" \t\r\n;#{}\"", rune(src[j])) {
+				j++
+			}
+			tokens = append(tokens, scriptToken{text: src[i:j], line: line})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+// ParseScript parses the macro script, and converts text into keycodes with the layout.
+func ParseScript(src string, layout Layout) (Script, error) {
+	tokens, err := tokenizeScript(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &scriptParser{tokens: tokens, layout: layout}
+	script, err := p.parseBlock(false)
+	if err != nil {
+		return nil, err
+	}
+	return script, nil
+}
+
+type scriptParser struct {
+	tokens []scriptToken
+	pos    int
+	layout Layout
+}
+
+func (p *scriptParser) next(what string, prev scriptToken) (scriptToken, error) {
+	if p.pos >= len(p.tokens) {
+		return scriptToken{}, fmt.Errorf("line %d: missing %s after %q", prev.line, what, prev.text)
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+func (p *scriptParser) parseBlock(nested bool) (script Script, err error) {
+	for p.pos < len(p.tokens) {
+		tok := p.tokens[p.pos]
+		p.pos++
+		if tok.text == "}" && !tok.quoted {
+			if nested {
+				return script, nil
+			}
+			return nil, fmt.Errorf("line %d: unexpected '}'", tok.line)
+		}
+
+		step := Step{Line: tok.line}
+		switch strings.ToLower(tok.text) {
+		case "press":
+			if step.Codes, err = p.parseKeyCode(tok); err != nil {
+				return nil, err
+			}
+			step.Op = OpPress
+		case "type":
+			arg, err := p.next("string", tok)
+			if err != nil {
+				return nil, err
+			} else if !arg.quoted {
+				return nil, fmt.Errorf("line %d: type requires a quoted string, got %q", arg.line, arg.text)
+			}
+			codes, untypable := p.layout.Encode(arg.text)
+			if len(untypable) > 0 {
+				return nil, fmt.Errorf("line %d: unable to type %q with current layout", arg.line, untypable[0].Char)
+			}
+			step.Op, step.Codes = OpType, codes
+		case "hold":
+			if step.Codes, err = p.parseKeyCode(tok); err != nil {
+				return nil, err
+			}
+			if step.Dur, err = p.parseDuration(tok); err != nil {
+				return nil, err
+			}
+			step.Op = OpHold
+		case "sleep":
+			if step.Dur, err = p.parseDuration(tok); err != nil {
+				return nil, err
+			}
+			step.Op = OpSleep
+		case "repeat":
+			arg, err := p.next("count", tok)
+			if err != nil {
+				return nil, err
+			}
+			if step.Count, err = strconv.Atoi(arg.text); err != nil || step.Count < 0 {
+				return nil, fmt.Errorf("line %d: invalid repeat count %q", arg.line, arg.text)
+			}
+			if brace, err := p.next("'{'", arg); err != nil {
+				return nil, err
+			} else if brace.text != "{" || brace.quoted {
+				return nil, fmt.Errorf("line %d: expect '{' after repeat count, got %q", brace.line, brace.text)
+			}
+			if step.Body, err = p.parseBlock(true); err != nil {
+				return nil, err
+			}
+			step.Op = OpRepeat
+		default:
+			return nil, fmt.Errorf("line %d: unknown command %q", tok.line, tok.text)
+		}
+		script = append(script, step)
+	}
+	if nested {
+		return nil, fmt.Errorf("missing '}' at end of script")
+	}
+	return script, nil
+}
+
+func (p *scriptParser) parseKeyCode(prev scriptToken) ([]KeyCode, error) {
+	arg, err := p.next("keys", prev)
+	if err != nil {
+		return nil, err
+	}
+	code, err := ParseKeyCode(arg.text)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", arg.line, err)
+	}
+	return []KeyCode{code}, nil
+}
+
+func (p *scriptParser) parseDuration(prev scriptToken) (time.Duration, error) {
+	arg, err := p.next("duration", prev)
+	if err != nil {
+		return 0, err
+	}
+	d, err := time.ParseDuration(arg.text)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("line %d: invalid duration %q", arg.line, arg.text)
+	}
+	return d, nil
+}
+
+// Run executes the steps by sending keycodes with send and pausing with sleep.
+// Keys are released by sending EmptyKeyCode after being pressed.
+func (s Script) Run(send func(KeyCode), sleep func(time.Duration)) {
+	for _, step := range s {
+		switch step.Op {
+		case OpPress, OpType:
+			for _, code := range step.Codes {
+				send(code)
+				send(EmptyKeyCode)
+			}
+		case OpHold:
+			send(step.Codes[0])
+			sleep(step.Dur)
+			send(EmptyKeyCode)
+		case OpSleep:
+			sleep(step.Dur)
+		case OpRepeat:
+			for range step.Count {
+				step.Body.Run(send, sleep)
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScript(t *testing.T) {
+	src := `# comment
+press ctrl+alt+f2
+type "a\n"
+hold shift 500ms
+repeat 2 { sleep 1s }
+`
+	want := Script{
+		{Op: OpPress, Line: 2, Codes: []KeyCode{{K_L_CTRL, K_L_ALT, K_F2}}},
+		{Op: OpType, Line: 3, Codes: []KeyCode{{K_A}, {K_ENTER}}},
+		{Op: OpHold, Line: 4, Codes: []KeyCode{{K_L_SHIFT}}, Dur: 500 * time.Millisecond},
+		{Op: OpRepeat, Line: 5, Count: 2, Body: Script{{Op: OpSleep, Line: 5, Dur: time.Second}}},
+	}
+	got, err := ParseScript(src, layoutUS)
+	if err != nil {
+		t.Fatalf("ParseScript() error: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseScript() = %+v; want %+v", got, want)
+	}
+}
+
+func TestParseScriptError(t *testing.T) {
+	var tests = []struct {
+		input string
+		want  string
+	}{
+		{"press", "line 1: missing keys"},
+		{"press ctrl+foo", "line 1: unknown key"},
+		{"type root", "line 1: type requires a quoted string"},
+		{"\nsleep 2", "line 2: invalid duration"},
+		{"repeat 2 {\npress a", "missing '}'"},
+		{"}", "line 1: unexpected '}'"},
+		{"click left", "line 1: unknown command"},
+		{`type "é"`, "line 1: unable to type"},
+	}
+	for _, test := range tests {
+		if _, err := ParseScript(test.input, layoutUS); err == nil || !strings.HasPrefix(err.Error(), test.want) {
+			t.Errorf("ParseScript(%q) error = %v; want %q", test.input, err, test.want)
+		}
+	}
+}
+
+func TestScriptRun(t *testing.T) {
+	script, err := ParseScript(`repeat 2 { press a } hold b 1s`, layoutUS)
+	if err != nil {
+		t.Fatalf("ParseScript() error: %v", err)
+	}
+	var got []string
+	script.Run(func(code KeyCode) {
+		got = append(got, code.String())
+	}, func(d time.Duration) {
+		got = append(got, d.String())
+	})
+	want := []string{"A", "NONE", "A", "NONE", "B", "1s", "NONE"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Script.Run() = %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/coder/websocket"
+	"github.com/whoisnian/glb/httpd"
+	"github.com/whoisnian/glb/logger"
+)
+
+const webPageHTML = `<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8" />
+  <meta name="viewport" content="width=device-width, initial-scale=1" />
+  <title>USB HID Keyboard</title>
+</head>
+<body style="font-family:ui-monospace,Menlo,Consolas,Hack,Liberation Mono,Microsoft Yahei,Noto Sans Mono CJK SC,sans-serif;">
+  <h1>USB HID Keyboard</h1>
+  <p>Status: <span id="status">connecting</span></p>
+  <p>Pressed: <span id="pressed"></span></p>
+  <p><button id="lock">Fullscreen and capture system keys</button></p>
+  <p>Keys are forwarded while this page is focused, all keys are released when it loses focus.</p>
+  <script>
+    const status = document.getElementById('status')
+    const pressed = document.getElementById('pressed')
+    const held = new Set()
+    const proto = location.protocol === 'https:' ? 'wss:' : 'ws:'
+    const ws = new WebSocket(proto + '//' + location.host + '/ws')
+    ws.onopen = () => { status.textContent = 'connected' }
+    ws.onclose = (e) => { status.textContent = 'disconnected' + (e.reason ? ': ' + e.reason : '') }
+    const send = (type, code) => {
+      if (ws.readyState === WebSocket.OPEN) ws.send(type + code)
+      pressed.textContent = [...held].join(' + ')
+    }
+    document.addEventListener('keydown', (e) => {
+      e.preventDefault()
+      if (e.repeat) return
+      held.add(e.code)
+      send('0', e.code)
+    })
+    document.addEventListener('keyup', (e) => {
+      e.preventDefault()
+      held.delete(e.code)
+      send('1', e.code)
+    })
+    window.addEventListener('blur', () => {
+      held.clear()
+      send('2', '')
+    })
+    document.getElementById('lock').onclick = async () => {
+      await document.documentElement.requestFullscreen()
+      if (navigator.keyboard && navigator.keyboard.lock) await navigator.keyboard.lock()
+    }
+  </script>
+</body>
+</html>`
+
+const (
+	MSG_TYPE_KEYDOWN = '0'
+	MSG_TYPE_KEYUP   = '1'
+	MSG_TYPE_RESET   = '2'
+)
+
+// webKeyCodes maps the KeyboardEvent.code of browser to HID key, keypad keys are mapped to the main keys.
+// https://developer.mozilla.org/en-US/docs/Web/API/UI_Events/Keyboard_event_code_values
+var webKeyCodes = map[string]Key{
+	"Enter": K_ENTER, "Escape": K_ESC, "Backspace": K_BACKSPACE, "Tab": K_TAB, "Space": K_SPACE,
+	"Minus": K_MINUS, "Equal": K_EQUAL, "BracketLeft": K_LEFTBRACE, "BracketRight": K_RIGHTBRACE, "Backslash": K_BACKSLASH,
+	"IntlBackslash": K_NONUS_BACKSLASH, "Semicolon": K_SEMICOLON, "Quote": K_APOSTROPHE, "Backquote": K_GRAVE,
+	"Comma": K_COMMA, "Period": K_DOT, "Slash": K_SLASH, "CapsLock": K_CAPSLOCK,
+	"PrintScreen": K_PRINTSCREEN, "ScrollLock": K_SCROLLLOCK, "Pause": K_PAUSE,
+	"Insert": K_INSERT, "Home": K_HOME, "PageUp": K_PAGEUP, "Delete": K_DELETE, "End": K_END, "PageDown": K_PAGEDOWN,
+	"ArrowRight": K_RIGHT, "ArrowLeft": K_LEFT, "ArrowDown": K_DOWN, "ArrowUp": K_UP,
+	"ControlLeft": K_L_CTRL, "ShiftLeft": K_L_SHIFT, "AltLeft": K_L_ALT, "MetaLeft": K_L_GUI,
+	"ControlRight": K_R_CTRL, "ShiftRight": K_R_SHIFT, "AltRight": K_R_ALT, "MetaRight": K_R_GUI,
+	"NumpadEnter": K_ENTER, "NumpadDecimal": K_DOT, "NumpadDivide": K_SLASH, "NumpadSubtract": K_MINUS, "NumpadEqual": K_EQUAL,
+}
+
+func init() {
+	for k := K_A; k <= K_Z; k++ {
+		webKeyCodes["Key"+k.String()] = k
+	}
+	for k := K_1; k <= K_0; k++ {
+		webKeyCodes["Digit"+k.String()] = k
+		webKeyCodes["Numpad"+k.String()] = k
+	}
+	for k := K_F1; k <= K_F12; k++ {
+		webKeyCodes[k.String()] = k
+	}
+}
+
+// webKeyboard forwards the browser key events to the key state, and sends the report on every change.
+type webKeyboard struct {
+	state KeyState
+	send  func(Report)
+}
+
+func (kb *webKeyboard) handle(msgType byte, name string) error {
+	if msgType == MSG_TYPE_RESET {
+		if kb.state.ReleaseAll() {
+			kb.send(EmptyReport)
+		}
+		return nil
+	}
+	k, ok := webKeyCodes[name]
+	if !ok {
+		return fmt.Errorf("unknown key code %q", name)
+	}
+
+	var r Report
+	var changed bool
+	switch msgType {
+	case MSG_TYPE_KEYDOWN:
+		r, changed = kb.state.Press(k)
+	case MSG_TYPE_KEYUP:
+		r, changed = kb.state.Release(k)
+	default:
+		return fmt.Errorf("unknown message type %q", msgType)
+	}
+	if changed {
+		kb.send(r)
+	}
+	return nil
+}
+
+// runHTTPServer serves the KVM page and forwards the browser key events from websocket, options is in the form of `[user:pass@]addr`.
+func runHTTPServer(ctx context.Context, options string, send func(Report)) error {
+	parsed, err := url.Parse("http://" + options)
+	if err != nil {
+		return fmt.Errorf("url.Parse error: %w", err)
+	}
+	auth := parsed.User.String()
+	addr := parsed.Host
+
+	mux := httpd.NewMux()
+	mux.HandleMiddleware(LOG.NewMiddleware())
+	mux.Handle("/", http.MethodGet, authRequire(func(s *httpd.Store) {
+		s.W.Header().Set("content-type", "text/html; charset=utf-8")
+		s.Respond200([]byte(webPageHTML))
+	}, auth))
+	mux.Handle("/ws", http.MethodGet, authRequire(webSocketHandlerWith(send), auth))
+
+	LOG.Infof(ctx, "http server listening on %s", addr)
+	server := &http.Server{Addr: addr, Handler: mux}
+	if err := server.ListenAndServe(); errors.Is(err, http.ErrServerClosed) {
+		LOG.Warn(ctx, "server is shutting down")
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func authRequire(handler httpd.HandlerFunc, userinfo string) httpd.HandlerFunc {
+	if userinfo == "" {
+		return handler
+	}
+	b64str := base64.StdEncoding.EncodeToString([]byte(userinfo))
+	return func(store *httpd.Store) {
+		if store.R.Header.Get("Authorization") != "Basic "+b64str {
+			store.W.Header().Add("WWW-Authenticate", `Basic realm="usb hid keyboard"`)
+			store.W.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler(store)
+	}
+}
+
+// Only one client can control the keyboard at a time, others will be rejected until it disconnects.
+func webSocketHandlerWith(send func(Report)) func(store *httpd.Store) {
+	var controlling sync.Mutex
+	return func(store *httpd.Store) {
+		if !controlling.TryLock() {
+			LOG.Warnf(store.R.Context(), "reject client %s, another client is controlling", store.R.RemoteAddr)
+			store.W.WriteHeader(http.StatusConflict)
+			return
+		}
+		defer controlling.Unlock()
+
+		conn, err := websocket.Accept(store.W, store.R, nil)
+		if err != nil {
+			LOG.Error(store.R.Context(), "websocket.Accept failed", logger.Error(err))
+			store.W.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		defer conn.CloseNow()
+		LOG.Infof(store.R.Context(), "client %s connected", store.R.RemoteAddr)
+
+		kb := &webKeyboard{send: send}
+		defer kb.handle(MSG_TYPE_RESET, "") // release all keys if client disconnects with keys held
+		for {
+			_, data, err := conn.Read(store.R.Context())
+			if err != nil && (websocket.CloseStatus(err) == websocket.StatusNormalClosure || websocket.CloseStatus(err) == websocket.StatusGoingAway) {
+				LOG.Infof(store.R.Context(), "client %s disconnected", store.R.RemoteAddr)
+				return
+			} else if err != nil {
+				LOG.Error(store.R.Context(), "websocket.Read failed", logger.Error(err))
+				return
+			} else if len(data) == 0 {
+				continue
+			}
+			if err := kb.handle(data[0], string(data[1:])); err != nil {
+				LOG.Warnf(store.R.Context(), "ignore message from client: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWebKeyboardHandle(t *testing.T) {
+	var got []Report
+	kb := &webKeyboard{send: func(r Report) { got = append(got, r) }}
+	for _, msg := range []string{"0KeyA", "0ShiftLeft", "0KeyA", "1KeyA", "0F5", "0KeyB", "2", "2"} {
+		if err := kb.handle(msg[0], msg[1:]); err != nil {
+			t.Fatalf("handle(%q) error: %v", msg, err)
+		}
+	}
+	want := []Report{
+		{Keys: [6]Key{K_A}},
+		{Modifier: 0x02, Keys: [6]Key{K_A}},
+		{Modifier: 0x02},
+		{Modifier: 0x02, Keys: [6]Key{K_F5}},
+		{Modifier: 0x02, Keys: [6]Key{K_F5, K_B}},
+		EmptyReport,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("handle() sent %v; want %v", got, want)
+	}
+	if err := kb.handle(MSG_TYPE_KEYDOWN, "Unknown"); err == nil {
+		t.Errorf("handle(Unknown) = nil; want error")
+	}
+}
@@ -85,17 +85,18 @@ func (p *Port) Push(data []byte) {
 	p.buf <- data
 }
 
+// GoWaitAndSend starts a goroutine to send pushed data with interval.
+// The returned stop function waits until all pushed data have been sent.
 func (p *Port) GoWaitAndSend() (stop func()) {
-	p.wg.Add(1)
-	go func() {
+	p.wg.Go(func() {
 		for data := range p.buf {
 			p.Write(data)
 			time.Sleep(p.interval)
 		}
-	}()
+	})
 
 	return func() {
 		close(p.buf)
-		p.wg.Done()
+		p.wg.Wait()
 	}
 }